@@ -7,11 +7,16 @@ import (
 	"io"
 	"log"
 	"os"
+	"path"
 	"path/filepath"
 	"runtime"
+	"sort"
 	"strconv"
+	"strings"
 	"text/template"
 	"time"
+
+	"github.com/yingce/drone-oss-cache/lib/cache/archive/ignore"
 )
 
 type MetaData map[string]interface{}
@@ -71,9 +76,91 @@ var funcMap = template.FuncMap{
 		}
 		return str
 	},
-	"epoch": func() string { return strconv.FormatInt(time.Now().Unix(), 10) },
-	"arch":  func() string { return runtime.GOARCH },
-	"os":    func() string { return runtime.GOOS },
+	"hashFiles": hashFiles,
+	"epoch":     func() string { return strconv.FormatInt(time.Now().Unix(), 10) },
+	"arch":      func() string { return runtime.GOARCH },
+	"os":        func() string { return runtime.GOOS },
+}
+
+// hashFiles hashes the content of all files matching the glob patterns, **
+// matches any number of directories. A pattern without matches is an error
+// so the key never silently stops depending on the files.
+func hashFiles(patterns ...string) (string, error) {
+	var files []string
+	for _, pattern := range patterns {
+		matches, err := globFiles(pattern)
+		if err != nil {
+			return "", err
+		}
+
+		if len(matches) == 0 {
+			return "", fmt.Errorf("No files match %s", pattern)
+		}
+		files = append(files, matches...)
+	}
+
+	sort.Strings(files)
+
+	var readers []io.Reader
+	for _, file := range files {
+		f, err := os.Open(file)
+		if err != nil {
+			return "", err
+		}
+		defer f.Close()
+
+		readers = append(readers, f)
+	}
+
+	return readerHasher(readers...)
+}
+
+// globFiles returns the regular files matching the pattern. The directory in
+// front of the first wildcard is walked, the rest of the pattern is matched
+// like a .cacheignore pattern anchored at that directory.
+func globFiles(pattern string) ([]string, error) {
+	pattern = filepath.ToSlash(pattern)
+
+	root, rest := ".", pattern
+	if i := strings.IndexAny(pattern, "*?[\\"); i >= 0 {
+		if j := strings.LastIndex(pattern[:i], "/"); j >= 0 {
+			root, rest = pattern[:j+1], pattern[j+1:]
+		}
+	} else {
+		root, rest = path.Dir(pattern), path.Base(pattern)
+	}
+
+	m, err := ignore.New([]string{"/" + rest})
+	if err != nil {
+		return nil, err
+	}
+
+	var files []string
+	err = filepath.Walk(filepath.FromSlash(root), func(p string, fi os.FileInfo, err error) error {
+		if err != nil {
+			if os.IsNotExist(err) && p == filepath.FromSlash(root) {
+				return filepath.SkipDir
+			}
+			return err
+		}
+
+		if !fi.Mode().IsRegular() {
+			return nil
+		}
+
+		rel, err := filepath.Rel(filepath.FromSlash(root), p)
+		if err != nil {
+			return err
+		}
+
+		if m.Match(filepath.ToSlash(rel), false) {
+			files = append(files, p)
+		}
+
+		return nil
+	})
+
+	return files, err
 }
 
 func readerLineHasher(startLine, endLine int, readers ...io.Reader) (string, error) {
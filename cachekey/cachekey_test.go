@@ -0,0 +1,70 @@
+package cachekey
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/franela/goblin"
+)
+
+func TestCacheKey(t *testing.T) {
+	g := goblin.Goblin(t)
+	wd, _ := os.Getwd()
+
+	g.Describe("hashFiles", func() {
+		var dir string
+
+		g.BeforeEach(func() {
+			dir, _ = ioutil.TempDir("", "cachekey")
+			os.Chdir(dir)
+
+			for _, name := range []string{"go.sum", "a/go.sum", "a/b/go.sum", "a/b/go.mod"} {
+				os.MkdirAll(filepath.Dir(name), 0755)
+				ioutil.WriteFile(name, []byte(name), 0644)
+			}
+		})
+
+		g.AfterEach(func() {
+			os.Chdir(wd)
+			os.RemoveAll(dir)
+		})
+
+		g.It("Should match files in any directory", func() {
+			files, err := globFiles("**/go.sum")
+			g.Assert(err == nil).IsTrue("failed to glob")
+			g.Assert(files).Equal([]string{"a/b/go.sum", "a/go.sum", "go.sum"})
+
+			files, _ = globFiles("a/**/go.*")
+			g.Assert(files).Equal([]string{"a/b/go.mod", "a/b/go.sum", "a/go.sum"})
+		})
+
+		g.It("Should match files in one directory", func() {
+			files, _ := globFiles("go.sum")
+			g.Assert(files).Equal([]string{"go.sum"})
+
+			files, _ = globFiles("a/*/go.sum")
+			g.Assert(files).Equal([]string{"a/b/go.sum"})
+		})
+
+		g.It("Should fail without matches", func() {
+			_, err := hashFiles("go.sum", "**/package-lock.json")
+			g.Assert(err != nil).IsTrue("expected a no match error")
+
+			_, err = CacheKey(`{{ hashFiles "missing/*.lock" }}`, MetaData{})
+			g.Assert(err != nil).IsTrue("expected a template error")
+		})
+
+		g.It("Should hash the content of the files", func() {
+			key, err := CacheKey(`{{ hashFiles "**/go.sum" }}`, MetaData{})
+			g.Assert(err == nil).IsTrue("failed to hash")
+			g.Assert(len(key)).Equal(32)
+
+			ioutil.WriteFile("a/b/go.sum", []byte("changed"), 0644)
+
+			changed, _ := CacheKey(`{{ hashFiles "**/go.sum" }}`, MetaData{})
+			g.Assert(changed != key).IsTrue("expected a new key")
+		})
+	})
+}
@@ -12,3 +12,22 @@ type Archive interface {
 	// Unpack reads the archive and restores it to the destination
 	Unpack(dst string, r io.Reader) error
 }
+
+// Options contains the settings shared by the archive formats.
+type Options struct {
+	// PreserveOwner restores the uid and gid of each entry. It only takes
+	// effect when unpacking as root.
+	PreserveOwner bool
+
+	// Xattrs captures extended attributes when packing and restores them
+	// when unpacking.
+	Xattrs bool
+
+	// Exclude contains gitignore-style patterns of paths to leave out when
+	// packing, relative to each source.
+	Exclude []string
+
+	// Include contains patterns that re-include paths matched by Exclude or
+	// a .cacheignore file.
+	Include []string
+}
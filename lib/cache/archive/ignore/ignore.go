@@ -0,0 +1,160 @@
+package ignore
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"regexp"
+	"strings"
+)
+
+// Filename is the name of the file that holds the ignore patterns of a mount.
+const Filename = ".cacheignore"
+
+type pattern struct {
+	re      *regexp.Regexp
+	negate  bool
+	dirOnly bool
+}
+
+// Matcher decides which paths are excluded using gitignore-style patterns.
+type Matcher struct {
+	patterns []pattern
+}
+
+// New creates a matcher from the given patterns. The last pattern that
+// matches a path decides whether it is excluded, patterns starting with an
+// exclamation mark include the path again.
+func New(patterns []string) (*Matcher, error) {
+	m := &Matcher{}
+
+	for _, p := range patterns {
+		p = strings.TrimSpace(p)
+
+		// Skip empty lines and comments
+		if len(p) == 0 || strings.HasPrefix(p, "#") {
+			continue
+		}
+
+		compiled, err := compile(p)
+		if err != nil {
+			return nil, fmt.Errorf("Invalid pattern %s: %s", p, err)
+		}
+
+		m.patterns = append(m.patterns, compiled)
+	}
+
+	return m, nil
+}
+
+// ReadFile reads the patterns from the file at path, one per line. A missing
+// file contains no patterns.
+func ReadFile(path string) ([]string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+
+		return nil, err
+	}
+	defer f.Close()
+
+	var patterns []string
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		patterns = append(patterns, scanner.Text())
+	}
+
+	return patterns, scanner.Err()
+}
+
+// Empty reports whether the matcher has no patterns.
+func (m *Matcher) Empty() bool {
+	return len(m.patterns) == 0
+}
+
+// Match reports whether the slash separated path, relative to the mount, is
+// excluded.
+func (m *Matcher) Match(path string, isDir bool) bool {
+	var excluded bool
+
+	for _, p := range m.patterns {
+		if p.dirOnly && !isDir {
+			continue
+		}
+
+		if p.re.MatchString(path) {
+			excluded = !p.negate
+		}
+	}
+
+	return excluded
+}
+
+func compile(p string) (pattern, error) {
+	var compiled pattern
+
+	if strings.HasPrefix(p, "!") {
+		compiled.negate = true
+		p = p[1:]
+	}
+
+	if strings.HasSuffix(p, "/") {
+		compiled.dirOnly = true
+		p = strings.TrimSuffix(p, "/")
+	}
+
+	// Patterns without a slash match at any depth, the others are relative
+	// to the root of the mount
+	var expr strings.Builder
+	expr.WriteString("^")
+	if !strings.Contains(p, "/") {
+		expr.WriteString("(?:.*/)?")
+	}
+	p = strings.TrimPrefix(p, "/")
+
+	for i := 0; i < len(p); i++ {
+		switch c := p[i]; {
+		case strings.HasPrefix(p[i:], "**/"):
+			expr.WriteString("(?:.*/)?")
+			i += 2
+		case p[i:] == "/**":
+			// Also match the directory itself so it can be skipped
+			expr.WriteString("(?:/.*)?")
+			i += 2
+		case strings.HasPrefix(p[i:], "**"):
+			expr.WriteString(".*")
+			i++
+		case c == '*':
+			expr.WriteString("[^/]*")
+		case c == '?':
+			expr.WriteString("[^/]")
+		case c == '[':
+			end := strings.IndexByte(p[i:], ']')
+			if end == -1 {
+				return compiled, fmt.Errorf("missing closing bracket")
+			}
+			class := p[i : i+end+1]
+			if strings.HasPrefix(class, "[!") {
+				class = "[^" + class[2:]
+			}
+			expr.WriteString(class)
+			i += end
+		case c == '\\' && i+1 < len(p):
+			i++
+			expr.WriteString(regexp.QuoteMeta(string(p[i])))
+		default:
+			expr.WriteString(regexp.QuoteMeta(string(c)))
+		}
+	}
+	expr.WriteString("$")
+
+	re, err := regexp.Compile(expr.String())
+	if err != nil {
+		return compiled, err
+	}
+	compiled.re = re
+
+	return compiled, nil
+}
@@ -0,0 +1,77 @@
+package ignore
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"github.com/franela/goblin"
+)
+
+func TestIgnore(t *testing.T) {
+	g := goblin.Goblin(t)
+
+	g.Describe("Match", func() {
+		g.It("Should match names at any depth", func() {
+			m, err := New([]string{".cache/"})
+			g.Assert(err == nil).IsTrue("failed to create matcher")
+
+			g.Assert(m.Match(".cache", true)).IsTrue("failed to match .cache")
+			g.Assert(m.Match("pkg/.cache", true)).IsTrue("failed to match pkg/.cache")
+			g.Assert(m.Match("pkg/.cache", false)).IsFalse("matched file for directory pattern")
+		})
+
+		g.It("Should anchor patterns with a slash", func() {
+			m, err := New([]string{"/build", "docs/*.md"})
+			g.Assert(err == nil).IsTrue("failed to create matcher")
+
+			g.Assert(m.Match("build", true)).IsTrue("failed to match build")
+			g.Assert(m.Match("sub/build", true)).IsFalse("matched nested build")
+			g.Assert(m.Match("docs/README.md", false)).IsTrue("failed to match docs/README.md")
+			g.Assert(m.Match("docs/sub/README.md", false)).IsFalse("matched nested docs/sub/README.md")
+		})
+
+		g.It("Should match double stars", func() {
+			m, err := New([]string{"**/*-SNAPSHOT/**"})
+			g.Assert(err == nil).IsTrue("failed to create matcher")
+
+			g.Assert(m.Match("org/foo/1.0-SNAPSHOT", true)).IsTrue("failed to match snapshot directory")
+			g.Assert(m.Match("org/foo/1.0-SNAPSHOT/foo.jar", false)).IsTrue("failed to match snapshot file")
+			g.Assert(m.Match("org/foo/1.0/foo.jar", false)).IsFalse("matched release file")
+		})
+
+		g.It("Should re-include negated patterns", func() {
+			m, err := New([]string{"*.log", "# comment", "", "!keep.log"})
+			g.Assert(err == nil).IsTrue("failed to create matcher")
+
+			g.Assert(m.Match("debug.log", false)).IsTrue("failed to match debug.log")
+			g.Assert(m.Match("keep.log", false)).IsFalse("failed to re-include keep.log")
+		})
+
+		g.It("Should return error on invalid pattern", func() {
+			_, err := New([]string{"[abc"})
+			g.Assert(err != nil).IsTrue("failed to return error")
+		})
+	})
+
+	g.Describe("ReadFile", func() {
+		g.It("Should read patterns", func() {
+			f, err := ioutil.TempFile("", "cacheignore")
+			g.Assert(err == nil).IsTrue("failed to create file")
+			defer os.Remove(f.Name())
+
+			f.WriteString("node_modules/.cache/\n*.tmp\n")
+			f.Close()
+
+			patterns, err := ReadFile(f.Name())
+			g.Assert(err == nil).IsTrue("failed to read file")
+			g.Assert(patterns).Equal([]string{"node_modules/.cache/", "*.tmp"})
+		})
+
+		g.It("Should return nothing for missing file", func() {
+			patterns, err := ReadFile("/tmp/does-not-exist/.cacheignore")
+			g.Assert(err == nil).IsTrue("failed to ignore missing file")
+			g.Assert(len(patterns)).Equal(0)
+		})
+	})
+}
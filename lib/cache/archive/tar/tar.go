@@ -13,15 +13,27 @@ import (
 
 	log "github.com/sirupsen/logrus"
 	"github.com/yingce/drone-oss-cache/lib/cache/archive"
+	"github.com/yingce/drone-oss-cache/lib/cache/archive/ignore"
 )
 
-type tarArchive struct{}
+// xattrPrefix is the PAX record prefix used for extended attributes.
+const xattrPrefix = "SCHILY.xattr."
+
+type tarArchive struct {
+	opts archive.Options
+}
 
 // New creates an archive that uses the .tar file format.
 func New() archive.Archive {
 	return &tarArchive{}
 }
 
+// NewWithOptions creates an archive that uses the .tar file format with the
+// given options.
+func NewWithOptions(opts archive.Options) archive.Archive {
+	return &tarArchive{opts: opts}
+}
+
 func (a *tarArchive) Pack(srcs []string, w io.Writer) error {
 	tw := tar.NewWriter(w)
 	defer tw.Close()
@@ -30,7 +42,13 @@ func (a *tarArchive) Pack(srcs []string, w io.Writer) error {
 	var fwErr error
 	for _, s := range srcs {
 		// ensure the src actually exists before trying to tar it
-		if _, err := os.Stat(s); err != nil {
+		info, err := os.Stat(s)
+		if err != nil {
+			return err
+		}
+
+		matcher, err := a.matcher(s, info.IsDir())
+		if err != nil {
 			return err
 		}
 
@@ -40,6 +58,16 @@ func (a *tarArchive) Pack(srcs []string, w io.Writer) error {
 				return err
 			}
 
+			if rel, _ := filepath.Rel(s, path); rel != "." && matcher.Match(filepath.ToSlash(rel), fi.IsDir()) {
+				if fi.IsDir() {
+					log.Debugf("Skipping excluded directory %s", path)
+					return filepath.SkipDir
+				}
+
+				log.Debugf("Skipping excluded file %s", path)
+				return nil
+			}
+
 			header, err := tar.FileInfoHeader(fi, fi.Name())
 			if err != nil {
 				return err
@@ -61,6 +89,23 @@ func (a *tarArchive) Pack(srcs []string, w io.Writer) error {
 
 			header.Name = strings.TrimPrefix(filepath.ToSlash(path), "/")
 
+			// PAX keeps sub-second mtimes and the access time
+			header.Format = tar.FormatPAX
+
+			if a.opts.Xattrs && fi.Mode()&os.ModeSymlink == 0 {
+				xattrs, err := readXattrs(path)
+				if err != nil {
+					return err
+				}
+
+				for name, value := range xattrs {
+					if header.PAXRecords == nil {
+						header.PAXRecords = make(map[string]string)
+					}
+					header.PAXRecords[xattrPrefix+name] = value
+				}
+			}
+
 			if err = tw.WriteHeader(header); err != nil {
 				return err
 			}
@@ -90,16 +135,46 @@ func (a *tarArchive) Pack(srcs []string, w io.Writer) error {
 	return fwErr
 }
 
+// matcher builds the exclude patterns for a source from the options and the
+// .cacheignore file at its root. Include patterns come last so they can
+// re-include excluded paths.
+func (a *tarArchive) matcher(src string, isDir bool) (*ignore.Matcher, error) {
+	patterns := append([]string{}, a.opts.Exclude...)
+
+	if isDir {
+		lines, err := ignore.ReadFile(filepath.Join(src, ignore.Filename))
+		if err != nil {
+			return nil, err
+		}
+		patterns = append(patterns, lines...)
+	}
+
+	for _, include := range a.opts.Include {
+		patterns = append(patterns, "!"+include)
+	}
+
+	return ignore.New(patterns)
+}
+
 func (a *tarArchive) Unpack(dst string, r io.Reader) error {
 	tr := tar.NewReader(r)
 
+	// directory times are restored last as creating their content changes them
+	var dirs []*tar.Header
+
 	for {
 		header, err := tr.Next()
 
 		switch {
 
-		// if no more files are found return
+		// if no more files are found restore the directory times and return
 		case err == io.EOF:
+			for i := len(dirs) - 1; i >= 0; i-- {
+				if err := restoreTimes(filepath.Join(dst, dirs[i].Name), dirs[i]); err != nil {
+					return err
+				}
+			}
+
 			return nil
 
 		// return any other error
@@ -140,6 +215,10 @@ func (a *tarArchive) Unpack(dst string, r io.Reader) error {
 				return err
 			}
 
+			if err := a.restoreOwner(target, header); err != nil {
+				return err
+			}
+
 		// if its a dir and it doesn't exist create it
 		case tar.TypeDir:
 			log.Debugf("Directory found at %s", target)
@@ -149,6 +228,12 @@ func (a *tarArchive) Unpack(dst string, r io.Reader) error {
 				}
 			}
 
+			if err := a.restoreMetadata(target, header); err != nil {
+				return err
+			}
+
+			dirs = append(dirs, header)
+
 		// if it's a file create it
 		case tar.TypeReg:
 			log.Debugf("File found at %s", target)
@@ -166,6 +251,62 @@ func (a *tarArchive) Unpack(dst string, r io.Reader) error {
 			if err != nil {
 				return err
 			}
+
+			if err := a.restoreMetadata(target, header); err != nil {
+				return err
+			}
+
+			if err := restoreTimes(target, header); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+// restoreMetadata restores the ownership and extended attributes of a file or
+// directory when enabled.
+func (a *tarArchive) restoreMetadata(target string, header *tar.Header) error {
+	if err := a.restoreOwner(target, header); err != nil {
+		return err
+	}
+
+	if !a.opts.Xattrs {
+		return nil
+	}
+
+	for key, value := range header.PAXRecords {
+		if !strings.HasPrefix(key, xattrPrefix) {
+			continue
+		}
+
+		name := strings.TrimPrefix(key, xattrPrefix)
+		log.Debugf("Setting extended attribute %s on %s", name, target)
+
+		if err := writeXattr(target, name, value); err != nil {
+			return err
 		}
 	}
+
+	return nil
+}
+
+// restoreOwner changes the uid and gid of the target. Only root is allowed to
+// do so, therefore it is skipped for everyone else.
+func (a *tarArchive) restoreOwner(target string, header *tar.Header) error {
+	if !a.opts.PreserveOwner || os.Geteuid() != 0 {
+		return nil
+	}
+
+	return os.Lchown(target, header.Uid, header.Gid)
+}
+
+// restoreTimes sets the access and modification times of the target. Archives
+// without an access time get the modification time for both.
+func restoreTimes(target string, header *tar.Header) error {
+	atime := header.AccessTime
+	if atime.IsZero() {
+		atime = header.ModTime
+	}
+
+	return os.Chtimes(target, atime, header.ModTime)
 }
@@ -1,12 +1,15 @@
 package tar
 
 import (
+	archivetar "archive/tar"
+	"bytes"
 	"fmt"
 	"io"
 	"io/ioutil"
 	"log"
 	"os"
 	"testing"
+	"time"
 
 	"github.com/franela/goblin"
 	"github.com/yingce/drone-oss-cache/lib/cache/archive"
@@ -68,6 +71,17 @@ func TestTarArchive(t *testing.T) {
 				g.Assert(werr != nil).IsTrue("Failed to properly stat 'mount'")
 				g.Assert(werr.Error()).Equal("stat mount1: no such file or directory")
 			})
+
+			g.It("Should skip excluded paths", func() {
+				ta := NewWithOptions(archive.Options{Exclude: []string{"test2.txt", "*.md"}, Include: []string{"keep.md"}})
+
+				os.Chdir("/tmp/fixtures/mounts")
+				names, err := listIt(ta, []string{"test.txt", "subdir", "docs"})
+				os.Chdir(wd)
+
+				g.Assert(err == nil).IsTrue("Failed to pack")
+				g.Assert(names).Equal([]string{"test.txt", "subdir", "subdir/linkto_test.txt", "docs", "docs/keep.md"})
+			})
 		})
 
 		g.Describe("Unpack", func() {
@@ -104,6 +118,14 @@ func TestTarArchive(t *testing.T) {
 				g.Assert(string(content)).Equal("hello\ngo\n")
 			})
 
+			g.It("Should restore modification times", func() {
+				for _, name := range []string{"test.txt", "subdir"} {
+					fi, err := os.Stat("/tmp/extracted/" + name)
+					g.Assert(err == nil).IsTrue("failed to stat " + name)
+					g.Assert(fi.ModTime().Equal(mountTime)).IsTrue("failed to restore mtime of " + name)
+				}
+			})
+
 			g.It("Should return error on invalid tarfile", func() {
 				ta := New()
 				g.Assert(ta != nil).IsTrue("failed to create tarArchive")
@@ -148,6 +170,26 @@ func packIt(a archive.Archive, srcs []string, dst string) (error, error) {
 	return err, werr
 }
 
+func listIt(a archive.Archive, srcs []string) ([]string, error) {
+	var buf bytes.Buffer
+	if err := a.Pack(srcs, &buf); err != nil {
+		return nil, err
+	}
+
+	var names []string
+	tr := archivetar.NewReader(&buf)
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			return names, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		names = append(names, header.Name)
+	}
+}
+
 func unpackIt(a archive.Archive, src string) error {
 	reader, writer := io.Pipe()
 
@@ -185,7 +227,7 @@ func createBadTarfile() {
 func createMountContent() {
 	// Write files and their content
 	var err error
-	for _, element := range mountFiles {
+	for _, element := range append(mountFiles, ignoreFiles...) {
 		err = ioutil.WriteFile("/tmp/fixtures/mounts/"+element.Path, []byte(element.Content), 0644)
 		if err != nil {
 			log.Fatalln(err)
@@ -194,6 +236,14 @@ func createMountContent() {
 
 	// Create a symlink
 	os.Symlink("../test.txt", "/tmp/fixtures/mounts/subdir/linkto_test.txt")
+
+	// Set a fixed time to check it is restored
+	for _, name := range []string{"test.txt", "subdir"} {
+		err = os.Chtimes("/tmp/fixtures/mounts/"+name, mountTime, mountTime)
+		if err != nil {
+			log.Fatalln(err)
+		}
+	}
 }
 
 func createFixtures() {
@@ -211,6 +261,7 @@ func createDirectories() {
 	directories := []string{
 		"/tmp/fixtures/tarfiles",
 		"/tmp/fixtures/mounts/subdir",
+		"/tmp/fixtures/mounts/docs",
 		"/tmp/extracted",
 	}
 
@@ -243,6 +294,13 @@ var (
 		{Path: "subdir/test2.txt", Content: "hello2\ngo\n"},
 	}
 
+	ignoreFiles = []mountFile{
+		{Path: "docs/README.md", Content: "readme\n"},
+		{Path: "docs/keep.md", Content: "keep\n"},
+	}
+
+	mountTime = time.Date(2019, time.March, 14, 15, 9, 26, 535897000, time.UTC)
+
 	validMount = []string{
 		"test.txt",
 		"subdir",
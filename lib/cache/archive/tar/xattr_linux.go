@@ -0,0 +1,64 @@
+package tar
+
+import (
+	"bytes"
+	"syscall"
+)
+
+// readXattrs returns the extended attributes of the file at path.
+func readXattrs(path string) (map[string]string, error) {
+	size, err := syscall.Listxattr(path, nil)
+	if err != nil {
+		if err == syscall.ENOTSUP {
+			return nil, nil
+		}
+
+		return nil, err
+	}
+
+	if size == 0 {
+		return nil, nil
+	}
+
+	buf := make([]byte, size)
+	size, err = syscall.Listxattr(path, buf)
+	if err != nil {
+		return nil, err
+	}
+
+	xattrs := make(map[string]string)
+	for _, name := range bytes.Split(buf[:size], []byte{0}) {
+		if len(name) == 0 {
+			continue
+		}
+
+		value, err := readXattr(path, string(name))
+		if err != nil {
+			return nil, err
+		}
+
+		xattrs[string(name)] = value
+	}
+
+	return xattrs, nil
+}
+
+func readXattr(path, name string) (string, error) {
+	size, err := syscall.Getxattr(path, name, nil)
+	if err != nil {
+		return "", err
+	}
+
+	buf := make([]byte, size)
+	size, err = syscall.Getxattr(path, name, buf)
+	if err != nil {
+		return "", err
+	}
+
+	return string(buf[:size]), nil
+}
+
+// writeXattr sets the extended attribute name on the file at path.
+func writeXattr(path, name, value string) error {
+	return syscall.Setxattr(path, name, []byte(value), 0)
+}
@@ -0,0 +1,19 @@
+//go:build !linux
+// +build !linux
+
+package tar
+
+import (
+	"fmt"
+	"runtime"
+)
+
+// readXattrs is not supported on this platform.
+func readXattrs(path string) (map[string]string, error) {
+	return nil, fmt.Errorf("Extended attributes are not supported on %s", runtime.GOOS)
+}
+
+// writeXattr is not supported on this platform.
+func writeXattr(path, name, value string) error {
+	return fmt.Errorf("Extended attributes are not supported on %s", runtime.GOOS)
+}
@@ -11,18 +11,26 @@ import (
 	"github.com/yingce/drone-oss-cache/lib/cache/archive/tar"
 )
 
-type tgzArchive struct{}
+type tgzArchive struct {
+	opts archive.Options
+}
 
 // New creates an archive that uses the .tar.gz file format.
 func New() archive.Archive {
 	return &tgzArchive{}
 }
 
+// NewWithOptions creates an archive that uses the .tar.gz file format with the
+// given options.
+func NewWithOptions(opts archive.Options) archive.Archive {
+	return &tgzArchive{opts: opts}
+}
+
 func (a *tgzArchive) Pack(srcs []string, w io.Writer) error {
 	gw := gzip.NewWriter(w)
 	defer gw.Close()
 
-	taP := tar.New()
+	taP := tar.NewWithOptions(a.opts)
 
 	err := taP.Pack(srcs, gw)
 
@@ -36,7 +44,7 @@ func (a *tgzArchive) Unpack(dst string, r io.Reader) error {
 		return err
 	}
 
-	taU := tar.New()
+	taU := tar.NewWithOptions(a.opts)
 
 	fwErr := taU.Unpack(dst, gr)
 
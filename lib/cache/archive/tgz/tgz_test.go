@@ -7,6 +7,7 @@ import (
 	"log"
 	"os"
 	"testing"
+	"time"
 
 	"github.com/franela/goblin"
 	"github.com/yingce/drone-oss-cache/lib/cache/archive"
@@ -104,6 +105,14 @@ func TestTgzArchive(t *testing.T) {
 				g.Assert(string(content)).Equal("hello\ngo\n")
 			})
 
+			g.It("Should restore modification times", func() {
+				for _, name := range []string{"test.txt", "subdir"} {
+					fi, err := os.Stat("/tmp/extracted/" + name)
+					g.Assert(err == nil).IsTrue("failed to stat " + name)
+					g.Assert(fi.ModTime().Equal(mountTime)).IsTrue("failed to restore mtime of " + name)
+				}
+			})
+
 			g.It("Should return error on invalid tarfile", func() {
 				tga := New()
 				g.Assert(tga != nil).IsTrue("failed to create tgzArchive")
@@ -194,6 +203,14 @@ func createMountContent() {
 
 	// Create a symlink
 	os.Symlink("../test.txt", "/tmp/fixtures/mounts/subdir/linkto_test.txt")
+
+	// Set a fixed time to check it is restored
+	for _, name := range []string{"test.txt", "subdir"} {
+		err = os.Chtimes("/tmp/fixtures/mounts/"+name, mountTime, mountTime)
+		if err != nil {
+			log.Fatalln(err)
+		}
+	}
 }
 
 func createFixtures() {
@@ -243,6 +260,8 @@ var (
 		{Path: "subdir/test2.txt", Content: "hello2\ngo\n"},
 	}
 
+	mountTime = time.Date(2019, time.March, 14, 15, 9, 26, 535897000, time.UTC)
+
 	validMount = []string{
 		"test.txt",
 		"subdir",
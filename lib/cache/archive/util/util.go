@@ -11,12 +11,18 @@ import (
 
 // FromFilename determines the archive format to use based on the name.
 func FromFilename(name string) (archive.Archive, error) {
+	return FromFilenameWithOptions(name, archive.Options{})
+}
+
+// FromFilenameWithOptions determines the archive format to use based on the
+// name and configures it with the given options.
+func FromFilenameWithOptions(name string, opts archive.Options) (archive.Archive, error) {
 	if strings.HasSuffix(name, ".tar") {
-		return tar.New(), nil
+		return tar.NewWithOptions(opts), nil
 	}
 
 	if strings.HasSuffix(name, ".tgz") || strings.HasSuffix(name, ".tar.gz") {
-		return tgz.New(), nil
+		return tgz.NewWithOptions(opts), nil
 	}
 
 	return nil, fmt.Errorf("Unknown file format for archive %s", name)
@@ -1,7 +1,9 @@
 package cache
 
 import (
+	"errors"
 	"io"
+	"time"
 
 	log "github.com/sirupsen/logrus"
 	"github.com/yingce/drone-oss-cache/lib/cache/archive"
@@ -9,6 +11,27 @@ import (
 	"github.com/yingce/drone-oss-cache/lib/cache/storage"
 )
 
+const (
+	// HitExact means the cache was restored from the requested path.
+	HitExact = "exact"
+	// HitFallback means the cache was restored from the fallback path.
+	HitFallback = "fallback"
+	// HitMiss means the cache could not be restored.
+	HitMiss = "miss"
+)
+
+// Result describes the outcome of restoring the cache.
+type Result struct {
+	// Hit is one of HitExact, HitFallback or HitMiss.
+	Hit string
+	// Path is where the cache was restored from, empty on a miss.
+	Path string
+	// Size is the number of archive bytes read from the storage.
+	Size int64
+	// Duration is how long restoring took including any fallback.
+	Duration time.Duration
+}
+
 // Cache defines a basic cache object.
 type Cache struct {
 	s storage.Storage
@@ -33,42 +56,95 @@ func (c Cache) Rebuild(srcs []string, dst string) error {
 
 // Restore restores the existing cache.
 func (c Cache) Restore(src string, fallback string) error {
-	err := restoreCache(src, c.s, c.a)
-
-	if err != nil && fallback != "" && fallback != src {
-		log.Warnf("Failed to retrieve %s, trying %s", src, fallback)
-		err = restoreCache(fallback, c.s, c.a)
-	}
+	_, err := c.RestoreResult(src, fallback)
 
 	// Cache plugin should print an error but it should not return it
 	// this is so the build continues even if the cache cant be restored
-	if err != nil {
-		log.Warnf("Cache could not be restored %s", err)
+	if IsNotFound(err) {
+		log.Warnf("Cache not found %s", err)
+	} else if err != nil {
+		log.Errorf("Cache could not be restored %s", err)
 	}
 
 	return nil
 }
 
-func restoreCache(src string, s storage.Storage, a archive.Archive) error {
+// RestoreResult restores the existing cache and reports where it was found.
+// Unlike Restore it returns the error when the cache could not be restored.
+func (c Cache) RestoreResult(src string, fallback string) (Result, error) {
+	start := time.Now()
+
+	size, err := restoreCache(src, c.s, c.a)
+
+	if err == nil {
+		return Result{Hit: HitExact, Path: src, Size: size, Duration: time.Since(start)}, nil
+	}
+
+	if fallback != "" && fallback != src {
+		log.Warnf("Failed to retrieve %s, trying %s", src, fallback)
+		size, ferr := restoreCache(fallback, c.s, c.a)
+
+		if ferr == nil {
+			return Result{Hit: HitFallback, Path: fallback, Size: size, Duration: time.Since(start)}, nil
+		}
+
+		// Keep a real failure of the first attempt over a missing fallback
+		if !IsNotFound(ferr) || IsNotFound(err) {
+			err = ferr
+		}
+	}
+
+	return Result{Hit: HitMiss, Duration: time.Since(start)}, err
+}
+
+// IsNotFound reports whether the error means the cache does not exist, as
+// opposed to a failure to talk to the storage or a corrupt archive.
+func IsNotFound(err error) bool {
+	return errors.Is(err, storage.ErrNotFound)
+}
+
+// countingReader counts the bytes read through it.
+type countingReader struct {
+	r io.Reader
+	n int64
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	c.n += int64(n)
+	return n, err
+}
+
+func restoreCache(src string, s storage.Storage, a archive.Archive) (int64, error) {
 	reader, writer := io.Pipe()
 
 	cw := make(chan error, 1)
 	defer close(cw)
 
 	go func() {
-		defer writer.Close()
+		err := s.Get(src, writer)
+
+		// Pass the error on so the archive does not see a truncated stream
+		writer.CloseWithError(err)
 
-		cw <- s.Get(src, writer)
+		cw <- err
 	}()
 
-	err := a.Unpack("", reader)
+	counter := &countingReader{r: reader}
+	err := a.Unpack("", counter)
+
+	// Unblock the download when the archive stopped reading
+	if err != nil {
+		reader.CloseWithError(err)
+	}
+
 	werr := <-cw
 
 	if werr != nil {
-		return werr
+		return counter.n, werr
 	}
 
-	return err
+	return counter.n, err
 }
 
 func rebuildCache(srcs []string, dst string, s storage.Storage, a archive.Archive) error {
@@ -101,6 +101,32 @@ func TestCache(t *testing.T) {
 				g.Assert(err == nil).IsTrue("should not have returned error on missing file")
 			})
 		})
+
+		g.Describe("RestoreResult", func() {
+			g.It("Should report a fallback hit", func() {
+				s, err := dummy.New(dummyOpts)
+				g.Assert(err == nil).IsTrue("failed to create storage")
+
+				c := NewDefault(s)
+
+				result, err := c.RestoreResult("fixtures/test2.tar", "fixtures/mounts/test.txt")
+				g.Assert(err == nil).IsTrue("failed to restore from fallback")
+				g.Assert(result.Hit).Equal(HitFallback)
+				g.Assert(result.Path).Equal("fixtures/mounts/test.txt")
+			})
+
+			g.It("Should report a miss with the error", func() {
+				s, err := dummy.New(dummyOpts)
+				g.Assert(err == nil).IsTrue("failed to create storage")
+
+				c := NewDefault(s)
+
+				result, err := c.RestoreResult("fixtures/test2.tar", "")
+				g.Assert(err != nil).IsTrue("failed to return error on missing file")
+				g.Assert(IsNotFound(err)).IsTrue("failed to report missing file as not found")
+				g.Assert(result.Hit).Equal(HitMiss)
+			})
+		})
 	})
 }
 
@@ -1,6 +1,7 @@
 package cache
 
 import (
+	"sort"
 	"time"
 
 	log "github.com/sirupsen/logrus"
@@ -12,8 +13,9 @@ type DirtyFunc func(storage.FileEntry) bool
 
 // Flusher defines an object to clear the cache.
 type Flusher struct {
-	store storage.Storage
-	dirty func(storage.FileEntry) bool
+	store   storage.Storage
+	dirty   func(storage.FileEntry) bool
+	maxSize int64
 }
 
 // NewFlusher creates a new cache flusher.
@@ -26,6 +28,14 @@ func NewDefaultFlusher(s storage.Storage) Flusher {
 	return Flusher{store: s, dirty: IsExpired}
 }
 
+// NewSizeFlusher creates a new cache flusher that removes the least recently
+// modified items once all items together are larger than maxSize bytes. An
+// item larger than maxSize on its own is removed too, even the most recent
+// one, without taking the place of the older items that still fit.
+func NewSizeFlusher(s storage.Storage, maxSize int64) Flusher {
+	return Flusher{store: s, dirty: func(storage.FileEntry) bool { return false }, maxSize: maxSize}
+}
+
 // Flush cleans the cache if it's expired.
 func (f *Flusher) Flush(src string) error {
 	log.Infof("Cleaning files from %s", src)
@@ -35,8 +45,10 @@ func (f *Flusher) Flush(src string) error {
 		return err
 	}
 
+	oversize := f.oversize(files)
+
 	for _, file := range files {
-		if f.dirty(file) {
+		if f.dirty(file) || oversize[file.Path] {
 			err := f.store.Delete(file.Path)
 			if err != nil {
 				return err
@@ -47,6 +59,32 @@ func (f *Flusher) Flush(src string) error {
 	return nil
 }
 
+// oversize returns the paths that do not fit into the maximum size, keeping
+// the most recently modified files.
+func (f *Flusher) oversize(files []storage.FileEntry) map[string]bool {
+	if f.maxSize <= 0 {
+		return nil
+	}
+
+	sorted := make([]storage.FileEntry, len(files))
+	copy(sorted, files)
+	sort.Slice(sorted, func(i, j int) bool {
+		return sorted[i].LastModified.After(sorted[j].LastModified)
+	})
+
+	var total int64
+	oversize := make(map[string]bool)
+	for _, file := range sorted {
+		if total+file.Size > f.maxSize {
+			oversize[file.Path] = true
+			continue
+		}
+		total += file.Size
+	}
+
+	return oversize
+}
+
 // IsExpired checks if the cache is expired.
 func IsExpired(file storage.FileEntry) bool {
 	// Check if older then 30 days
@@ -1,6 +1,7 @@
 package cache
 
 import (
+	"io"
 	"io/ioutil"
 	"log"
 	"os"
@@ -70,6 +71,33 @@ func TestFlusher(t *testing.T) {
 				checkFileExists("/tmp/fixtures/cleanup/proj1/master/archive.txt", g)
 				checkFileExists("/tmp/fixtures/cleanup/proj1/newtest/archive.txt", g)
 			})
+
+			g.It("Should cleanup the oldest files above the size", func() {
+				s := &listStorage{files: []storage.FileEntry{
+					{Path: "proj1/master/archive.txt", Size: 10, LastModified: time.Now()},
+					{Path: "proj1/oldtest/archive.txt", Size: 10, LastModified: time.Now().AddDate(0, 0, -40)},
+					{Path: "proj1/newtest/archive.txt", Size: 10, LastModified: time.Now().AddDate(0, 0, -1)},
+				}}
+
+				f := NewSizeFlusher(s, 25)
+
+				err := f.Flush("proj1")
+				g.Assert(err == nil).IsTrue("failed to cleanup")
+				g.Assert(s.deleted).Equal([]string{"proj1/oldtest/archive.txt"})
+			})
+
+			g.It("Should cleanup the newest file above the size on its own", func() {
+				s := &listStorage{files: []storage.FileEntry{
+					{Path: "proj1/master/archive.txt", Size: 30, LastModified: time.Now()},
+					{Path: "proj1/newtest/archive.txt", Size: 10, LastModified: time.Now().AddDate(0, 0, -1)},
+				}}
+
+				f := NewSizeFlusher(s, 25)
+
+				err := f.Flush("proj1")
+				g.Assert(err == nil).IsTrue("failed to cleanup")
+				g.Assert(s.deleted).Equal([]string{"proj1/master/archive.txt"})
+			})
 		})
 	})
 }
@@ -115,3 +143,20 @@ var (
 		"/tmp/fixtures/cleanup/proj2/oldtest",
 	}
 )
+
+// listStorage lists a fixed set of files and records deletes.
+type listStorage struct {
+	files   []storage.FileEntry
+	deleted []string
+}
+
+func (s *listStorage) Get(p string, dst io.Writer) error          { return nil }
+func (s *listStorage) Put(p string, src io.Reader) error          { return nil }
+func (s *listStorage) Exists(p string) (bool, error)              { return false, nil }
+func (s *listStorage) Copy(src, dst string) error                 { return nil }
+func (s *listStorage) List(p string) ([]storage.FileEntry, error) { return s.files, nil }
+
+func (s *listStorage) Delete(p string) error {
+	s.deleted = append(s.deleted, p)
+	return nil
+}
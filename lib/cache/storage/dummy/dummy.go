@@ -1,6 +1,7 @@
 package dummy
 
 import (
+	"fmt"
 	"io"
 	"io/ioutil"
 	"os"
@@ -30,6 +31,10 @@ func New(opts *Options) (storage.Storage, error) {
 
 func (s *dummyStorage) Get(p string, dst io.Writer) error {
 	if _, err := os.Stat(p); err != nil {
+		if os.IsNotExist(err) {
+			return fmt.Errorf("%w: %s", storage.ErrNotFound, err)
+		}
+
 		return err
 	}
 
@@ -76,8 +81,51 @@ func (s *dummyStorage) List(p string) ([]storage.FileEntry, error) {
 	return files, nil
 }
 
+func (s *dummyStorage) Exists(p string) (bool, error) {
+	if _, err := os.Stat(p); err != nil {
+		if os.IsNotExist(err) {
+			return false, nil
+		}
+
+		return false, err
+	}
+
+	return true, nil
+}
+
 func (s *dummyStorage) Delete(p string) error {
 	log.Infof("Deleteing %s", p)
 
 	return os.Remove(p)
 }
+
+func (s *dummyStorage) Copy(src, dst string) error {
+	log.Infof("Copying %s to %s", src, dst)
+
+	in, err := os.Open(src)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return fmt.Errorf("%w: %s", storage.ErrNotFound, err)
+		}
+
+		return err
+	}
+	defer in.Close()
+
+	if err := os.MkdirAll(filepath.Dir(dst), 0755); err != nil {
+		return err
+	}
+
+	out, err := os.Create(dst)
+	if err != nil {
+		return err
+	}
+
+	_, err = io.Copy(out, in)
+
+	if cerr := out.Close(); err == nil {
+		err = cerr
+	}
+
+	return err
+}
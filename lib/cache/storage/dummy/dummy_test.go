@@ -0,0 +1,52 @@
+package dummy
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/franela/goblin"
+	"github.com/yingce/drone-oss-cache/lib/cache/storage"
+)
+
+func TestDummy(t *testing.T) {
+	g := goblin.Goblin(t)
+
+	g.Describe("dummy copies", func() {
+		var dir string
+		var s storage.Storage
+
+		g.BeforeEach(func() {
+			dir, _ = ioutil.TempDir("", "dummy")
+			s, _ = New(&Options{})
+		})
+
+		g.AfterEach(func() {
+			os.RemoveAll(dir)
+		})
+
+		g.It("Should copy to a new directory", func() {
+			src := filepath.Join(dir, "feature", "archive.tar")
+			dst := filepath.Join(dir, "master", "archive.tar")
+
+			os.MkdirAll(filepath.Dir(src), 0755)
+			ioutil.WriteFile(src, []byte("archive"), 0644)
+
+			err := s.Copy(src, dst)
+			g.Assert(err == nil).IsTrue("failed to copy")
+
+			content, _ := ioutil.ReadFile(dst)
+			g.Assert(string(content)).Equal("archive")
+		})
+
+		g.It("Should report a missing source", func() {
+			err := s.Copy(filepath.Join(dir, "missing.tar"), filepath.Join(dir, "master", "archive.tar"))
+			g.Assert(errors.Is(err, storage.ErrNotFound)).IsTrue("expected a not found error")
+
+			_, err = os.Stat(filepath.Join(dir, "master"))
+			g.Assert(os.IsNotExist(err)).IsTrue("expected no destination")
+		})
+	})
+}
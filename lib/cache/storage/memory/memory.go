@@ -0,0 +1,115 @@
+package memory
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/yingce/drone-oss-cache/lib/cache/storage"
+)
+
+type file struct {
+	data         []byte
+	lastModified time.Time
+}
+
+type memoryStorage struct {
+	mu    sync.RWMutex
+	files map[string]file
+}
+
+// New creates an implementation of Storage that keeps the files in memory.
+// It is meant for tests and does not persist anything.
+func New() storage.Storage {
+	return &memoryStorage{
+		files: make(map[string]file),
+	}
+}
+
+func (s *memoryStorage) Get(p string, dst io.Writer) error {
+	s.mu.RLock()
+	f, ok := s.files[p]
+	s.mu.RUnlock()
+
+	if !ok {
+		return fmt.Errorf("%w: %s", storage.ErrNotFound, p)
+	}
+
+	_, err := io.Copy(dst, bytes.NewReader(f.data))
+	return err
+}
+
+func (s *memoryStorage) Put(p string, src io.Reader) error {
+	data, err := ioutil.ReadAll(src)
+	if err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	s.files[p] = file{data: data, lastModified: time.Now()}
+	s.mu.Unlock()
+
+	return nil
+}
+
+func (s *memoryStorage) List(p string) ([]storage.FileEntry, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	prefix := strings.TrimSuffix(p, "/") + "/"
+
+	var files []storage.FileEntry
+	for name, f := range s.files {
+		if strings.HasPrefix(name, prefix) {
+			files = append(files, storage.FileEntry{
+				Path:         name,
+				Size:         int64(len(f.data)),
+				LastModified: f.lastModified,
+			})
+		}
+	}
+
+	sort.Slice(files, func(i, j int) bool {
+		return files[i].Path < files[j].Path
+	})
+
+	return files, nil
+}
+
+func (s *memoryStorage) Exists(p string) (bool, error) {
+	s.mu.RLock()
+	_, ok := s.files[p]
+	s.mu.RUnlock()
+
+	return ok, nil
+}
+
+func (s *memoryStorage) Delete(p string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.files[p]; !ok {
+		return fmt.Errorf("%w: %s", storage.ErrNotFound, p)
+	}
+
+	delete(s.files, p)
+	return nil
+}
+
+func (s *memoryStorage) Copy(src, dst string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	f, ok := s.files[src]
+	if !ok {
+		return fmt.Errorf("%w: %s", storage.ErrNotFound, src)
+	}
+
+	s.files[dst] = file{data: f.data, lastModified: time.Now()}
+	return nil
+}
@@ -0,0 +1,16 @@
+package memory
+
+import (
+	"testing"
+
+	"github.com/franela/goblin"
+	"github.com/yingce/drone-oss-cache/lib/cache/storage/storagetest"
+)
+
+func TestMemory(t *testing.T) {
+	g := goblin.Goblin(t)
+
+	g.Describe("memory storage", func() {
+		storagetest.Run(g, New)
+	})
+}
@@ -0,0 +1,280 @@
+package storage
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/dustin/go-humanize"
+)
+
+// OptionType is the type of the value of a provider option.
+type OptionType int
+
+const (
+	// String options hold a single value
+	String OptionType = iota
+	// Bool options are true or false, missing options are false
+	Bool
+	// Strings options may be given several times
+	Strings
+	// Size options are a number of bytes, e.g. 16MiB
+	Size
+)
+
+func (t OptionType) String() string {
+	switch t {
+	case Bool:
+		return "bool"
+	case Strings:
+		return "list"
+	case Size:
+		return "size"
+	default:
+		return "string"
+	}
+}
+
+// Option describes an option of a provider. It is a query parameter of the
+// provider's URLs and the <provider>_<name> flag.
+type Option struct {
+	Name  string
+	Usage string
+	Type  OptionType
+
+	// EnvVars are environment variables read besides
+	// PLUGIN_<PROVIDER>_<NAME>
+	EnvVars []string
+
+	// Shared is the setting shared by the providers used when the option is
+	// not set, e.g. server
+	Shared string
+}
+
+// Provider is a storage backend. Backends register it from init.
+type Provider struct {
+	Name  string
+	Usage string
+
+	// Aliases are other names the provider is selected by, e.g. http
+	Aliases []string
+
+	// Schemes of the provider's URLs, defaults to the name
+	Schemes []string
+
+	Options []Option
+
+	// URL turns a storage URL into options and the location it points to as
+	// a storage path, defaults to BucketURL
+	URL func(u *url.URL) (url.Values, string, error)
+
+	// New creates the storage from checked options
+	New func(opts Values) (Storage, error)
+}
+
+var (
+	providersMu sync.RWMutex
+	providers   = make(map[string]Provider)
+)
+
+// Register makes a provider available by its name, aliases and schemes.
+// Registering the same name twice panics.
+func Register(p Provider) {
+	providersMu.Lock()
+	defer providersMu.Unlock()
+
+	if len(p.Schemes) == 0 {
+		p.Schemes = []string{p.Name}
+	}
+
+	if p.URL == nil {
+		p.URL = BucketURL
+	}
+
+	if _, ok := providers[p.Name]; ok {
+		panic("storage: Register called twice for " + p.Name)
+	}
+
+	providers[p.Name] = p
+}
+
+// Providers returns the registered providers ordered by name.
+func Providers() []Provider {
+	providersMu.RLock()
+	defer providersMu.RUnlock()
+
+	var list []Provider
+	for _, p := range providers {
+		list = append(list, p)
+	}
+
+	sort.Slice(list, func(i, j int) bool {
+		return list[i].Name < list[j].Name
+	})
+
+	return list
+}
+
+// Lookup returns the provider by its name or an alias.
+func Lookup(name string) (Provider, error) {
+	name = strings.ToLower(strings.TrimSpace(name))
+
+	for _, p := range Providers() {
+		if p.Name == name || contains(p.Aliases, name) {
+			return p, nil
+		}
+	}
+
+	return Provider{}, fmt.Errorf("Unknown provider %s. Needs to be one of %s", name, strings.Join(providerNames(), ", "))
+}
+
+// LookupScheme returns the provider of a URL scheme.
+func LookupScheme(scheme string) (Provider, error) {
+	scheme = strings.ToLower(scheme)
+
+	var schemes []string
+
+	for _, p := range Providers() {
+		if contains(p.Schemes, scheme) {
+			return p, nil
+		}
+
+		schemes = append(schemes, p.Schemes...)
+	}
+
+	sort.Strings(schemes)
+
+	return Provider{}, fmt.Errorf("Unknown storage scheme %s. Needs to be one of %s", scheme, strings.Join(schemes, ", "))
+}
+
+// Open creates the storage for a URL, e.g. s3://bucket/prefix?region=eu-west-1,
+// with the provider of its scheme. It returns the location the URL points to
+// as well.
+func Open(u *url.URL) (Storage, string, error) {
+	p, err := LookupScheme(u.Scheme)
+	if err != nil {
+		return nil, "", err
+	}
+
+	opts, location, err := p.URL(u)
+	if err != nil {
+		return nil, "", err
+	}
+
+	s, err := p.Open(opts)
+
+	return s, location, err
+}
+
+// Open checks the options against the schema and creates the storage.
+func (p Provider) Open(opts url.Values) (Storage, error) {
+	for name, values := range opts {
+		o, ok := p.Option(name)
+		if !ok {
+			var names []string
+			for _, o := range p.Options {
+				names = append(names, o.Name)
+			}
+
+			return nil, fmt.Errorf("Unknown option %s for %s. Needs to be one of %s", name, p.Name, strings.Join(names, ", "))
+		}
+
+		for _, v := range values {
+			if err := o.check(v); err != nil {
+				return nil, fmt.Errorf("Invalid option %s=%s for %s. %s", name, v, p.Name, err)
+			}
+		}
+	}
+
+	return p.New(Values{values: opts})
+}
+
+// Option returns the option by name.
+func (p Provider) Option(name string) (Option, bool) {
+	for _, o := range p.Options {
+		if o.Name == name {
+			return o, true
+		}
+	}
+
+	return Option{}, false
+}
+
+func (o Option) check(v string) error {
+	if v == "" {
+		return nil
+	}
+
+	switch o.Type {
+	case Bool:
+		if _, err := strconv.ParseBool(v); err != nil {
+			return errors.New("Needs to be true or false")
+		}
+	case Size:
+		if _, err := humanize.ParseBytes(v); err != nil {
+			return errors.New("Needs to be a size, e.g. 16MiB")
+		}
+	}
+
+	return nil
+}
+
+// Values are the checked options a provider creates the storage from.
+type Values struct {
+	values url.Values
+}
+
+// String returns the value of an option, empty when not set.
+func (v Values) String(name string) string {
+	return v.values.Get(name)
+}
+
+// Bool returns the value of a Bool option.
+func (v Values) Bool(name string) bool {
+	b, _ := strconv.ParseBool(v.values.Get(name))
+	return b
+}
+
+// Strings returns the values of a Strings option.
+func (v Values) Strings(name string) []string {
+	return v.values[name]
+}
+
+// Size returns the value of a Size option in bytes, 0 when not set.
+func (v Values) Size(name string) int64 {
+	size, _ := humanize.ParseBytes(v.values.Get(name))
+	return int64(size)
+}
+
+// BucketURL returns the query options and the bucket/prefix location of URLs
+// that keep the bucket in the host, e.g. s3://bucket/prefix.
+func BucketURL(u *url.URL) (url.Values, string, error) {
+	if len(u.Host) == 0 {
+		return nil, "", fmt.Errorf("No bucket specified in the %s URL", u.Scheme)
+	}
+
+	return u.Query(), strings.TrimSuffix(u.Host+u.Path, "/"), nil
+}
+
+func providerNames() []string {
+	var names []string
+	for _, p := range Providers() {
+		names = append(names, p.Name)
+	}
+
+	return names
+}
+
+func contains(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+
+	return false
+}
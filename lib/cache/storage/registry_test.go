@@ -0,0 +1,99 @@
+package storage
+
+import (
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/franela/goblin"
+)
+
+func TestRegistry(t *testing.T) {
+	g := goblin.Goblin(t)
+
+	var opened Values
+
+	Register(Provider{
+		Name:    "test",
+		Aliases: []string{"testing"},
+		Schemes: []string{"test", "tests"},
+		Options: []Option{
+			{Name: "region"},
+			{Name: "create_bucket", Type: Bool},
+			{Name: "header", Type: Strings},
+			{Name: "chunk_size", Type: Size},
+		},
+		New: func(opts Values) (Storage, error) {
+			opened = opts
+			return nil, nil
+		},
+	})
+
+	g.Describe("registry", func() {
+		g.It("Should panic when registered twice", func() {
+			defer func() {
+				g.Assert(recover() != nil).IsTrue("expected a panic")
+			}()
+
+			Register(Provider{Name: "test"})
+		})
+
+		g.It("Should look up providers by name and alias", func() {
+			p, err := Lookup("Test")
+			g.Assert(err == nil).IsTrue("failed to look up by name")
+			g.Assert(p.Name).Equal("test")
+
+			p, err = Lookup("testing")
+			g.Assert(err == nil).IsTrue("failed to look up by alias")
+			g.Assert(p.Name).Equal("test")
+		})
+
+		g.It("Should list the valid providers for unknown ones", func() {
+			_, err := Lookup("unknown")
+			g.Assert(err != nil).IsTrue("expected an error")
+			g.Assert(strings.Contains(err.Error(), "test")).IsTrue(err.Error())
+		})
+
+		g.It("Should reject unknown schemes", func() {
+			u, _ := url.Parse("unknown://bucket/prefix")
+
+			_, _, err := Open(u)
+			g.Assert(err != nil).IsTrue("expected an error")
+			g.Assert(strings.Contains(err.Error(), "tests")).IsTrue(err.Error())
+		})
+
+		g.It("Should parse the typed options", func() {
+			u, _ := url.Parse("tests://bucket/prefix/?region=eu-west-1&create_bucket=true&header=a=1&header=b=2&chunk_size=1KiB")
+
+			_, location, err := Open(u)
+			g.Assert(err == nil).IsTrue("failed to open")
+			g.Assert(location).Equal("bucket/prefix")
+			g.Assert(opened.String("region")).Equal("eu-west-1")
+			g.Assert(opened.Bool("create_bucket")).IsTrue("expected true")
+			g.Assert(opened.Strings("header")).Equal([]string{"a=1", "b=2"})
+			g.Assert(opened.Size("chunk_size")).Equal(int64(1024))
+		})
+
+		g.It("Should reject unknown and invalid options", func() {
+			u, _ := url.Parse("test://bucket/prefix?unknown=1")
+			_, _, err := Open(u)
+			g.Assert(err != nil).IsTrue("expected an unknown option error")
+			g.Assert(strings.Contains(err.Error(), "region")).IsTrue(err.Error())
+
+			u, _ = url.Parse("test://bucket/prefix?create_bucket=maybe")
+			_, _, err = Open(u)
+			g.Assert(err != nil).IsTrue("expected an invalid bool error")
+
+			u, _ = url.Parse("test://bucket/prefix?chunk_size=big")
+			_, _, err = Open(u)
+			g.Assert(err != nil).IsTrue("expected an invalid size error")
+		})
+
+		g.It("Should need a bucket", func() {
+			u, _ := url.Parse("test:///prefix")
+
+			_, _, err := Open(u)
+			g.Assert(err != nil).IsTrue("expected a missing bucket error")
+		})
+	})
+}
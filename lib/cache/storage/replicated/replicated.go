@@ -0,0 +1,303 @@
+package replicated
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/yingce/drone-oss-cache/lib/cache/storage"
+)
+
+const (
+	// QuorumAll requires every backend to succeed.
+	QuorumAll = "all"
+
+	// QuorumPrimary requires only the first backend to succeed, failures of
+	// the others are logged.
+	QuorumPrimary = "primary"
+)
+
+// Options contains configuration for the replication.
+type Options struct {
+	// Quorum is QuorumAll or QuorumPrimary, defaults to QuorumAll
+	Quorum string
+}
+
+type replicatedStorage struct {
+	backends []storage.Storage
+	opts     *Options
+}
+
+// New creates an implementation of Storage that writes to all backends and
+// reads from the first one that has the file. The first backend is the
+// primary.
+func New(backends []storage.Storage, opts *Options) (storage.Storage, error) {
+	if len(backends) == 0 {
+		return nil, errors.New("No storage backends specified")
+	}
+
+	switch opts.Quorum {
+	case "":
+		opts.Quorum = QuorumAll
+	case QuorumAll, QuorumPrimary:
+	default:
+		return nil, fmt.Errorf("Invalid quorum %s. Needs to be %s or %s", opts.Quorum, QuorumAll, QuorumPrimary)
+	}
+
+	return &replicatedStorage{
+		backends: backends,
+		opts:     opts,
+	}, nil
+}
+
+func (s *replicatedStorage) Get(p string, dst io.Writer) error {
+	var firstErr error
+
+	for i, b := range s.backends {
+		cw := &countingWriter{w: dst}
+		err := b.Get(p, cw)
+
+		if err == nil {
+			return nil
+		}
+
+		// Falling back is only possible while nothing has been written
+		if cw.n > 0 {
+			return err
+		}
+
+		if !errors.Is(err, storage.ErrNotFound) {
+			log.Warnf("Failed to get %s from backend %d: %s", p, i, err)
+
+			if firstErr == nil {
+				firstErr = err
+			}
+		}
+	}
+
+	if firstErr != nil {
+		return firstErr
+	}
+
+	return fmt.Errorf("%w: %s", storage.ErrNotFound, p)
+}
+
+// Put reads src once and streams it to all backends concurrently.
+func (s *replicatedStorage) Put(p string, src io.Reader) error {
+	writers := make([]io.Writer, len(s.backends))
+	pipes := make([]*io.PipeWriter, len(s.backends))
+	errs := make([]error, len(s.backends))
+
+	var wg sync.WaitGroup
+
+	for i, b := range s.backends {
+		reader, writer := io.Pipe()
+		pipes[i] = writer
+		writers[i] = &replicaWriter{w: writer}
+
+		wg.Add(1)
+		go func(i int, b storage.Storage) {
+			defer wg.Done()
+
+			errs[i] = b.Put(p, reader)
+
+			// Unblock the writer when the backend stopped reading early
+			reader.CloseWithError(errs[i])
+		}(i, b)
+	}
+
+	_, err := io.Copy(&fanoutWriter{writers: writers}, src)
+
+	for _, w := range pipes {
+		w.CloseWithError(err)
+	}
+
+	wg.Wait()
+
+	if err != nil {
+		return err
+	}
+
+	return s.quorum("put", p, errs)
+}
+
+func (s *replicatedStorage) List(p string) ([]storage.FileEntry, error) {
+	var firstErr error
+
+	for i, b := range s.backends {
+		files, err := b.List(p)
+
+		if err == nil {
+			return files, nil
+		}
+
+		log.Warnf("Failed to list %s from backend %d: %s", p, i, err)
+
+		if firstErr == nil {
+			firstErr = err
+		}
+	}
+
+	return nil, firstErr
+}
+
+func (s *replicatedStorage) Exists(p string) (bool, error) {
+	var firstErr error
+
+	for i, b := range s.backends {
+		exists, err := b.Exists(p)
+
+		if err != nil {
+			log.Warnf("Failed to check %s on backend %d: %s", p, i, err)
+
+			if firstErr == nil {
+				firstErr = err
+			}
+
+			continue
+		}
+
+		if exists {
+			return true, nil
+		}
+	}
+
+	return false, firstErr
+}
+
+func (s *replicatedStorage) Delete(p string) error {
+	errs := s.each(func(b storage.Storage) error {
+		return b.Delete(p)
+	})
+
+	return s.quorum("delete", p, errs)
+}
+
+func (s *replicatedStorage) Copy(src, dst string) error {
+	errs := s.each(func(b storage.Storage) error {
+		return b.Copy(src, dst)
+	})
+
+	return s.quorum("copy", src, errs)
+}
+
+// Close closes all backends and returns the first error.
+func (s *replicatedStorage) Close() error {
+	var firstErr error
+
+	for _, b := range s.backends {
+		if err := storage.Close(b); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+
+	return firstErr
+}
+
+// each calls fn for all backends concurrently and returns their errors.
+func (s *replicatedStorage) each(fn func(storage.Storage) error) []error {
+	errs := make([]error, len(s.backends))
+
+	var wg sync.WaitGroup
+
+	for i, b := range s.backends {
+		wg.Add(1)
+		go func(i int, b storage.Storage) {
+			defer wg.Done()
+			errs[i] = fn(b)
+		}(i, b)
+	}
+
+	wg.Wait()
+
+	return errs
+}
+
+// quorum decides whether the errors of the backends fail the operation.
+// Missing files only count when no backend had the file.
+func (s *replicatedStorage) quorum(op, p string, errs []error) error {
+	var failed []string
+	missing := 0
+
+	for i, err := range errs {
+		if err == nil {
+			continue
+		}
+
+		if errors.Is(err, storage.ErrNotFound) {
+			missing++
+			continue
+		}
+
+		log.Warnf("Failed to %s %s on backend %d: %s", op, p, i, err)
+
+		if i == 0 || s.opts.Quorum == QuorumAll {
+			failed = append(failed, fmt.Sprintf("backend %d: %s", i, err))
+		}
+	}
+
+	if len(failed) > 0 {
+		return fmt.Errorf("Failed to %s %s on %s", op, p, strings.Join(failed, ", "))
+	}
+
+	if missing == len(errs) {
+		return errs[0]
+	}
+
+	return nil
+}
+
+// fanoutWriter writes to all writers. Unlike io.MultiWriter it keeps going
+// when a writer fails, as long as one is left.
+type fanoutWriter struct {
+	writers []io.Writer
+}
+
+func (f *fanoutWriter) Write(p []byte) (int, error) {
+	var err error
+	alive := 0
+
+	for _, w := range f.writers {
+		if _, err = w.Write(p); err == nil {
+			alive++
+		}
+	}
+
+	if alive == 0 {
+		return 0, err
+	}
+
+	return len(p), nil
+}
+
+// replicaWriter stops writing to a backend after its first error.
+type replicaWriter struct {
+	w   io.Writer
+	err error
+}
+
+func (r *replicaWriter) Write(p []byte) (int, error) {
+	if r.err != nil {
+		return 0, r.err
+	}
+
+	n, err := r.w.Write(p)
+	r.err = err
+
+	return n, err
+}
+
+// countingWriter counts the bytes written through it.
+type countingWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (c *countingWriter) Write(p []byte) (int, error) {
+	n, err := c.w.Write(p)
+	c.n += int64(n)
+	return n, err
+}
@@ -0,0 +1,95 @@
+package replicated
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"io/ioutil"
+	"strings"
+	"testing"
+
+	"github.com/franela/goblin"
+	"github.com/yingce/drone-oss-cache/lib/cache/storage"
+	"github.com/yingce/drone-oss-cache/lib/cache/storage/memory"
+)
+
+func TestReplicated(t *testing.T) {
+	g := goblin.Goblin(t)
+
+	g.Describe("replicated storage", func() {
+		var primary, secondary storage.Storage
+
+		g.BeforeEach(func() {
+			primary = memory.New()
+			secondary = memory.New()
+		})
+
+		g.It("Should put to all backends", func() {
+			s, _ := New([]storage.Storage{primary, secondary}, &Options{})
+
+			err := s.Put("bucket/a.tar", strings.NewReader("aaaa"))
+			g.Assert(err == nil).IsTrue("failed to put")
+
+			for _, b := range []storage.Storage{primary, secondary} {
+				var buf bytes.Buffer
+				b.Get("bucket/a.tar", &buf)
+				g.Assert(buf.String()).Equal("aaaa")
+			}
+		})
+
+		g.It("Should get from the next backend on a miss", func() {
+			s, _ := New([]storage.Storage{primary, secondary}, &Options{})
+			secondary.Put("bucket/a.tar", strings.NewReader("aaaa"))
+
+			var buf bytes.Buffer
+			err := s.Get("bucket/a.tar", &buf)
+			g.Assert(err == nil).IsTrue("failed to get")
+			g.Assert(buf.String()).Equal("aaaa")
+
+			err = s.Get("bucket/missing.tar", &buf)
+			g.Assert(errors.Is(err, storage.ErrNotFound)).IsTrue("expected not found")
+		})
+
+		g.It("Should fail when a backend fails with quorum all", func() {
+			s, _ := New([]storage.Storage{primary, failing{}}, &Options{Quorum: QuorumAll})
+
+			err := s.Put("bucket/a.tar", strings.NewReader("aaaa"))
+			g.Assert(err != nil).IsTrue("expected an error")
+		})
+
+		g.It("Should only require the primary with quorum primary", func() {
+			s, _ := New([]storage.Storage{primary, failing{}}, &Options{Quorum: QuorumPrimary})
+
+			err := s.Put("bucket/a.tar", strings.NewReader("aaaa"))
+			g.Assert(err == nil).IsTrue("failed to put")
+
+			s, _ = New([]storage.Storage{failing{}, secondary}, &Options{Quorum: QuorumPrimary})
+
+			err = s.Put("bucket/a.tar", strings.NewReader("aaaa"))
+			g.Assert(err != nil).IsTrue("expected an error")
+		})
+
+		g.It("Should reject an invalid quorum", func() {
+			_, err := New([]storage.Storage{primary}, &Options{Quorum: "some"})
+			g.Assert(err != nil).IsTrue("expected an error")
+		})
+	})
+}
+
+// failing is a storage that fails right away.
+type failing struct{}
+
+var errFailing = errors.New("unavailable")
+
+func (failing) Get(p string, dst io.Writer) error { return errFailing }
+
+func (failing) Put(p string, src io.Reader) error {
+	// Read a little to make sure the others keep going
+	io.CopyN(ioutil.Discard, src, 1)
+	return errFailing
+}
+
+func (failing) List(p string) ([]storage.FileEntry, error) { return nil, errFailing }
+func (failing) Exists(p string) (bool, error)              { return false, errFailing }
+func (failing) Delete(p string) error                      { return errFailing }
+func (failing) Copy(src, dst string) error                 { return errFailing }
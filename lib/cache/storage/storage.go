@@ -1,10 +1,15 @@
 package storage
 
 import (
+	"errors"
 	"io"
 	"time"
 )
 
+// ErrNotFound is returned, possibly wrapped, when a bucket or object does not
+// exist. Check for it with errors.Is.
+var ErrNotFound = errors.New("not found")
+
 // FileEntry defines a single cache item.
 type FileEntry struct {
 	Path         string
@@ -19,4 +24,17 @@ type Storage interface {
 	List(p string) ([]FileEntry, error)
 	Exists(key string) (bool, error)
 	Delete(p string) error
+
+	// Copy copies the file at src to dst without downloading it.
+	Copy(src, dst string) error
+}
+
+// Close releases the connections of storages that hold them open, i.e.
+// implement io.Closer. Other storages are left as is.
+func Close(s Storage) error {
+	if c, ok := s.(io.Closer); ok {
+		return c.Close()
+	}
+
+	return nil
 }
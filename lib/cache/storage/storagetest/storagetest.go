@@ -0,0 +1,93 @@
+// Package storagetest checks the behavior every storage.Storage shares, so
+// the tests of a backend only need to cover what is specific to it.
+package storagetest
+
+import (
+	"bytes"
+	"errors"
+	"io/ioutil"
+	"sort"
+	"strings"
+
+	"github.com/franela/goblin"
+	"github.com/yingce/drone-oss-cache/lib/cache/storage"
+)
+
+// Run adds the shared checks to the current goblin block. The storage is
+// returned by open for every check and is expected to be empty, paths start
+// with the bucket.
+func Run(g *goblin.G, open func() storage.Storage) {
+	g.It("Should put and get files", func() {
+		s := open()
+
+		err := s.Put("bucket/owner/repo/archive.tar", strings.NewReader("archive"))
+		g.Assert(err == nil).IsTrue("failed to put")
+
+		var buf bytes.Buffer
+		err = s.Get("bucket/owner/repo/archive.tar", &buf)
+		g.Assert(err == nil).IsTrue("failed to get")
+		g.Assert(buf.String()).Equal("archive")
+
+		exists, err := s.Exists("bucket/owner/repo/archive.tar")
+		g.Assert(err == nil).IsTrue("failed to check")
+		g.Assert(exists).IsTrue("missing file")
+	})
+
+	g.It("Should report missing files", func() {
+		s := open()
+
+		err := s.Get("bucket/missing.tar", ioutil.Discard)
+		g.Assert(errors.Is(err, storage.ErrNotFound)).IsTrue("expected not found")
+
+		exists, err := s.Exists("bucket/missing.tar")
+		g.Assert(err == nil).IsTrue("failed to check")
+		g.Assert(exists).IsFalse("found a missing file")
+
+		err = s.Delete("bucket/missing.tar")
+		g.Assert(errors.Is(err, storage.ErrNotFound)).IsTrue("expected not found")
+
+		err = s.Copy("bucket/missing.tar", "bucket/master/missing.tar")
+		g.Assert(errors.Is(err, storage.ErrNotFound)).IsTrue("expected not found")
+	})
+
+	g.It("Should list by prefix", func() {
+		s := open()
+
+		s.Put("bucket/owner/repo/a.tar", strings.NewReader("a"))
+		s.Put("bucket/owner/repo/master/b.tar", strings.NewReader("bb"))
+		s.Put("bucket/owner/other/c.tar", strings.NewReader("c"))
+
+		files, err := s.List("bucket/owner/repo")
+		g.Assert(err == nil).IsTrue("failed to list")
+
+		sort.Slice(files, func(i, j int) bool {
+			return files[i].Path < files[j].Path
+		})
+
+		g.Assert(len(files)).Equal(2)
+		g.Assert(files[0].Path).Equal("bucket/owner/repo/a.tar")
+		g.Assert(files[0].Size).Equal(int64(1))
+		g.Assert(files[0].LastModified.IsZero()).IsFalse("missing last modified")
+		g.Assert(files[1].Path).Equal("bucket/owner/repo/master/b.tar")
+		g.Assert(files[1].Size).Equal(int64(2))
+	})
+
+	g.It("Should copy and delete", func() {
+		s := open()
+
+		s.Put("bucket/feature/a.tar", strings.NewReader("a"))
+
+		err := s.Copy("bucket/feature/a.tar", "bucket/master/a.tar")
+		g.Assert(err == nil).IsTrue("failed to copy")
+
+		err = s.Delete("bucket/feature/a.tar")
+		g.Assert(err == nil).IsTrue("failed to delete")
+
+		exists, _ := s.Exists("bucket/feature/a.tar")
+		g.Assert(exists).IsFalse("failed to delete")
+
+		var buf bytes.Buffer
+		s.Get("bucket/master/a.tar", &buf)
+		g.Assert(buf.String()).Equal("a")
+	})
+}
@@ -0,0 +1,62 @@
+//go:build !windows
+// +build !windows
+
+package tiered
+
+import (
+	"io"
+	"os"
+	"syscall"
+)
+
+// lockFile opens the lock file at p and locks it, shared or exclusive. The
+// lock is held until the file is closed. Lock files are removed together with
+// their entry, so a lock on a file that is no longer at p is retried.
+func lockFile(p string, exclusive bool) (io.Closer, error) {
+	return flock(p, exclusive, 0)
+}
+
+// tryLockFile is like lockFile with an exclusive lock but fails instead of
+// waiting when the file is locked by someone else.
+func tryLockFile(p string) (io.Closer, error) {
+	return flock(p, true, syscall.LOCK_NB)
+}
+
+func flock(p string, exclusive bool, flags int) (*os.File, error) {
+	how := syscall.LOCK_SH
+	if exclusive {
+		how = syscall.LOCK_EX
+	}
+
+	for {
+		f, err := os.OpenFile(p, os.O_CREATE|os.O_RDONLY, 0644)
+		if err != nil {
+			return nil, err
+		}
+
+		if err := syscall.Flock(int(f.Fd()), how|flags); err != nil {
+			f.Close()
+			return nil, err
+		}
+
+		if locked(f, p) {
+			return f, nil
+		}
+		f.Close()
+	}
+}
+
+// locked reports whether the locked file f is still the file at p.
+func locked(f *os.File, p string) bool {
+	fi, err := f.Stat()
+	if err != nil {
+		return false
+	}
+
+	current, err := os.Stat(p)
+	if err != nil {
+		return false
+	}
+
+	return os.SameFile(fi, current)
+}
@@ -0,0 +1,106 @@
+package tiered
+
+import (
+	"errors"
+	"io"
+	"path/filepath"
+	"sync"
+)
+
+// errLocked is returned by tryLockFile for a path locked by someone else.
+var errLocked = errors.New("Locked by someone else")
+
+// Files are not locked on Windows, the locks are kept in memory instead. So
+// the local tier is only safe to share between goroutines of a single
+// process, not between processes.
+var (
+	locksMu   sync.Mutex
+	locksCond = sync.NewCond(&locksMu)
+	locks     = make(map[string]*memLock)
+)
+
+// memLock is a readers-writer lock of one path.
+type memLock struct {
+	readers int
+	writer  bool
+	waiting int
+}
+
+// lockFile locks the path, shared or exclusive, until the returned lock is
+// closed. No lock file is created.
+func lockFile(p string, exclusive bool) (io.Closer, error) {
+	l, _ := acquire(p, exclusive, true)
+	return l, nil
+}
+
+// tryLockFile is like lockFile with an exclusive lock but fails instead of
+// waiting when the path is locked by someone else.
+func tryLockFile(p string) (io.Closer, error) {
+	l, ok := acquire(p, true, false)
+	if !ok {
+		return nil, errLocked
+	}
+	return l, nil
+}
+
+func acquire(p string, exclusive, wait bool) (io.Closer, bool) {
+	p = filepath.Clean(p)
+
+	locksMu.Lock()
+	defer locksMu.Unlock()
+
+	l, ok := locks[p]
+	if !ok {
+		l = &memLock{}
+		locks[p] = l
+	}
+
+	for l.writer || (exclusive && l.readers > 0) {
+		if !wait {
+			l.release(p)
+			return nil, false
+		}
+
+		l.waiting++
+		locksCond.Wait()
+		l.waiting--
+	}
+
+	if exclusive {
+		l.writer = true
+	} else {
+		l.readers++
+	}
+
+	return &heldLock{path: p, lock: l, exclusive: exclusive}, true
+}
+
+// release forgets the lock once nobody holds or waits for it, locksMu has to
+// be held.
+func (l *memLock) release(p string) {
+	if l.readers == 0 && !l.writer && l.waiting == 0 {
+		delete(locks, p)
+	}
+}
+
+type heldLock struct {
+	path      string
+	lock      *memLock
+	exclusive bool
+}
+
+func (h *heldLock) Close() error {
+	locksMu.Lock()
+	defer locksMu.Unlock()
+
+	if h.exclusive {
+		h.lock.writer = false
+	} else {
+		h.lock.readers--
+	}
+
+	h.lock.release(h.path)
+	locksCond.Broadcast()
+
+	return nil
+}
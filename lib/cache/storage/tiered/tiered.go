@@ -0,0 +1,313 @@
+package tiered
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	pathutil "path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/yingce/drone-oss-cache/lib/cache/storage"
+)
+
+const (
+	// lockSuffix is appended to an entry for its lock file.
+	lockSuffix = ".lock"
+
+	// tmpSuffix is appended to entries that are still being written.
+	tmpSuffix = ".tmp"
+
+	// evictLock guards the eviction of the whole directory.
+	evictLock = ".evict" + lockSuffix
+)
+
+// Options contains configuration for the local tier.
+type Options struct {
+	// Dir is the local directory the entries are kept in, e.g. a host mount
+	Dir string
+
+	// MaxSize evicts the least recently used entries above it, 0 disables it
+	MaxSize int64
+}
+
+type tieredStorage struct {
+	remote storage.Storage
+	opts   *Options
+}
+
+// New creates an implementation of Storage that reads through a local
+// directory in front of the remote storage and writes through to both.
+func New(remote storage.Storage, opts *Options) (storage.Storage, error) {
+	if len(opts.Dir) == 0 {
+		return nil, errors.New("No local cache directory specified")
+	}
+
+	if err := os.MkdirAll(opts.Dir, 0755); err != nil {
+		return nil, err
+	}
+
+	return &tieredStorage{
+		remote: remote,
+		opts:   opts,
+	}, nil
+}
+
+func (s *tieredStorage) Get(p string, dst io.Writer) error {
+	local := s.local(p)
+
+	// A broken local tier must not fail the restore
+	if err := os.MkdirAll(filepath.Dir(local), 0755); err != nil {
+		log.Warnf("Failed to use local cache for %s: %s", p, err)
+		return s.remote.Get(p, dst)
+	}
+
+	// Readers share the lock so parallel steps can restore the same entry
+	lock, err := lockFile(local+lockSuffix, false)
+	if err != nil {
+		log.Warnf("Failed to lock local cache for %s: %s", p, err)
+		return s.remote.Get(p, dst)
+	}
+
+	if ok, err := s.getLocal(local, dst); ok || err != nil {
+		lock.Close()
+		return err
+	}
+	lock.Close()
+
+	// Populating needs the exclusive lock, someone else might have been first
+	if lock, err = lockFile(local+lockSuffix, true); err != nil {
+		log.Warnf("Failed to lock local cache for %s: %s", p, err)
+		return s.remote.Get(p, dst)
+	}
+	defer lock.Close()
+
+	if ok, err := s.getLocal(local, dst); ok || err != nil {
+		return err
+	}
+
+	log.Debugf("Local cache miss for %s", p)
+
+	w := newLocalWriter(local)
+	err = s.remote.Get(p, io.MultiWriter(dst, w))
+
+	if cerr := w.Commit(err == nil); cerr != nil {
+		log.Warnf("Failed to populate local cache for %s: %s", p, cerr)
+	}
+
+	if err != nil {
+		return err
+	}
+
+	s.evict()
+	return nil
+}
+
+func (s *tieredStorage) Put(p string, src io.Reader) error {
+	local := s.local(p)
+
+	if err := os.MkdirAll(filepath.Dir(local), 0755); err != nil {
+		return err
+	}
+
+	lock, err := lockFile(local+lockSuffix, true)
+	if err != nil {
+		return err
+	}
+	defer lock.Close()
+
+	w := newLocalWriter(local)
+	err = s.remote.Put(p, io.TeeReader(src, w))
+
+	if cerr := w.Commit(err == nil); cerr != nil {
+		log.Warnf("Failed to write local cache for %s: %s", p, cerr)
+	}
+
+	if err != nil {
+		return err
+	}
+
+	s.evict()
+	return nil
+}
+
+func (s *tieredStorage) List(p string) ([]storage.FileEntry, error) {
+	return s.remote.List(p)
+}
+
+func (s *tieredStorage) Exists(p string) (bool, error) {
+	if _, err := os.Stat(s.local(p)); err == nil {
+		return true, nil
+	}
+
+	return s.remote.Exists(p)
+}
+
+func (s *tieredStorage) Delete(p string) error {
+	local := s.local(p)
+
+	if lock, err := lockFile(local+lockSuffix, true); err == nil {
+		os.Remove(local)
+		os.Remove(local + lockSuffix)
+		lock.Close()
+	}
+
+	return s.remote.Delete(p)
+}
+
+func (s *tieredStorage) Copy(src, dst string) error {
+	return s.remote.Copy(src, dst)
+}
+
+func (s *tieredStorage) Close() error {
+	return storage.Close(s.remote)
+}
+
+// local returns the path of the entry in the local directory. Cleaning it
+// as an absolute path keeps it inside the directory.
+func (s *tieredStorage) local(p string) string {
+	return filepath.Join(s.opts.Dir, filepath.FromSlash(pathutil.Clean("/"+p)))
+}
+
+// getLocal copies the local entry to dst. It reports whether the entry was
+// found.
+func (s *tieredStorage) getLocal(local string, dst io.Writer) (bool, error) {
+	f, err := os.Open(local)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return false, nil
+		}
+
+		return false, err
+	}
+	defer f.Close()
+
+	log.Debugf("Local cache hit for %s", local)
+
+	// The modification time tracks the last use for the eviction
+	now := time.Now()
+	os.Chtimes(local, now, now)
+
+	_, err = io.Copy(dst, f)
+	return true, err
+}
+
+// evict removes the least recently used entries until the directory fits
+// into the maximum size. Entries in use by someone else are skipped.
+func (s *tieredStorage) evict() {
+	if s.opts.MaxSize <= 0 {
+		return
+	}
+
+	lock, err := tryLockFile(filepath.Join(s.opts.Dir, evictLock))
+	if err != nil {
+		// Someone else is evicting already
+		return
+	}
+	defer lock.Close()
+
+	var entries []storage.FileEntry
+	var total int64
+
+	err = filepath.Walk(s.opts.Dir, func(path string, fi os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+
+		if fi.IsDir() || strings.HasSuffix(path, lockSuffix) || strings.HasSuffix(path, tmpSuffix) {
+			return nil
+		}
+
+		entries = append(entries, storage.FileEntry{
+			Path:         path,
+			Size:         fi.Size(),
+			LastModified: fi.ModTime(),
+		})
+		total += fi.Size()
+
+		return nil
+	})
+
+	if err != nil {
+		log.Warnf("Failed to list local cache: %s", err)
+		return
+	}
+
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].LastModified.Before(entries[j].LastModified)
+	})
+
+	for _, e := range entries {
+		if total <= s.opts.MaxSize {
+			break
+		}
+
+		entryLock, err := tryLockFile(e.Path + lockSuffix)
+		if err != nil {
+			continue
+		}
+
+		if err := os.Remove(e.Path); err != nil {
+			log.Warnf("Failed to evict %s: %s", e.Path, err)
+		} else {
+			log.Debugf("Evicted %s from local cache", e.Path)
+			total -= e.Size
+
+			// Still locked, lockFile notices when its file is gone
+			os.Remove(e.Path + lockSuffix)
+		}
+
+		entryLock.Close()
+	}
+}
+
+// localWriter writes an entry to a temporary file next to it. Failures only
+// disable the writer so the remote transfer is never affected.
+type localWriter struct {
+	path string
+	f    *os.File
+	err  error
+}
+
+func newLocalWriter(p string) *localWriter {
+	w := &localWriter{path: p}
+	w.f, w.err = ioutil.TempFile(filepath.Dir(p), filepath.Base(p)+".*"+tmpSuffix)
+	return w
+}
+
+func (w *localWriter) Write(p []byte) (int, error) {
+	if w.err == nil {
+		_, w.err = w.f.Write(p)
+	}
+
+	return len(p), nil
+}
+
+// Commit moves the temporary file into place when ok, otherwise it is
+// discarded.
+func (w *localWriter) Commit(ok bool) error {
+	if w.f == nil {
+		return w.err
+	}
+
+	if err := w.f.Close(); w.err == nil {
+		w.err = err
+	}
+
+	if !ok || w.err != nil {
+		os.Remove(w.f.Name())
+		return w.err
+	}
+
+	if err := os.Rename(w.f.Name(), w.path); err != nil {
+		os.Remove(w.f.Name())
+		return fmt.Errorf("Failed to rename %s: %s", w.f.Name(), err)
+	}
+
+	return nil
+}
@@ -0,0 +1,126 @@
+package tiered
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/franela/goblin"
+	"github.com/yingce/drone-oss-cache/lib/cache/storage"
+	"github.com/yingce/drone-oss-cache/lib/cache/storage/memory"
+)
+
+func TestTiered(t *testing.T) {
+	g := goblin.Goblin(t)
+
+	g.Describe("tiered storage", func() {
+		var dir string
+		var remote storage.Storage
+		var s storage.Storage
+
+		g.BeforeEach(func() {
+			var err error
+			dir, err = ioutil.TempDir("", "tiered")
+			if err != nil {
+				g.Fail(err)
+			}
+
+			remote = memory.New()
+			s, err = New(remote, &Options{Dir: dir, MaxSize: 10})
+			if err != nil {
+				g.Fail(err)
+			}
+		})
+
+		g.AfterEach(func() {
+			os.RemoveAll(dir)
+		})
+
+		g.It("Should populate the local tier on a miss", func() {
+			remote.Put("bucket/a.tar", strings.NewReader("aaaa"))
+
+			var buf bytes.Buffer
+			err := s.Get("bucket/a.tar", &buf)
+			g.Assert(err == nil).IsTrue("failed to get from the remote")
+			g.Assert(buf.String()).Equal("aaaa")
+
+			// Served locally once the remote is gone
+			remote.Delete("bucket/a.tar")
+
+			buf.Reset()
+			err = s.Get("bucket/a.tar", &buf)
+			g.Assert(err == nil).IsTrue("failed to get from the local tier")
+			g.Assert(buf.String()).Equal("aaaa")
+		})
+
+		g.It("Should write through on put", func() {
+			err := s.Put("bucket/a.tar", strings.NewReader("aaaa"))
+			g.Assert(err == nil).IsTrue("failed to put")
+
+			var buf bytes.Buffer
+			remote.Get("bucket/a.tar", &buf)
+			g.Assert(buf.String()).Equal("aaaa")
+
+			data, _ := ioutil.ReadFile(filepath.Join(dir, "bucket", "a.tar"))
+			g.Assert(string(data)).Equal("aaaa")
+		})
+
+		g.It("Should not keep missing entries", func() {
+			err := s.Get("bucket/missing.tar", ioutil.Discard)
+			g.Assert(err != nil).IsTrue("expected a miss")
+
+			_, err = os.Stat(filepath.Join(dir, "bucket", "missing.tar"))
+			g.Assert(os.IsNotExist(err)).IsTrue("kept a missing entry")
+		})
+
+		g.It("Should evict the least recently used entries", func() {
+			s.Put("bucket/a.tar", strings.NewReader("aaaa"))
+			s.Put("bucket/b.tar", strings.NewReader("bbbb"))
+
+			old := time.Now().Add(-time.Hour)
+			os.Chtimes(filepath.Join(dir, "bucket", "b.tar"), old, old)
+			older := old.Add(-time.Hour)
+			os.Chtimes(filepath.Join(dir, "bucket", "a.tar"), older, older)
+
+			// Using a makes it the most recent
+			s.Get("bucket/a.tar", ioutil.Discard)
+
+			s.Put("bucket/c.tar", strings.NewReader("cccc"))
+
+			_, err := os.Stat(filepath.Join(dir, "bucket", "b.tar"))
+			g.Assert(os.IsNotExist(err)).IsTrue("failed to evict b")
+
+			_, err = os.Stat(filepath.Join(dir, "bucket", "a.tar"))
+			g.Assert(err == nil).IsTrue("evicted a")
+
+			_, err = os.Stat(filepath.Join(dir, "bucket", "c.tar"))
+			g.Assert(err == nil).IsTrue("evicted c")
+
+			_, err = os.Stat(filepath.Join(dir, "bucket", "b.tar"+lockSuffix))
+			g.Assert(os.IsNotExist(err)).IsTrue("failed to remove the lock of b")
+		})
+
+		g.It("Should read from the remote when the local tier fails", func() {
+			remote.Put("bucket/a.tar", strings.NewReader("aaaa"))
+
+			// A file in place of the directory of the entry
+			ioutil.WriteFile(filepath.Join(dir, "bucket"), []byte("x"), 0644)
+
+			var buf bytes.Buffer
+			err := s.Get("bucket/a.tar", &buf)
+			g.Assert(err == nil).IsTrue("failed to read from the remote")
+			g.Assert(buf.String()).Equal("aaaa")
+		})
+
+		g.It("Should keep entries inside the directory", func() {
+			s.Put("../../escape.tar", strings.NewReader("x"))
+
+			_, err := os.Stat(filepath.Join(dir, "escape.tar"))
+			g.Assert(err == nil).IsTrue("wrote outside the directory")
+		})
+	})
+}
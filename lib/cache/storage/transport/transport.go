@@ -0,0 +1,90 @@
+// Package transport creates the HTTP transports of backends talking to self
+// hosted servers, e.g. MinIO or Ceph behind a private CA or a proxy.
+package transport
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// Options contains configuration for the TLS connections of the transport.
+type Options struct {
+	// CACert is a PEM encoded CA trusted in addition to the system CAs,
+	// CACertPath reads one from a file
+	CACert     string
+	CACertPath string
+
+	// InsecureSkipVerify accepts any server certificate, only meant for labs
+	InsecureSkipVerify bool
+}
+
+// New returns a transport like http.DefaultTransport with the TLS options.
+// Proxies are read from HTTP_PROXY, HTTPS_PROXY and NO_PROXY. The CA is added
+// to a copy of the system pool in memory, the system CAs are never modified.
+func New(opts *Options) (*http.Transport, error) {
+	t := http.DefaultTransport.(*http.Transport).Clone()
+	t.Proxy = http.ProxyFromEnvironment
+
+	pool, err := certPool(opts)
+	if err != nil {
+		return nil, err
+	}
+
+	if pool == nil && !opts.InsecureSkipVerify {
+		return t, nil
+	}
+
+	if opts.InsecureSkipVerify {
+		log.Warn("Skipping verification of server certificates")
+	}
+
+	t.TLSClientConfig = &tls.Config{
+		RootCAs:            pool,
+		InsecureSkipVerify: opts.InsecureSkipVerify,
+	}
+
+	return t, nil
+}
+
+// certPool returns the system pool with the CAs of the options, nil without
+// any.
+func certPool(opts *Options) (*x509.CertPool, error) {
+	var certs [][]byte
+
+	if len(opts.CACert) != 0 {
+		certs = append(certs, []byte(opts.CACert))
+	}
+
+	if len(opts.CACertPath) != 0 {
+		cert, err := ioutil.ReadFile(opts.CACertPath)
+		if err != nil {
+			return nil, fmt.Errorf("Failed to read ca cert: %s", err)
+		}
+
+		certs = append(certs, cert)
+	}
+
+	if len(certs) == 0 {
+		return nil, nil
+	}
+
+	pool, err := x509.SystemCertPool()
+	if err != nil {
+		log.Warnf("Failed to load the system CAs, only trusting the ca cert: %s", err)
+		pool = x509.NewCertPool()
+	}
+
+	for _, cert := range certs {
+		if !pool.AppendCertsFromPEM(cert) {
+			return nil, errors.New("Invalid ca cert. Needs to be a PEM encoded certificate")
+		}
+	}
+
+	return pool, nil
+}
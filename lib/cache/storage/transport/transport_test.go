@@ -0,0 +1,75 @@
+package transport
+
+import (
+	"encoding/pem"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/franela/goblin"
+)
+
+func TestTransport(t *testing.T) {
+	g := goblin.Goblin(t)
+
+	g.Describe("transport", func() {
+		var server *httptest.Server
+		var cert string
+
+		g.BeforeEach(func() {
+			server = httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+			cert = string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: server.Certificate().Raw}))
+		})
+
+		g.AfterEach(func() {
+			server.Close()
+		})
+
+		get := func(opts *Options) error {
+			tr, err := New(opts)
+			if err != nil {
+				return err
+			}
+
+			resp, err := (&http.Client{Transport: tr}).Get(server.URL)
+			if err != nil {
+				return err
+			}
+
+			return resp.Body.Close()
+		}
+
+		g.It("Should only trust the system cas by default", func() {
+			g.Assert(get(&Options{}) != nil).IsTrue("expected an unknown authority error")
+		})
+
+		g.It("Should trust the ca cert", func() {
+			g.Assert(get(&Options{CACert: cert}) == nil).IsTrue("failed with the ca cert")
+		})
+
+		g.It("Should trust the ca cert file", func() {
+			dir, _ := ioutil.TempDir("", "transport")
+			defer os.RemoveAll(dir)
+
+			path := filepath.Join(dir, "ca.pem")
+			ioutil.WriteFile(path, []byte(cert), 0644)
+
+			g.Assert(get(&Options{CACertPath: path}) == nil).IsTrue("failed with the ca cert file")
+		})
+
+		g.It("Should skip verification", func() {
+			g.Assert(get(&Options{InsecureSkipVerify: true}) == nil).IsTrue("failed without verification")
+		})
+
+		g.It("Should reject invalid ca certs", func() {
+			_, err := New(&Options{CACert: "not a certificate"})
+			g.Assert(err != nil).IsTrue("expected an invalid ca cert error")
+
+			_, err = New(&Options{CACertPath: "/does/not/exist.pem"})
+			g.Assert(err != nil).IsTrue("expected a missing file error")
+		})
+	})
+}
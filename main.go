@@ -6,14 +6,13 @@ import (
 	"os"
 	pathutil "path"
 	"strconv"
-	"strings"
-
-	"github.com/yingce/drone-oss-cache/storage/aliyun_oss"
 
+	"github.com/dustin/go-humanize"
 	log "github.com/sirupsen/logrus"
 	"github.com/urfave/cli"
 	"github.com/yingce/drone-oss-cache/lib/cache/storage"
-	"github.com/yingce/drone-oss-cache/storage/s3"
+	"github.com/yingce/drone-oss-cache/lib/cache/storage/replicated"
+	"github.com/yingce/drone-oss-cache/lib/cache/storage/tiered"
 )
 
 var (
@@ -26,13 +25,32 @@ func main() {
 	app.Usage = "cache plugin"
 	app.Action = run
 	app.Version = version
+	app.Commands = []cli.Command{
+		{
+			Name:   "serve",
+			Usage:  "serve bazel, gradle, ccache and turborepo remote caches from the storage",
+			Action: serve,
+			Flags:  serveFlags,
+		},
+	}
 	app.Flags = []cli.Flag{
 		// Cache information
 		cli.StringFlag{
+			Name:   "url",
+			Usage:  "storage url, e.g. s3://bucket/prefix?region=eu-west-1, used instead of provider. Paths are relative to it",
+			EnvVar: "PLUGIN_URL",
+		},
+		cli.StringSliceFlag{
 			Name:   "provider",
-			Usage:  "Cache provider, e.g: S3 or OSS",
+			Usage:  "Cache provider, see the providers above, defaults to s3. Several providers are written in order, the first is the primary",
 			EnvVar: "PLUGIN_PROVIDER",
 		},
+		cli.StringFlag{
+			Name:   "quorum",
+			Usage:  "backends that must succeed with several providers: all or primary",
+			EnvVar: "PLUGIN_QUORUM",
+			Value:  replicated.QuorumAll,
+		},
 		cli.StringFlag{
 			Name:   "filename",
 			Usage:  "Filename for the cache",
@@ -58,6 +76,16 @@ func main() {
 			Usage:  "cache directories",
 			EnvVar: "PLUGIN_MOUNT",
 		},
+		cli.StringSliceFlag{
+			Name:   "exclude",
+			Usage:  "gitignore-style patterns to exclude from the mounts",
+			EnvVar: "PLUGIN_EXCLUDE",
+		},
+		cli.StringSliceFlag{
+			Name:   "include",
+			Usage:  "gitignore-style patterns to include again after exclusion",
+			EnvVar: "PLUGIN_INCLUDE",
+		},
 		cli.BoolFlag{
 			Name:   "rebuild",
 			Usage:  "rebuild the cache directories",
@@ -68,11 +96,26 @@ func main() {
 			Usage:  "restore the cache directories",
 			EnvVar: "PLUGIN_RESTORE",
 		},
+		cli.BoolFlag{
+			Name:   "strict, fail_on_miss",
+			Usage:  "fail the restore when the cache is missing or cannot be restored",
+			EnvVar: "PLUGIN_STRICT,PLUGIN_FAIL_ON_MISS",
+		},
+		cli.StringFlag{
+			Name:   "hit_file",
+			Usage:  "file to write the restore result to in dotenv format",
+			EnvVar: "PLUGIN_HIT_FILE",
+		},
 		cli.BoolFlag{
 			Name:   "flush",
 			Usage:  "flush the cache",
 			EnvVar: "PLUGIN_FLUSH",
 		},
+		cli.BoolFlag{
+			Name:   "promote",
+			Usage:  "copy the cache to the fallback path on the server",
+			EnvVar: "PLUGIN_PROMOTE",
+		},
 		cli.StringFlag{
 			Name:   "flush_age",
 			Usage:  "flush cache files older than # days",
@@ -84,6 +127,16 @@ func main() {
 			Usage:  "path to search for flushable cache files",
 			EnvVar: "PLUGIN_FLUSH_PATH",
 		},
+		cli.BoolFlag{
+			Name:   "preserve_owner",
+			Usage:  "restore file ownership when running as root",
+			EnvVar: "PLUGIN_PRESERVE_OWNER",
+		},
+		cli.BoolFlag{
+			Name:   "xattrs",
+			Usage:  "store and restore extended attributes",
+			EnvVar: "PLUGIN_XATTRS",
+		},
 		cli.BoolFlag{
 			Name:   "debug",
 			Usage:  "debug plugin output",
@@ -115,11 +168,11 @@ func main() {
 			EnvVar: "DRONE_COMMIT_BRANCH",
 		},
 
-		// S3 information
+		// Settings shared by the providers
 
 		cli.StringFlag{
 			Name:   "server",
-			Usage:  "s3 server",
+			Usage:  "server of the provider",
 			EnvVar: "PLUGIN_SERVER,PLUGIN_ENDPOINT,CACHE_S3_ENDPOINT,CACHE_S3_SERVER,S3_ENDPOINT",
 		},
 		cli.StringFlag{
@@ -129,12 +182,12 @@ func main() {
 		},
 		cli.StringFlag{
 			Name:   "access-key",
-			Usage:  "s3 access key",
+			Usage:  "access key of the provider",
 			EnvVar: "PLUGIN_ACCESS_KEY,CACHE_S3_ACCESS_KEY,AWS_ACCESS_KEY_ID",
 		},
 		cli.StringFlag{
 			Name:   "secret-key",
-			Usage:  "s3 secret key",
+			Usage:  "secret key of the provider",
 			EnvVar: "PLUGIN_SECRET_KEY,CACHE_S3_SECRET_KEY,AWS_SECRET_ACCESS_KEY",
 		},
 		cli.StringFlag{
@@ -144,21 +197,54 @@ func main() {
 		},
 		cli.StringFlag{
 			Name:   "region",
-			Usage:  "s3 region",
+			Usage:  "region of the provider",
 			EnvVar: "PLUGIN_REGION,CACHE_S3_REGION",
 		},
+		cli.BoolFlag{
+			Name:   "create_bucket",
+			Usage:  "create the bucket if it does not exist",
+			EnvVar: "PLUGIN_CREATE_BUCKET",
+		},
+		cli.StringFlag{
+			Name:   "bucket_acl",
+			Usage:  "acl of created buckets, defaults to private",
+			EnvVar: "PLUGIN_BUCKET_ACL",
+		},
+		cli.StringFlag{
+			Name:   "local_cache",
+			Usage:  "local directory read through in front of the storage, e.g. a host mount",
+			EnvVar: "PLUGIN_LOCAL_CACHE",
+		},
+		cli.StringFlag{
+			Name:   "local_cache_size",
+			Usage:  "evict local cache entries above this size, e.g. 20GB",
+			EnvVar: "PLUGIN_LOCAL_CACHE_SIZE",
+		},
 		cli.StringFlag{
 			Name:   "ca_cert",
-			Usage:  "ca cert to connect to s3 server",
+			Usage:  "pem encoded ca trusted in addition to the system cas to connect to https servers",
 			EnvVar: "PLUGIN_CA_CERT,CACHE_S3_CA_CERT",
 		},
 		cli.StringFlag{
 			Name:   "ca_cert_path",
-			Usage:  "ca cert to connect to s3 server",
+			Usage:  "file of a pem encoded ca trusted in addition to the system cas to connect to https servers",
 			EnvVar: "PLUGIN_CA_CERT_PATH,CACHE_S3_CA_CERT_PATH",
 		},
+		cli.BoolFlag{
+			Name:   "insecure_skip_verify",
+			Usage:  "accept any server certificate, only meant for labs",
+			EnvVar: "PLUGIN_INSECURE_SKIP_VERIFY",
+		},
+		cli.StringFlag{
+			Name:   "bucket_lookup",
+			Usage:  "addressing of buckets: path, dns (virtual-hosted) or auto",
+			EnvVar: "PLUGIN_BUCKET_LOOKUP",
+		},
 	}
 
+	app.Flags = append(app.Flags, providerFlags()...)
+	app.Description = providersHelp()
+
 	if err := app.Run(os.Args); err != nil {
 		log.Fatal(err)
 	}
@@ -174,20 +260,20 @@ func run(c *cli.Context) error {
 	rebuild := c.Bool("rebuild")
 	restore := c.Bool("restore")
 	flush := c.Bool("flush")
+	promote := c.Bool("promote")
 
-	if isMultipleModes(rebuild, restore, flush) {
-		return errors.New("Must use a single mode: rebuild, restore or flush")
-	} else if !rebuild && !restore && !flush {
+	if isMultipleModes(rebuild, restore, flush, promote) {
+		return errors.New("Must use a single mode: rebuild, restore, flush or promote")
+	} else if !rebuild && !restore && !flush && !promote {
 		return errors.New("No action specified")
 	}
 
 	var mode string
-	var mount []string
 
-	if rebuild {
-		// Look for the mount points to rebuild
-		mount = c.StringSlice("mount")
+	// Look for the mount points, restore needs them for keyed mounts
+	mount := c.StringSlice("mount")
 
+	if rebuild {
 		if len(mount) == 0 {
 			return errors.New("No mounts specified")
 		}
@@ -195,6 +281,8 @@ func run(c *cli.Context) error {
 		mode = RebuildMode
 	} else if flush {
 		mode = FlushMode
+	} else if promote {
+		mode = PromoteMode
 	} else {
 		mode = RestoreMode
 	}
@@ -261,12 +349,21 @@ func run(c *cli.Context) error {
 		filename = "archive.tar"
 	}
 
-	s, err := newStorage(c)
+	s, location, err := newStorage(c)
 
 	if err != nil {
 		return err
 	}
 
+	defer storage.Close(s)
+
+	// Paths are relative to the location of the storage url
+	if len(location) > 0 {
+		path = prefixRoot(location, path)
+		fallbackPath = prefixRoot(location, fallbackPath)
+		flushPath = prefixRoot(location, flushPath)
+	}
+
 	flushAge, err := strconv.Atoi(c.String("flush_age"))
 
 	if err != nil {
@@ -282,70 +379,99 @@ func run(c *cli.Context) error {
 		FlushAge:     flushAge,
 		Mount:        mount,
 		Storage:      s,
-		Cacert:       c.String("ca_cert"),
-		CacertPath:   c.String("ca_cert_path"),
+
+		PreserveOwner: c.Bool("preserve_owner"),
+		Xattrs:        c.Bool("xattrs"),
+		Exclude:       c.StringSlice("exclude"),
+		Include:       c.StringSlice("include"),
+		HitFile:       c.String("hit_file"),
+		Strict:        c.Bool("strict"),
 	}
 
 	return p.Exec()
 }
 
-func newStorage(c *cli.Context) (storage.Storage, error) {
-	provider := c.String("provider")
-	provider = strings.ToLower(provider)
-	if provider == "" || provider == "s3" {
-		return s3Storage(c)
-	} else if provider == "oss" {
-		return ossStorage(c)
-	} else {
-		log.Fatal("not support provider")
+// newStorage creates the configured storage. It returns the location of the
+// storage url as well, which the paths are relative to.
+func newStorage(c *cli.Context) (storage.Storage, string, error) {
+	s, location, err := remoteStorage(c)
+
+	if err != nil {
+		return nil, "", err
 	}
-	return nil, nil
-}
 
-func ossStorage(c *cli.Context) (storage.Storage, error) {
-	server := c.String("server")
-	if server == "" {
-		server = "https://oss-cn-beijing.aliyuncs.com"
+	dir := c.String("local_cache")
+
+	if len(dir) == 0 {
+		return s, location, nil
+	}
+
+	var maxSize uint64
+
+	if size := c.String("local_cache_size"); len(size) > 0 {
+		if maxSize, err = humanize.ParseBytes(size); err != nil {
+			storage.Close(s)
+			return nil, "", err
+		}
 	}
-	return aliyun_oss.New(&aliyun_oss.Options{
-		Endpoint: server,
-		Key:      c.String("access-key"),
-		Secret:   c.String("secret-key"),
+
+	t, err := tiered.New(s, &tiered.Options{
+		Dir:     dir,
+		MaxSize: int64(maxSize),
 	})
+
+	if err != nil {
+		storage.Close(s)
+		return nil, "", err
+	}
+
+	return t, location, nil
+}
+
+func remoteStorage(c *cli.Context) (storage.Storage, string, error) {
+	providers := c.StringSlice("provider")
+
+	if len(c.String("url")) > 0 {
+		if len(providers) > 0 {
+			return nil, "", errors.New("Must use either url or provider")
+		}
+
+		return urlStorage(c)
+	}
+
+	s, err := providersStorage(c, providers)
+	return s, "", err
 }
 
-func s3Storage(c *cli.Context) (storage.Storage, error) {
-	// Get the endpoint
-	server := c.String("server")
+func providersStorage(c *cli.Context, providers []string) (storage.Storage, error) {
+	if len(providers) <= 1 {
+		var provider string
+		if len(providers) == 1 {
+			provider = providers[0]
+		}
+
+		return providerStorage(c, provider)
+	}
 
-	var endpoint string
-	var useSSL bool
+	// Several providers are written to all of them in order
+	var backends []storage.Storage
 
-	if len(server) > 0 {
-		useSSL = strings.HasPrefix(server, "https://")
+	for _, provider := range providers {
+		s, err := providerStorage(c, provider)
 
-		if !useSSL {
-			if !strings.HasPrefix(server, "http://") {
-				return nil, fmt.Errorf("Invalid server %s. Needs to be a HTTP URI", server)
+		if err != nil {
+			for _, b := range backends {
+				storage.Close(b)
 			}
 
-			endpoint = server[7:]
-		} else {
-			endpoint = server[8:]
+			return nil, err
 		}
-	} else {
-		endpoint = "s3.amazonaws.com"
-		useSSL = true
+
+		backends = append(backends, s)
 	}
 
-	return s3.New(&s3.Options{
-		Endpoint:            endpoint,
-		AcceleratedEndpoint: c.String("accelerated-endpoint"),
-		Access:              c.String("access-key"),
-		Secret:              c.String("secret-key"),
-		Token:               c.String("session-token"),
-		Region:              c.String("region"),
-		UseSSL:              useSSL,
+	return replicated.New(backends, &replicated.Options{
+		Quorum: c.String("quorum"),
 	})
 }
 
@@ -0,0 +1,142 @@
+package main
+
+import (
+	"fmt"
+	pathutil "path"
+	"strings"
+	"sync"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/yingce/drone-oss-cache/cachekey"
+	"github.com/yingce/drone-oss-cache/lib/cache/cache"
+)
+
+// mountKeySeparator separates a mount from its key, e.g.
+// node_modules=>{{ hashFiles "package-lock.json" }}
+const mountKeySeparator = "=>"
+
+// mount is a cache directory stored in an archive of its own.
+type mount struct {
+	Src string
+	Key string
+}
+
+// parseMounts splits the mounts into directories and keys. It reports whether
+// any mount has a key, in which case every mount gets its own archive and
+// every mount needs a key, directories are never used as object names.
+func parseMounts(mounts []string) ([]mount, bool, error) {
+	var keyed bool
+	parsed := make([]mount, 0, len(mounts))
+
+	for _, m := range mounts {
+		src := m
+		var key string
+
+		if i := strings.Index(m, mountKeySeparator); i != -1 {
+			src = strings.TrimSpace(m[:i])
+			key = strings.TrimSpace(m[i+len(mountKeySeparator):])
+			keyed = true
+		}
+
+		parsed = append(parsed, mount{Src: src, Key: key})
+	}
+
+	if !keyed {
+		return parsed, false, nil
+	}
+
+	for _, m := range parsed {
+		if len(m.Key) == 0 {
+			return nil, true, fmt.Errorf("No key specified for mount %s. Needs to be mount=>key when any mount has a key", m.Src)
+		}
+	}
+
+	return parsed, true, nil
+}
+
+// isChecksumKey reports whether the template derives the key from file
+// content, so an existing object never has to be rebuilt.
+func isChecksumKey(tmpl string) bool {
+	return strings.Contains(tmpl, "checksum") || strings.Contains(tmpl, "hashFiles")
+}
+
+// execMounts rebuilds, restores or promotes every mount concurrently.
+func (p *Plugin) execMounts(c cache.Cache, mounts []mount) error {
+	var wg sync.WaitGroup
+	errs := make([]error, len(mounts))
+	results := make([]restoreResult, len(mounts))
+
+	for i, m := range mounts {
+		wg.Add(1)
+
+		go func(i int, m mount) {
+			defer wg.Done()
+
+			results[i].Name = m.Src
+			results[i].Result, errs[i] = p.execMount(c, m)
+		}(i, m)
+	}
+
+	wg.Wait()
+
+	if p.Mode == RestoreMode && p.HitFile != "" {
+		if err := writeHitFile(p.HitFile, results); err != nil {
+			return err
+		}
+	}
+
+	for _, err := range errs {
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (p *Plugin) execMount(c cache.Cache, m mount) (cache.Result, error) {
+	key, err := cachekey.CacheKey(m.Key, cachekey.MetaData{})
+	if err != nil {
+		return cache.Result{}, err
+	}
+
+	path := pathutil.Join(p.Path, key, p.Filename)
+	fallbackPath := pathutil.Join(p.FallbackPath, key, p.Filename)
+
+	if p.Mode == PromoteMode {
+		return cache.Result{}, p.promote(path, fallbackPath)
+	}
+
+	if p.Mode == RebuildMode {
+		if isChecksumKey(m.Key) {
+			exists, err := p.Storage.Exists(path)
+			if err != nil {
+				log.Warnf("Failed to check for cache at %s: %s", path, err)
+			}
+
+			if exists {
+				log.Infof("Cache skip for %s, object exists at %s", m.Src, path)
+				return cache.Result{}, nil
+			}
+		}
+
+		log.Infof("Rebuilding cache for %s at %s", m.Src, path)
+
+		if err := c.Rebuild([]string{m.Src}, path); err != nil {
+			return cache.Result{}, err
+		}
+
+		log.Infof("Cache rebuilt for %s", m.Src)
+		return cache.Result{}, nil
+	}
+
+	log.Infof("Restoring cache for %s at %s", m.Src, path)
+
+	result, err := c.RestoreResult(path, fallbackPath)
+	if err != nil {
+		return result, p.restoreFailed(path, err)
+	}
+
+	log.Infof("Cache hit (%s) for %s from %s", result.Hit, m.Src, result.Path)
+	return result, nil
+}
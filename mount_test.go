@@ -0,0 +1,41 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/franela/goblin"
+)
+
+func TestParseMounts(t *testing.T) {
+	g := goblin.Goblin(t)
+
+	g.Describe("parseMounts", func() {
+		g.It("Should keep unkeyed mounts in one archive", func() {
+			mounts, keyed, err := parseMounts([]string{"node_modules", ".gocache"})
+			g.Assert(err == nil).IsTrue("failed to parse mounts")
+			g.Assert(keyed).IsFalse("expected unkeyed mounts")
+			g.Assert(len(mounts)).Equal(2)
+		})
+
+		g.It("Should split mounts and keys", func() {
+			mounts, keyed, err := parseMounts([]string{"node_modules => npm-{{ .Commit.Branch }}", ".gocache=>go"})
+			g.Assert(err == nil).IsTrue("failed to parse mounts")
+			g.Assert(keyed).IsTrue("expected keyed mounts")
+			g.Assert(mounts).Equal([]mount{
+				{Src: "node_modules", Key: "npm-{{ .Commit.Branch }}"},
+				{Src: ".gocache", Key: "go"},
+			})
+		})
+
+		g.It("Should need a key for every mount once any is keyed", func() {
+			for _, mounts := range [][]string{
+				{"node_modules=>npm", "../shared"},
+				{"node_modules=>npm", "/var/cache"},
+				{"node_modules=>npm", ".gocache=>"},
+			} {
+				_, _, err := parseMounts(mounts)
+				g.Assert(err != nil).IsTrue("expected a missing key error")
+			}
+		})
+	})
+}
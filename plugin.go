@@ -1,14 +1,14 @@
 package main
 
 import (
-	"io/ioutil"
+	"fmt"
 	pathutil "path"
-	"strings"
 	"time"
 
 	"github.com/yingce/drone-oss-cache/cachekey"
 
 	log "github.com/sirupsen/logrus"
+	"github.com/yingce/drone-oss-cache/lib/cache/archive"
 	"github.com/yingce/drone-oss-cache/lib/cache/archive/util"
 	"github.com/yingce/drone-oss-cache/lib/cache/cache"
 	"github.com/yingce/drone-oss-cache/lib/cache/storage"
@@ -23,8 +23,13 @@ type Plugin struct {
 	Mode         string
 	FlushAge     int
 	Mount        []string
-	Cacert       string
-	CacertPath   string
+
+	PreserveOwner bool
+	Xattrs        bool
+	Exclude       []string
+	Include       []string
+	HitFile       string
+	Strict        bool
 
 	Storage storage.Storage
 }
@@ -36,6 +41,8 @@ const (
 	RebuildMode = "rebuild"
 	// FlushMode for flush mode string
 	FlushMode = "flush"
+	// PromoteMode for promote mode string
+	PromoteMode = "promote"
 )
 
 // Exec runs the plugin
@@ -44,7 +51,7 @@ func (p *Plugin) Exec() error {
 
 	var useCheckSum bool
 
-	if strings.Contains(p.Path, "checksum") || strings.Contains(p.Filename, "checksum") {
+	if isChecksumKey(p.Path) || isChecksumKey(p.Filename) {
 		useCheckSum = true
 	}
 
@@ -61,7 +68,12 @@ func (p *Plugin) Exec() error {
 		log.Fatal(err)
 	}
 
-	at, err := util.FromFilename(p.Filename)
+	at, err := util.FromFilenameWithOptions(p.Filename, archive.Options{
+		PreserveOwner: p.PreserveOwner,
+		Xattrs:        p.Xattrs,
+		Exclude:       p.Exclude,
+		Include:       p.Include,
+	})
 
 	if err != nil {
 		return err
@@ -72,31 +84,23 @@ func (p *Plugin) Exec() error {
 	path := pathutil.Join(p.Path, p.Filename)
 	fallbackPath := pathutil.Join(p.FallbackPath, p.Filename)
 
-	if p.Cacert != "" {
-		certPath := "/etc/ssl/certs/ca-certificates.crt"
-		log.Infof("Installing new ca certificate at %s", certPath)
-		err := installCaCert(certPath, p.Cacert)
-
-		if err == nil {
-			log.Info("Successfully installed new certificate")
-		}
+	mounts, keyed, err := parseMounts(p.Mount)
+	if err != nil {
+		return err
 	}
 
-	if p.CacertPath != "" {
-		certPath := "/etc/ssl/certs/ca-certificates.crt"
-		log.Infof("Installing new ca certificate at %s", certPath)
-		err := installCaCertFromPath(certPath, p.CacertPath)
-
-		if err == nil {
-			log.Info("Successfully installed new certificate")
-		}
+	if keyed && p.Mode != FlushMode {
+		return p.execMounts(c, mounts)
 	}
 
 	if p.Mode == RebuildMode {
 		log.Infof("Rebuilding cache at %s", path)
 		var exists bool
 		if useCheckSum {
-			exists, _ = p.Storage.Exists(path)
+			var eerr error
+			if exists, eerr = p.Storage.Exists(path); eerr != nil {
+				log.Warnf("Failed to check for cache at %s: %s", path, eerr)
+			}
 		}
 		if !exists {
 			err = c.Rebuild(p.Mount, path)
@@ -110,11 +114,27 @@ func (p *Plugin) Exec() error {
 
 	if p.Mode == RestoreMode {
 		log.Infof("Restoring cache at %s", path)
-		err = c.Restore(path, fallbackPath)
+		result, rerr := c.RestoreResult(path, fallbackPath)
 
-		if err == nil {
+		if rerr == nil {
 			log.Info("Cache restored")
 		}
+
+		if rerr != nil {
+			err = p.restoreFailed(path, rerr)
+		}
+
+		// Later steps read the hit file, failing to write it fails the step
+		// even when the restore may fail
+		if p.HitFile != "" {
+			if herr := writeHitFile(p.HitFile, []restoreResult{{Result: result}}); herr != nil && err == nil {
+				err = herr
+			}
+		}
+	}
+
+	if p.Mode == PromoteMode {
+		err = p.promote(path, fallbackPath)
 	}
 
 	if p.Mode == FlushMode {
@@ -130,24 +150,48 @@ func (p *Plugin) Exec() error {
 	return err
 }
 
-func genIsExpired(age int) cache.DirtyFunc {
-	return func(file storage.FileEntry) bool {
-		// Check if older than "age" days
-		return file.LastModified.Before(time.Now().AddDate(0, 0, age*-1))
+// promote copies the cache at path to the fallback path on the server.
+func (p *Plugin) promote(path, fallbackPath string) error {
+	if path == fallbackPath {
+		log.Infof("Cache skip, %s is already the fallback path", path)
+		return nil
 	}
-}
 
-func installCaCert(path, cacert string) error {
-	err := ioutil.WriteFile(path, []byte(cacert), 0644)
-	return err
-}
+	log.Infof("Promoting cache at %s to %s", path, fallbackPath)
 
-func installCaCertFromPath(path, cacertPath string) error {
-	cacert, err := ioutil.ReadFile(cacertPath)
-	if err != nil {
+	if err := p.Storage.Copy(path, fallbackPath); err != nil {
 		return err
 	}
 
-	err = ioutil.WriteFile(path, []byte(cacert), 0644)
-	return err
+	log.Info("Cache promoted")
+	return nil
+}
+
+// restoreFailed reports why the cache at path could not be restored. The
+// plugin should print an error but it should not return it, this is so the
+// build continues even if the cache cant be restored. Only strict mode fails
+// the step.
+func (p *Plugin) restoreFailed(path string, err error) error {
+	if cache.IsNotFound(err) {
+		if p.Strict {
+			return fmt.Errorf("Cache not found at %s: %s", path, err)
+		}
+
+		log.Warnf("Cache not found at %s: %s", path, err)
+		return nil
+	}
+
+	if p.Strict {
+		return fmt.Errorf("Cache could not be restored from %s: %s", path, err)
+	}
+
+	log.Errorf("Cache could not be restored from %s: %s", path, err)
+	return nil
+}
+
+func genIsExpired(age int) cache.DirtyFunc {
+	return func(file storage.FileEntry) bool {
+		// Check if older than "age" days
+		return file.LastModified.Before(time.Now().AddDate(0, 0, age*-1))
+	}
 }
@@ -0,0 +1,90 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/franela/goblin"
+	"github.com/yingce/drone-oss-cache/lib/cache/storage"
+	"github.com/yingce/drone-oss-cache/lib/cache/storage/memory"
+)
+
+func TestPlugin(t *testing.T) {
+	g := goblin.Goblin(t)
+
+	g.Describe("restore", func() {
+		var dir string
+
+		g.BeforeEach(func() {
+			dir, _ = ioutil.TempDir("", "plugin")
+		})
+
+		g.AfterEach(func() {
+			os.RemoveAll(dir)
+		})
+
+		g.It("Should write the hit file of a miss", func() {
+			p := &Plugin{
+				Mode:     RestoreMode,
+				Path:     "bucket/repo",
+				Filename: "archive.tar",
+				Mount:    []string{filepath.Join(dir, "mount")},
+				HitFile:  filepath.Join(dir, ".cache-result"),
+				Storage:  memory.New(),
+			}
+
+			g.Assert(p.Exec() == nil).IsTrue("expected a miss to pass")
+
+			content, _ := ioutil.ReadFile(p.HitFile)
+			g.Assert(strings.HasPrefix(string(content), "CACHE_HIT=miss\n")).IsTrue(string(content))
+		})
+
+		g.It("Should fail when the hit file cannot be written", func() {
+			p := &Plugin{
+				Mode:     RestoreMode,
+				Path:     "bucket/repo",
+				Filename: "archive.tar",
+				Mount:    []string{filepath.Join(dir, "mount")},
+				HitFile:  filepath.Join(dir, "missing", ".cache-result"),
+				Storage:  memory.New(),
+			}
+
+			g.Assert(p.Exec() != nil).IsTrue("expected a hit file error")
+		})
+	})
+	g.Describe("promote", func() {
+		var s storage.Storage
+		var p *Plugin
+
+		g.BeforeEach(func() {
+			s = memory.New()
+			p = &Plugin{Storage: s}
+		})
+
+		g.It("Should copy the cache to the fallback path", func() {
+			s.Put("bucket/feature/archive.tar", strings.NewReader("archive"))
+
+			err := p.promote("bucket/feature/archive.tar", "bucket/master/archive.tar")
+			g.Assert(err == nil).IsTrue("failed to promote")
+
+			var buf bytes.Buffer
+			s.Get("bucket/master/archive.tar", &buf)
+			g.Assert(buf.String()).Equal("archive")
+		})
+
+		g.It("Should skip the fallback path itself", func() {
+			err := p.promote("bucket/master/archive.tar", "bucket/master/archive.tar")
+			g.Assert(err == nil).IsTrue("expected a skip")
+		})
+
+		g.It("Should fail without a cache", func() {
+			err := p.promote("bucket/feature/archive.tar", "bucket/master/archive.tar")
+			g.Assert(errors.Is(err, storage.ErrNotFound)).IsTrue("expected a not found error")
+		})
+	})
+}
@@ -0,0 +1,164 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"strings"
+
+	"github.com/urfave/cli"
+	"github.com/yingce/drone-oss-cache/lib/cache/storage"
+
+	// Backends register their providers
+	_ "github.com/yingce/drone-oss-cache/storage/aliyun_oss"
+	_ "github.com/yingce/drone-oss-cache/storage/azblob"
+	_ "github.com/yingce/drone-oss-cache/storage/file"
+	_ "github.com/yingce/drone-oss-cache/storage/gcs"
+	_ "github.com/yingce/drone-oss-cache/storage/s3"
+	_ "github.com/yingce/drone-oss-cache/storage/sftp"
+	_ "github.com/yingce/drone-oss-cache/storage/webdav"
+)
+
+// defaultProvider is used when no provider is given.
+const defaultProvider = "s3"
+
+// providerFlags returns the <provider>_<option> flags of the registered
+// providers.
+func providerFlags() []cli.Flag {
+	var flags []cli.Flag
+
+	for _, p := range storage.Providers() {
+		for _, o := range p.Options {
+			name := flagName(p, o)
+			envVars := append([]string{"PLUGIN_" + strings.ToUpper(name)}, o.EnvVars...)
+
+			usage := o.Usage
+			if len(o.Shared) > 0 {
+				usage += ", defaults to " + o.Shared
+			}
+
+			switch o.Type {
+			case storage.Bool:
+				flags = append(flags, cli.BoolFlag{Name: name, Usage: usage, EnvVar: strings.Join(envVars, ",")})
+			case storage.Strings:
+				flags = append(flags, cli.StringSliceFlag{Name: name, Usage: usage, EnvVar: strings.Join(envVars, ",")})
+			default:
+				flags = append(flags, cli.StringFlag{Name: name, Usage: usage, EnvVar: strings.Join(envVars, ",")})
+			}
+		}
+	}
+
+	return flags
+}
+
+// providersHelp describes the registered providers and their options for
+// --help.
+func providersHelp() string {
+	var b strings.Builder
+
+	b.WriteString("Providers, selected with --provider or the scheme of --url:\n")
+
+	for _, p := range storage.Providers() {
+		name := p.Name
+		if len(p.Aliases) > 0 {
+			name += " (" + strings.Join(p.Aliases, ", ") + ")"
+		}
+
+		fmt.Fprintf(&b, "\n   %s: %s\n", name, p.Usage)
+
+		for _, o := range p.Options {
+			fmt.Fprintf(&b, "     %-22s %-7s %s\n", o.Name, o.Type, o.Usage)
+		}
+	}
+
+	b.WriteString("\n   URL options are set as query parameters, flags as --<provider>_<option>.")
+
+	return b.String()
+}
+
+// providerStorage creates the storage of a provider from its flags.
+func providerStorage(c *cli.Context, name string) (storage.Storage, error) {
+	if len(strings.TrimSpace(name)) == 0 {
+		name = defaultProvider
+	}
+
+	p, err := storage.Lookup(name)
+	if err != nil {
+		return nil, err
+	}
+
+	opts := url.Values{}
+
+	for _, o := range p.Options {
+		if v := optionValue(c, p, o); len(v) > 0 {
+			opts[o.Name] = v
+		}
+	}
+
+	return p.Open(opts)
+}
+
+// urlStorage creates the storage for the url flag, e.g.
+// oss://bucket/prefix?endpoint=...&region=..., with the flags overriding
+// the options of the URL. It returns the location of the URL as well.
+func urlStorage(c *cli.Context) (storage.Storage, string, error) {
+	u, err := url.Parse(c.String("url"))
+	if err != nil {
+		return nil, "", err
+	}
+
+	if len(u.Scheme) == 0 {
+		return nil, "", errors.New("Invalid url. Needs a scheme, e.g. s3://bucket/prefix")
+	}
+
+	p, err := storage.LookupScheme(u.Scheme)
+	if err != nil {
+		return nil, "", err
+	}
+
+	opts, location, err := p.URL(u)
+	if err != nil {
+		return nil, "", err
+	}
+
+	for _, o := range p.Options {
+		if v := optionValue(c, p, o); len(v) > 0 {
+			opts[o.Name] = v
+		}
+	}
+
+	s, err := p.Open(opts)
+
+	return s, location, err
+}
+
+// optionValue returns the value of the provider flag of an option, falling
+// back to the shared flag.
+func optionValue(c *cli.Context, p storage.Provider, o storage.Option) []string {
+	for _, flag := range []string{flagName(p, o), o.Shared} {
+		if len(flag) == 0 {
+			continue
+		}
+
+		switch o.Type {
+		case storage.Bool:
+			if c.Bool(flag) {
+				return []string{"true"}
+			}
+		case storage.Strings:
+			if v := c.StringSlice(flag); len(v) > 0 {
+				return v
+			}
+		default:
+			if v := c.String(flag); len(v) > 0 {
+				return []string{v}
+			}
+		}
+	}
+
+	return nil
+}
+
+func flagName(p storage.Provider, o storage.Option) string {
+	return p.Name + "_" + o.Name
+}
@@ -0,0 +1,81 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"strings"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/yingce/drone-oss-cache/lib/cache/cache"
+)
+
+// restoreResult is the outcome of restoring a mount, the name is empty when
+// all mounts share a single archive.
+type restoreResult struct {
+	Name   string
+	Result cache.Result
+}
+
+// writeHitFile writes the restore results in dotenv format so later steps can
+// source it. Keyed mounts get their variables suffixed with the mount name and
+// CACHE_HIT holds the worst hit over all mounts. Mounts mapping to the same
+// variables fail instead of overwriting each other.
+func writeHitFile(path string, results []restoreResult) error {
+	var buf bytes.Buffer
+
+	names := make(map[string]string)
+
+	hit := cache.HitExact
+	for _, r := range results {
+		suffix := envSuffix(r.Name)
+
+		if other, ok := names[suffix]; ok {
+			return fmt.Errorf("Mounts %s and %s both write CACHE_HIT%s. Needs to be renamed", other, r.Name, suffix)
+		}
+		names[suffix] = r.Name
+
+		// Results without a hit failed before the restore, e.g. evaluating
+		// the key
+		result := r.Result
+		if result.Hit == "" {
+			result.Hit = cache.HitMiss
+		}
+
+		fmt.Fprintf(&buf, "CACHE_HIT%s=%s\n", suffix, result.Hit)
+		fmt.Fprintf(&buf, "CACHE_KEY%s=%s\n", suffix, result.Path)
+		fmt.Fprintf(&buf, "CACHE_SIZE%s=%d\n", suffix, result.Size)
+		fmt.Fprintf(&buf, "CACHE_DURATION%s=%.3f\n", suffix, result.Duration.Seconds())
+
+		if result.Hit == cache.HitMiss || (result.Hit == cache.HitFallback && hit == cache.HitExact) {
+			hit = result.Hit
+		}
+	}
+
+	if len(results) != 1 || results[0].Name != "" {
+		fmt.Fprintf(&buf, "CACHE_HIT=%s\n", hit)
+	}
+
+	log.Infof("Writing cache result to %s", path)
+
+	return ioutil.WriteFile(path, buf.Bytes(), 0644)
+}
+
+// envSuffix turns a mount into a suffix for an environment variable, e.g.
+// node_modules becomes _NODE_MODULES.
+func envSuffix(name string) string {
+	if name == "" {
+		return ""
+	}
+
+	return "_" + strings.Map(func(r rune) rune {
+		switch {
+		case r >= 'a' && r <= 'z':
+			return r - 'a' + 'A'
+		case r >= 'A' && r <= 'Z', r >= '0' && r <= '9':
+			return r
+		default:
+			return '_'
+		}
+	}, strings.Trim(name, "./~"))
+}
@@ -0,0 +1,108 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/franela/goblin"
+	"github.com/yingce/drone-oss-cache/lib/cache/cache"
+)
+
+func TestResult(t *testing.T) {
+	g := goblin.Goblin(t)
+
+	g.Describe("envSuffix", func() {
+		g.It("Should turn mounts into variable suffixes", func() {
+			for name, suffix := range map[string]string{
+				"":                 "",
+				"node_modules":     "_NODE_MODULES",
+				"./.gocache":       "_GOCACHE",
+				"~/.m2/repository": "_M2_REPOSITORY",
+				"a-b":              "_A_B",
+				"Build2":           "_BUILD2",
+			} {
+				g.Assert(envSuffix(name)).Equal(suffix)
+			}
+		})
+	})
+
+	g.Describe("writeHitFile", func() {
+		var dir, path string
+
+		g.BeforeEach(func() {
+			dir, _ = ioutil.TempDir("", "result")
+			path = filepath.Join(dir, ".cache-result")
+		})
+
+		g.AfterEach(func() {
+			os.RemoveAll(dir)
+		})
+
+		tests := []struct {
+			name    string
+			results []restoreResult
+			want    string
+			fails   bool
+		}{
+			{
+				name: "Should write a single archive without suffix",
+				results: []restoreResult{
+					{Result: cache.Result{Hit: cache.HitExact, Path: "repo/archive.tar", Size: 42, Duration: 1500 * time.Millisecond}},
+				},
+				want: "CACHE_HIT=exact\nCACHE_KEY=repo/archive.tar\nCACHE_SIZE=42\nCACHE_DURATION=1.500\n",
+			},
+			{
+				name: "Should write the worst hit of the mounts",
+				results: []restoreResult{
+					{Name: "node_modules", Result: cache.Result{Hit: cache.HitExact, Path: "a"}},
+					{Name: ".gocache", Result: cache.Result{Hit: cache.HitFallback, Path: "b"}},
+				},
+				want: "CACHE_HIT_NODE_MODULES=exact\nCACHE_KEY_NODE_MODULES=a\nCACHE_SIZE_NODE_MODULES=0\nCACHE_DURATION_NODE_MODULES=0.000\n" +
+					"CACHE_HIT_GOCACHE=fallback\nCACHE_KEY_GOCACHE=b\nCACHE_SIZE_GOCACHE=0\nCACHE_DURATION_GOCACHE=0.000\n" +
+					"CACHE_HIT=fallback\n",
+			},
+			{
+				name: "Should write a miss when the key failed",
+				results: []restoreResult{
+					{Name: "node_modules", Result: cache.Result{Hit: cache.HitExact, Path: "a"}},
+					{Name: "vendor"},
+				},
+				want: "CACHE_HIT_NODE_MODULES=exact\nCACHE_KEY_NODE_MODULES=a\nCACHE_SIZE_NODE_MODULES=0\nCACHE_DURATION_NODE_MODULES=0.000\n" +
+					"CACHE_HIT_VENDOR=miss\nCACHE_KEY_VENDOR=\nCACHE_SIZE_VENDOR=0\nCACHE_DURATION_VENDOR=0.000\n" +
+					"CACHE_HIT=miss\n",
+			},
+			{
+				name: "Should fail when mounts share variables",
+				results: []restoreResult{
+					{Name: "a-b", Result: cache.Result{Hit: cache.HitExact}},
+					{Name: "a_b", Result: cache.Result{Hit: cache.HitExact}},
+				},
+				fails: true,
+			},
+		}
+
+		for _, tt := range tests {
+			tt := tt
+
+			g.It(tt.name, func() {
+				err := writeHitFile(path, tt.results)
+
+				if tt.fails {
+					g.Assert(err != nil).IsTrue("expected an error")
+
+					_, err = os.Stat(path)
+					g.Assert(os.IsNotExist(err)).IsTrue("expected no hit file")
+					return
+				}
+
+				g.Assert(err == nil).IsTrue("failed to write the hit file")
+
+				content, _ := ioutil.ReadFile(path)
+				g.Assert(string(content)).Equal(tt.want)
+			})
+		}
+	})
+}
@@ -0,0 +1,164 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/dustin/go-humanize"
+	log "github.com/sirupsen/logrus"
+	"github.com/urfave/cli"
+	"github.com/yingce/drone-oss-cache/lib/cache/storage"
+	"github.com/yingce/drone-oss-cache/server"
+	"github.com/yingce/drone-oss-cache/server/bazel"
+	"github.com/yingce/drone-oss-cache/server/ccache"
+	"github.com/yingce/drone-oss-cache/server/gradle"
+	"github.com/yingce/drone-oss-cache/server/turbo"
+)
+
+var serveFlags = []cli.Flag{
+	cli.StringFlag{
+		Name:   "addr",
+		Usage:  "address to listen on",
+		EnvVar: "PLUGIN_ADDR",
+		Value:  ":8080",
+	},
+	cli.StringFlag{
+		Name:   "gradle_max_size",
+		Usage:  "evict gradle build cache entries above this size, e.g. 10GB",
+		EnvVar: "PLUGIN_GRADLE_MAX_SIZE",
+	},
+	cli.StringFlag{
+		Name:   "ccache_memory_size",
+		Usage:  "memory used to keep small ccache entries, e.g. 64MB",
+		EnvVar: "PLUGIN_CCACHE_MEMORY_SIZE",
+		Value:  "64MB",
+	},
+	cli.DurationFlag{
+		Name:   "evict_interval",
+		Usage:  "how often to evict entries above the maximum size",
+		EnvVar: "PLUGIN_EVICT_INTERVAL",
+		Value:  time.Hour,
+	},
+	cli.StringSliceFlag{
+		Name:   "turbo_token",
+		Usage:  "bearer tokens accepted by the turborepo remote cache, basic auth applies without any",
+		EnvVar: "PLUGIN_TURBO_TOKEN",
+	},
+	cli.StringFlag{
+		Name:   "username",
+		Usage:  "basic auth username, disables auth when empty",
+		EnvVar: "PLUGIN_USERNAME",
+	},
+	cli.StringFlag{
+		Name:   "password",
+		Usage:  "basic auth password",
+		EnvVar: "PLUGIN_PASSWORD",
+	},
+}
+
+// serve runs the remote cache server on top of the configured storage.
+func serve(c *cli.Context) error {
+	if c.GlobalBool("debug") {
+		log.SetLevel(log.DebugLevel)
+	}
+
+	// Entries are kept below path, which starts with the bucket unless it is
+	// relative to the storage url
+	root := c.GlobalString("path")
+
+	s, location, err := newStorage(c.Parent())
+
+	if err != nil {
+		return err
+	}
+
+	defer storage.Close(s)
+
+	if len(location) > 0 {
+		root = prefixRoot(location, root)
+	} else if len(root) == 0 {
+		return errors.New("No path specified")
+	}
+
+	var gradleMaxSize uint64
+
+	if size := c.String("gradle_max_size"); len(size) > 0 {
+		if gradleMaxSize, err = humanize.ParseBytes(size); err != nil {
+			return err
+		}
+	}
+
+	ccacheMemorySize, err := humanize.ParseBytes(c.String("ccache_memory_size"))
+	if err != nil {
+		return err
+	}
+
+	// Bazel, Gradle and ccache use basic auth, Turborepo sends bearer tokens
+	// and falls back to basic auth without any
+	auth := func(h http.Handler) http.Handler {
+		return server.BasicAuth(c.String("username"), c.String("password"), h)
+	}
+
+	mux := http.NewServeMux()
+
+	bh := auth(bazel.New(s, &bazel.Options{Root: prefixRoot(root, "bazel")}))
+	mux.Handle("/ac/", bh)
+	mux.Handle("/cas/", bh)
+
+	gh := gradle.New(s, &gradle.Options{
+		Root:    prefixRoot(root, "gradle"),
+		MaxSize: int64(gradleMaxSize),
+	})
+	mux.Handle(gradle.Prefix, auth(gh))
+
+	ch := ccache.New(s, &ccache.Options{
+		Root:       prefixRoot(root, "ccache"),
+		MemorySize: int64(ccacheMemorySize),
+	})
+	mux.Handle(ccache.Prefix, auth(ch))
+
+	th := turbo.New(s, &turbo.Options{Root: prefixRoot(root, "turbo")})
+	if tokens := c.StringSlice("turbo_token"); len(tokens) > 0 {
+		mux.Handle(turbo.Prefix+"/", server.BearerAuth(tokens, th))
+	} else {
+		mux.Handle(turbo.Prefix+"/", auth(th))
+	}
+
+	mux.Handle("/stats", auth(server.StatsHandler(map[string]*server.Stats{
+		"ccache": ch.Stats(),
+		"gradle": gh.Stats(),
+		"turbo":  th.Stats(),
+	})))
+
+	go evict(c.Duration("evict_interval"), gh.Evict)
+
+	addr := c.String("addr")
+	log.Infof("Serving cache from %s on %s", root, addr)
+
+	// Entries can be large, so only the headers and idle connections are
+	// limited, not the transfer of the body
+	srv := &http.Server{
+		Addr:              addr,
+		Handler:           mux,
+		ReadHeaderTimeout: 30 * time.Second,
+		IdleTimeout:       2 * time.Minute,
+	}
+
+	return srv.ListenAndServe()
+}
+
+// evict runs the eviction functions every interval until the process exits.
+func evict(interval time.Duration, fns ...func() error) {
+	if interval <= 0 {
+		return
+	}
+
+	for range time.Tick(interval) {
+		for _, fn := range fns {
+			if err := fn(); err != nil {
+				log.Errorf("Failed to evict cache entries: %s", err)
+			}
+		}
+	}
+}
@@ -0,0 +1,100 @@
+package bazel
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	pathutil "path"
+	"regexp"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/yingce/drone-oss-cache/lib/cache/storage"
+	"github.com/yingce/drone-oss-cache/server"
+)
+
+// pathPattern matches /ac/<sha256> and /cas/<sha256>.
+var pathPattern = regexp.MustCompile(`^/(ac|cas)/([a-f0-9]{64})$`)
+
+// Options contains configuration for the Bazel remote cache.
+type Options struct {
+	// Root is the storage path the entries are kept under, e.g. bucket/bazel
+	Root string
+}
+
+type handler struct {
+	s    storage.Storage
+	opts *Options
+}
+
+// New creates a handler that speaks the Bazel HTTP remote cache protocol and
+// keeps the action cache and content addressable store in the storage.
+func New(s storage.Storage, opts *Options) http.Handler {
+	return &handler{
+		s:    s,
+		opts: opts,
+	}
+}
+
+func (h *handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	m := pathPattern.FindStringSubmatch(r.URL.Path)
+	if m == nil {
+		http.NotFound(w, r)
+		return
+	}
+
+	kind, hash := m[1], m[2]
+	p := pathutil.Join(h.opts.Root, kind, hash)
+
+	switch r.Method {
+	case http.MethodGet:
+		server.Get(w, h.s, p)
+	case http.MethodHead:
+		server.Head(w, h.s, p)
+	case http.MethodPut:
+		if kind == "cas" {
+			h.putCAS(w, r, p, hash)
+			return
+		}
+		server.Put(w, h.s, p, r.Body)
+	default:
+		w.Header().Set("Allow", "GET, HEAD, PUT")
+		http.Error(w, "Method Not Allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// putCAS stores a blob after verifying its content matches the digest. The
+// body is spooled to disk first so a bad blob never reaches the storage.
+func (h *handler) putCAS(w http.ResponseWriter, r *http.Request, p, hash string) {
+	f, err := ioutil.TempFile("", "cas")
+	if err != nil {
+		log.Errorf("Failed to create temporary file: %s", err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+	defer os.Remove(f.Name())
+	defer f.Close()
+
+	digest := sha256.New()
+	if _, err := io.Copy(io.MultiWriter(f, digest), r.Body); err != nil {
+		log.Errorf("Failed to read %s: %s", p, err)
+		http.Error(w, "Bad Request", http.StatusBadRequest)
+		return
+	}
+
+	if sum := hex.EncodeToString(digest.Sum(nil)); sum != hash {
+		log.Warnf("Rejecting %s with digest %s", p, sum)
+		http.Error(w, "Digest mismatch", http.StatusBadRequest)
+		return
+	}
+
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		log.Errorf("Failed to rewind temporary file: %s", err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+
+	server.Put(w, h.s, p, f)
+}
@@ -0,0 +1,100 @@
+package bazel
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/franela/goblin"
+	"github.com/yingce/drone-oss-cache/lib/cache/storage/memory"
+	"github.com/yingce/drone-oss-cache/server"
+)
+
+func TestBazel(t *testing.T) {
+	g := goblin.Goblin(t)
+
+	g.Describe("bazel handler", func() {
+		var h http.Handler
+
+		g.BeforeEach(func() {
+			h = New(memory.New(), &Options{Root: "bucket/bazel"})
+		})
+
+		g.It("Should store and return a CAS blob", func() {
+			w := do(h, http.MethodPut, "/cas/"+blobHash, blob)
+			g.Assert(w.Code).Equal(http.StatusOK)
+
+			w = do(h, http.MethodHead, "/cas/"+blobHash, "")
+			g.Assert(w.Code).Equal(http.StatusOK)
+
+			w = do(h, http.MethodGet, "/cas/"+blobHash, "")
+			g.Assert(w.Code).Equal(http.StatusOK)
+			g.Assert(w.Body.String()).Equal(blob)
+		})
+
+		g.It("Should reject a CAS blob with the wrong digest", func() {
+			w := do(h, http.MethodPut, "/cas/"+blobHash, "something else")
+			g.Assert(w.Code).Equal(http.StatusBadRequest)
+
+			w = do(h, http.MethodGet, "/cas/"+blobHash, "")
+			g.Assert(w.Code).Equal(http.StatusNotFound)
+		})
+
+		g.It("Should store action results without verification", func() {
+			w := do(h, http.MethodPut, "/ac/"+blobHash, "action result")
+			g.Assert(w.Code).Equal(http.StatusOK)
+
+			w = do(h, http.MethodGet, "/ac/"+blobHash, "")
+			g.Assert(w.Body.String()).Equal("action result")
+		})
+
+		g.It("Should return 404 for a missing entry", func() {
+			w := do(h, http.MethodGet, "/ac/"+blobHash, "")
+			g.Assert(w.Code).Equal(http.StatusNotFound)
+
+			w = do(h, http.MethodHead, "/ac/"+blobHash, "")
+			g.Assert(w.Code).Equal(http.StatusNotFound)
+		})
+
+		g.It("Should return 404 for an invalid path", func() {
+			w := do(h, http.MethodGet, "/cas/not-a-digest", "")
+			g.Assert(w.Code).Equal(http.StatusNotFound)
+		})
+
+		g.It("Should reject other methods", func() {
+			w := do(h, http.MethodDelete, "/ac/"+blobHash, "")
+			g.Assert(w.Code).Equal(http.StatusMethodNotAllowed)
+		})
+
+		g.It("Should require basic auth when configured", func() {
+			auth := server.BasicAuth("bazel", "secret", h)
+
+			w := do(auth, http.MethodGet, "/ac/"+blobHash, "")
+			g.Assert(w.Code).Equal(http.StatusUnauthorized)
+
+			r := httptest.NewRequest(http.MethodGet, "/ac/"+blobHash, nil)
+			r.SetBasicAuth("bazel", "secret")
+			w = httptest.NewRecorder()
+			auth.ServeHTTP(w, r)
+			g.Assert(w.Code).Equal(http.StatusNotFound)
+		})
+	})
+}
+
+func do(h http.Handler, method, path, body string) *httptest.ResponseRecorder {
+	r := httptest.NewRequest(method, path, strings.NewReader(body))
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, r)
+	return w
+}
+
+var (
+	blob     = "hello bazel"
+	blobHash = func() string {
+		sum := sha256.Sum256([]byte(blob))
+		return hex.EncodeToString(sum[:])
+	}()
+)
@@ -0,0 +1,200 @@
+package ccache
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"net/http"
+	pathutil "path"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/yingce/drone-oss-cache/lib/cache/storage"
+	"github.com/yingce/drone-oss-cache/server"
+)
+
+// Prefix is the path the remote storage is served at.
+const Prefix = "/ccache/"
+
+// keyPattern matches the flat and subdirs layouts of ccache.
+var keyPattern = regexp.MustCompile(`^[A-Za-z0-9_-]+(/[A-Za-z0-9_-]+)?$`)
+
+// Options contains configuration for the ccache remote storage.
+type Options struct {
+	// Root is the storage path the entries are kept under, e.g. bucket/ccache
+	Root string
+
+	// MemorySize is the size of the in-memory front cache, 0 disables it.
+	// Entries larger than an eighth of it are never kept in memory. The disk
+	// tier is the storage itself when serve runs with local_cache.
+	MemorySize int64
+}
+
+// Handler proxies the ccache HTTP remote storage to the storage.
+type Handler struct {
+	s     storage.Storage
+	opts  *Options
+	lru   *lru
+	stats server.Stats
+
+	// writes counts the puts and deletes, a read that overlaps one of them
+	// may have seen the old entry and must not fill the memory cache
+	mu     sync.Mutex
+	writes uint64
+}
+
+// New creates a handler that keeps the ccache entries in the storage with a
+// small in-memory cache in front of it.
+func New(s storage.Storage, opts *Options) *Handler {
+	return &Handler{
+		s:    s,
+		opts: opts,
+		lru:  newLRU(opts.MemorySize),
+	}
+}
+
+func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	key := strings.TrimPrefix(r.URL.Path, Prefix)
+	if key == r.URL.Path || !keyPattern.MatchString(key) {
+		http.NotFound(w, r)
+		return
+	}
+
+	p := pathutil.Join(h.opts.Root, key)
+
+	switch r.Method {
+	case http.MethodGet:
+		h.get(w, p)
+	case http.MethodHead:
+		if _, ok := h.lru.Get(p); ok {
+			w.WriteHeader(http.StatusOK)
+			return
+		}
+		server.Head(w, h.s, p)
+	case http.MethodPut:
+		h.put(w, r, p)
+	case http.MethodDelete:
+		h.update(p, nil)
+		err := h.s.Delete(p)
+		h.update(p, nil)
+
+		switch {
+		case err == nil:
+			w.WriteHeader(http.StatusOK)
+		case errors.Is(err, storage.ErrNotFound):
+			http.NotFound(w, r)
+		default:
+			log.Errorf("Failed to delete %s: %s", p, err)
+			http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		}
+	default:
+		w.Header().Set("Allow", "GET, HEAD, PUT, DELETE")
+		http.Error(w, "Method Not Allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// Stats returns the request counters of the remote storage.
+func (h *Handler) Stats() *server.Stats {
+	return &h.stats
+}
+
+func (h *Handler) get(w http.ResponseWriter, p string) {
+	if data, ok := h.lru.Get(p); ok {
+		log.Debugf("Memory hit for %s", p)
+		h.stats.Hit()
+
+		w.Header().Set("Content-Type", "application/octet-stream")
+		w.Header().Set("Content-Length", strconv.Itoa(len(data)))
+		w.Write(data)
+		return
+	}
+
+	// Stream the entry while keeping a copy for the memory cache
+	h.mu.Lock()
+	writes := h.writes
+	h.mu.Unlock()
+
+	buf := &limitedBuffer{limit: h.entryLimit()}
+	err := server.Get(&teeResponseWriter{ResponseWriter: w, buf: buf}, h.s, p)
+	h.stats.Get(err)
+
+	// A failed read leaves a truncated copy behind
+	if err != nil || buf.overflow {
+		return
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if h.writes == writes {
+		h.lru.Add(p, buf.Bytes())
+	}
+}
+
+func (h *Handler) put(w http.ResponseWriter, r *http.Request, p string) {
+	h.update(p, nil)
+
+	buf := &limitedBuffer{limit: h.entryLimit()}
+	if !server.Put(w, h.s, p, io.TeeReader(r.Body, buf)) {
+		h.update(p, nil)
+		return
+	}
+	h.stats.Put()
+
+	if buf.overflow {
+		h.update(p, nil)
+	} else {
+		h.update(p, buf.Bytes())
+	}
+}
+
+// update replaces the entry in memory with data, or removes it when nil. It
+// runs before and after every write of the storage so overlapping reads do
+// not keep what they read.
+func (h *Handler) update(p string, data []byte) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	h.writes++
+	h.lru.Remove(p)
+
+	if data != nil {
+		h.lru.Add(p, data)
+	}
+}
+
+// entryLimit is the largest entry kept in memory.
+func (h *Handler) entryLimit() int {
+	return int(h.opts.MemorySize / 8)
+}
+
+// limitedBuffer keeps what is written to it until the limit is exceeded.
+type limitedBuffer struct {
+	bytes.Buffer
+	limit    int
+	overflow bool
+}
+
+func (b *limitedBuffer) Write(p []byte) (int, error) {
+	if !b.overflow && b.Len()+len(p) <= b.limit {
+		return b.Buffer.Write(p)
+	}
+
+	b.overflow = true
+	b.Reset()
+	return len(p), nil
+}
+
+// teeResponseWriter copies the body into a limited buffer.
+type teeResponseWriter struct {
+	http.ResponseWriter
+	buf *limitedBuffer
+}
+
+func (w *teeResponseWriter) Write(p []byte) (int, error) {
+	w.buf.Write(p)
+	return w.ResponseWriter.Write(p)
+}
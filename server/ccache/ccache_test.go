@@ -0,0 +1,175 @@
+package ccache
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/franela/goblin"
+	"github.com/yingce/drone-oss-cache/lib/cache/storage"
+	"github.com/yingce/drone-oss-cache/lib/cache/storage/memory"
+)
+
+func TestCcache(t *testing.T) {
+	g := goblin.Goblin(t)
+
+	g.Describe("ccache handler", func() {
+		var s storage.Storage
+		var h *Handler
+
+		g.BeforeEach(func() {
+			s = memory.New()
+			h = New(s, &Options{Root: "bucket/ccache", MemorySize: 1024})
+		})
+
+		g.It("Should store and return an entry", func() {
+			w := do(h, http.MethodPut, "/ccache/ab/cdef0123", "object")
+			g.Assert(w.Code).Equal(http.StatusOK)
+
+			w = do(h, http.MethodHead, "/ccache/ab/cdef0123", "")
+			g.Assert(w.Code).Equal(http.StatusOK)
+
+			w = do(h, http.MethodGet, "/ccache/ab/cdef0123", "")
+			g.Assert(w.Code).Equal(http.StatusOK)
+			g.Assert(w.Body.String()).Equal("object")
+		})
+
+		g.It("Should serve entries from memory", func() {
+			s.Put("bucket/ccache/abcdef", strings.NewReader("object"))
+
+			w := do(h, http.MethodGet, "/ccache/abcdef", "")
+			g.Assert(w.Body.String()).Equal("object")
+
+			// Gone from the storage but still in memory
+			s.Delete("bucket/ccache/abcdef")
+
+			w = do(h, http.MethodGet, "/ccache/abcdef", "")
+			g.Assert(w.Code).Equal(http.StatusOK)
+			g.Assert(w.Body.String()).Equal("object")
+		})
+
+		g.It("Should not keep large entries in memory", func() {
+			large := strings.Repeat("x", 512)
+			do(h, http.MethodPut, "/ccache/abcdef", large)
+
+			_, ok := h.lru.Get("bucket/ccache/abcdef")
+			g.Assert(ok).IsFalse("kept a large entry in memory")
+
+			w := do(h, http.MethodGet, "/ccache/abcdef", "")
+			g.Assert(w.Body.String()).Equal(large)
+		})
+
+		g.It("Should not keep entries that failed halfway in memory", func() {
+			s.Put("bucket/ccache/abcdef", strings.NewReader("object"))
+			h = New(&truncatingStorage{Storage: s}, &Options{Root: "bucket/ccache", MemorySize: 1024})
+
+			w := do(h, http.MethodGet, "/ccache/abcdef", "")
+			g.Assert(w.Body.String()).Equal("obj")
+
+			_, ok := h.lru.Get("bucket/ccache/abcdef")
+			g.Assert(ok).IsFalse("kept a truncated entry in memory")
+
+			stats := h.Stats().Snapshot()
+			g.Assert(stats.Hits).Equal(uint64(0))
+			g.Assert(stats.Misses).Equal(uint64(0))
+		})
+
+		g.It("Should not keep entries replaced while reading", func() {
+			s.Put("bucket/ccache/abcdef", strings.NewReader("old"))
+			slow := &slowStorage{Storage: s, read: make(chan struct{}), release: make(chan struct{})}
+			h = New(slow, &Options{Root: "bucket/ccache", MemorySize: 1024})
+
+			done := make(chan struct{})
+			go func() {
+				do(h, http.MethodGet, "/ccache/abcdef", "")
+				close(done)
+			}()
+
+			<-slow.read
+			do(h, http.MethodPut, "/ccache/abcdef", "new")
+			close(slow.release)
+			<-done
+
+			data, ok := h.lru.Get("bucket/ccache/abcdef")
+			g.Assert(ok).IsTrue("expected the new entry in memory")
+			g.Assert(string(data)).Equal("new")
+		})
+
+		g.It("Should delete an entry", func() {
+			do(h, http.MethodPut, "/ccache/abcdef", "object")
+
+			w := do(h, http.MethodDelete, "/ccache/abcdef", "")
+			g.Assert(w.Code).Equal(http.StatusOK)
+
+			w = do(h, http.MethodGet, "/ccache/abcdef", "")
+			g.Assert(w.Code).Equal(http.StatusNotFound)
+
+			w = do(h, http.MethodDelete, "/ccache/abcdef", "")
+			g.Assert(w.Code).Equal(http.StatusNotFound)
+		})
+	})
+
+	g.Describe("lru", func() {
+		g.It("Should evict the least recently used entries", func() {
+			c := newLRU(10)
+			c.Add("a", []byte("aaaa"))
+			c.Add("b", []byte("bbbb"))
+			c.Get("a")
+			c.Add("c", []byte("cccc"))
+
+			_, ok := c.Get("b")
+			g.Assert(ok).IsFalse("failed to evict b")
+			_, ok = c.Get("a")
+			g.Assert(ok).IsTrue("evicted a")
+			_, ok = c.Get("c")
+			g.Assert(ok).IsTrue("evicted c")
+		})
+	})
+}
+
+// truncatingStorage fails halfway through every read.
+type truncatingStorage struct {
+	storage.Storage
+}
+
+func (s *truncatingStorage) Get(p string, dst io.Writer) error {
+	var buf bytes.Buffer
+	if err := s.Storage.Get(p, &buf); err != nil {
+		return err
+	}
+
+	dst.Write(buf.Bytes()[:buf.Len()/2])
+	return errors.New("connection reset")
+}
+
+// slowStorage signals read once it read an entry and waits for release
+// before sending it.
+type slowStorage struct {
+	storage.Storage
+	read    chan struct{}
+	release chan struct{}
+}
+
+func (s *slowStorage) Get(p string, dst io.Writer) error {
+	var buf bytes.Buffer
+	if err := s.Storage.Get(p, &buf); err != nil {
+		return err
+	}
+
+	close(s.read)
+	<-s.release
+
+	_, err := dst.Write(buf.Bytes())
+	return err
+}
+
+func do(h http.Handler, method, path, body string) *httptest.ResponseRecorder {
+	r := httptest.NewRequest(method, path, strings.NewReader(body))
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, r)
+	return w
+}
@@ -0,0 +1,80 @@
+package ccache
+
+import (
+	"container/list"
+	"sync"
+)
+
+type entry struct {
+	key  string
+	data []byte
+}
+
+// lru keeps the most recently used entries in memory up to a total size.
+type lru struct {
+	mu      sync.Mutex
+	maxSize int64
+	size    int64
+	ll      *list.List
+	items   map[string]*list.Element
+}
+
+func newLRU(maxSize int64) *lru {
+	return &lru{
+		maxSize: maxSize,
+		ll:      list.New(),
+		items:   make(map[string]*list.Element),
+	}
+}
+
+// Get returns the entry and marks it as recently used.
+func (c *lru) Get(key string) ([]byte, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	el, ok := c.items[key]
+	if !ok {
+		return nil, false
+	}
+
+	c.ll.MoveToFront(el)
+	return el.Value.(*entry).data, true
+}
+
+// Add stores the entry and evicts the least recently used entries until it
+// fits.
+func (c *lru) Add(key string, data []byte) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.maxSize <= 0 || int64(len(data)) > c.maxSize {
+		return
+	}
+
+	if el, ok := c.items[key]; ok {
+		c.removeElement(el)
+	}
+
+	c.items[key] = c.ll.PushFront(&entry{key: key, data: data})
+	c.size += int64(len(data))
+
+	for c.size > c.maxSize {
+		c.removeElement(c.ll.Back())
+	}
+}
+
+// Remove drops the entry.
+func (c *lru) Remove(key string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if el, ok := c.items[key]; ok {
+		c.removeElement(el)
+	}
+}
+
+func (c *lru) removeElement(el *list.Element) {
+	e := c.ll.Remove(el).(*entry)
+	delete(c.items, e.key)
+	c.size -= int64(len(e.data))
+}
@@ -0,0 +1,84 @@
+package gradle
+
+import (
+	"net/http"
+	pathutil "path"
+	"regexp"
+	"strings"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/yingce/drone-oss-cache/lib/cache/cache"
+	"github.com/yingce/drone-oss-cache/lib/cache/storage"
+	"github.com/yingce/drone-oss-cache/server"
+)
+
+// Prefix is the path the build cache is served at.
+const Prefix = "/cache/"
+
+// keyPattern matches the cache keys Gradle sends.
+var keyPattern = regexp.MustCompile(`^[A-Za-z0-9._-]+$`)
+
+// Options contains configuration for the Gradle build cache.
+type Options struct {
+	// Root is the storage path the entries are kept under, e.g. bucket/gradle
+	Root string
+
+	// MaxSize evicts the least recently stored entries once all entries
+	// together are larger, 0 disables eviction
+	MaxSize int64
+}
+
+// Handler speaks the Gradle HTTP build cache protocol.
+type Handler struct {
+	s     storage.Storage
+	opts  *Options
+	stats server.Stats
+}
+
+// New creates a handler that keeps the Gradle build cache entries in the
+// storage.
+func New(s storage.Storage, opts *Options) *Handler {
+	return &Handler{
+		s:    s,
+		opts: opts,
+	}
+}
+
+func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	key := strings.TrimPrefix(r.URL.Path, Prefix)
+	if key == r.URL.Path || !keyPattern.MatchString(key) {
+		http.NotFound(w, r)
+		return
+	}
+
+	p := pathutil.Join(h.opts.Root, key)
+
+	switch r.Method {
+	case http.MethodGet:
+		h.stats.Get(server.Get(w, h.s, p))
+	case http.MethodPut:
+		if server.Put(w, h.s, p, r.Body) {
+			h.stats.Put()
+		}
+	default:
+		w.Header().Set("Allow", "GET, PUT")
+		http.Error(w, "Method Not Allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// Stats returns the request counters of the build cache.
+func (h *Handler) Stats() *server.Stats {
+	return &h.stats
+}
+
+// Evict removes the least recently stored entries above the maximum size.
+func (h *Handler) Evict() error {
+	if h.opts.MaxSize <= 0 {
+		return nil
+	}
+
+	log.Debugf("Evicting build cache entries at %s", h.opts.Root)
+
+	f := cache.NewSizeFlusher(h.s, h.opts.MaxSize)
+	return f.Flush(h.opts.Root)
+}
@@ -0,0 +1,101 @@
+package gradle
+
+import (
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/franela/goblin"
+	"github.com/yingce/drone-oss-cache/lib/cache/storage"
+	"github.com/yingce/drone-oss-cache/lib/cache/storage/memory"
+)
+
+func TestGradle(t *testing.T) {
+	g := goblin.Goblin(t)
+
+	g.Describe("gradle handler", func() {
+		var s storage.Storage
+		var h *Handler
+
+		g.BeforeEach(func() {
+			s = memory.New()
+			h = New(s, &Options{Root: "bucket/gradle", MaxSize: 10})
+		})
+
+		g.It("Should store and return an entry", func() {
+			w := do(h, http.MethodPut, "/cache/abc123", "entry")
+			g.Assert(w.Code).Equal(http.StatusOK)
+
+			w = do(h, http.MethodGet, "/cache/abc123", "")
+			g.Assert(w.Code).Equal(http.StatusOK)
+			g.Assert(w.Body.String()).Equal("entry")
+		})
+
+		g.It("Should return 404 for a missing entry", func() {
+			w := do(h, http.MethodGet, "/cache/abc123", "")
+			g.Assert(w.Code).Equal(http.StatusNotFound)
+		})
+
+		g.It("Should return 404 for an invalid key", func() {
+			w := do(h, http.MethodGet, "/cache/../secret", "")
+			g.Assert(w.Code).Equal(http.StatusNotFound)
+		})
+
+		g.It("Should count hits, misses and puts", func() {
+			do(h, http.MethodPut, "/cache/abc123", "entry")
+			do(h, http.MethodGet, "/cache/abc123", "")
+			do(h, http.MethodGet, "/cache/def456", "")
+
+			stats := h.Stats().Snapshot()
+			g.Assert(stats.Hits).Equal(uint64(1))
+			g.Assert(stats.Misses).Equal(uint64(1))
+			g.Assert(stats.Puts).Equal(uint64(1))
+		})
+
+		g.It("Should not count storage failures as misses", func() {
+			h = New(&failingStorage{Storage: s}, &Options{Root: "bucket/gradle"})
+
+			w := do(h, http.MethodGet, "/cache/abc123", "")
+			g.Assert(w.Code).Equal(http.StatusInternalServerError)
+
+			stats := h.Stats().Snapshot()
+			g.Assert(stats.Hits).Equal(uint64(0))
+			g.Assert(stats.Misses).Equal(uint64(0))
+		})
+
+		g.It("Should evict the oldest entries above the maximum size", func() {
+			h = New(s, &Options{Root: "bucket/gradle", MaxSize: 15})
+
+			do(h, http.MethodPut, "/cache/abc123", "entry")
+			time.Sleep(10 * time.Millisecond)
+			do(h, http.MethodPut, "/cache/def456", "another entry")
+
+			err := h.Evict()
+			g.Assert(err == nil).IsTrue("failed to evict")
+
+			files, _ := s.List("bucket/gradle")
+			g.Assert(len(files)).Equal(1)
+			g.Assert(files[0].Path).Equal("bucket/gradle/def456")
+		})
+	})
+}
+
+// failingStorage fails to read any entry.
+type failingStorage struct {
+	storage.Storage
+}
+
+func (s *failingStorage) Get(p string, dst io.Writer) error {
+	return errors.New("connection reset")
+}
+
+func do(h http.Handler, method, path, body string) *httptest.ResponseRecorder {
+	r := httptest.NewRequest(method, path, strings.NewReader(body))
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, r)
+	return w
+}
@@ -0,0 +1,127 @@
+package server
+
+import (
+	"crypto/subtle"
+	"errors"
+	"io"
+	"net/http"
+	"strings"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/yingce/drone-oss-cache/lib/cache/storage"
+)
+
+// BasicAuth protects the handler with basic auth. An empty username disables
+// the check.
+func BasicAuth(username, password string, h http.Handler) http.Handler {
+	if username == "" {
+		return h
+	}
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		user, pass, ok := r.BasicAuth()
+
+		if !ok ||
+			subtle.ConstantTimeCompare([]byte(user), []byte(username)) != 1 ||
+			subtle.ConstantTimeCompare([]byte(pass), []byte(password)) != 1 {
+			w.Header().Set("WWW-Authenticate", `Basic realm="cache"`)
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+
+		h.ServeHTTP(w, r)
+	})
+}
+
+// BearerAuth protects the handler with bearer tokens. No tokens disable the
+// check.
+func BearerAuth(tokens []string, h http.Handler) http.Handler {
+	if len(tokens) == 0 {
+		return h
+	}
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		header := r.Header.Get("Authorization")
+		if !strings.HasPrefix(header, "Bearer ") {
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+		token := strings.TrimPrefix(header, "Bearer ")
+
+		for _, t := range tokens {
+			if subtle.ConstantTimeCompare([]byte(token), []byte(t)) == 1 {
+				h.ServeHTTP(w, r)
+				return
+			}
+		}
+
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+	})
+}
+
+// responseWriter remembers whether the body has been started, after that the
+// status can no longer be changed.
+type responseWriter struct {
+	w       http.ResponseWriter
+	written bool
+}
+
+func (rw *responseWriter) Write(p []byte) (int, error) {
+	rw.written = true
+	return rw.w.Write(p)
+}
+
+// Get streams the file at p to the response, or responds with 404 when it
+// does not exist. It returns nil only when the whole file was sent, and an
+// error wrapping storage.ErrNotFound when it does not exist.
+func Get(w http.ResponseWriter, s storage.Storage, p string) error {
+	w.Header().Set("Content-Type", "application/octet-stream")
+
+	rw := &responseWriter{w: w}
+	err := s.Get(p, rw)
+
+	switch {
+	case err == nil:
+		if !rw.written {
+			w.WriteHeader(http.StatusOK)
+		}
+	case rw.written:
+		// Too late to report it, the client sees a truncated body
+		log.Errorf("Failed to send %s: %s", p, err)
+	case errors.Is(err, storage.ErrNotFound):
+		log.Debugf("Cache miss for %s", p)
+		http.Error(w, "Not Found", http.StatusNotFound)
+	default:
+		log.Errorf("Failed to retrieve %s: %s", p, err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+	}
+
+	return err
+}
+
+// Head responds with 200 when the file at p exists and 404 otherwise.
+func Head(w http.ResponseWriter, s storage.Storage, p string) {
+	exists, err := s.Exists(p)
+
+	switch {
+	case err != nil:
+		log.Errorf("Failed to check %s: %s", p, err)
+		w.WriteHeader(http.StatusInternalServerError)
+	case exists:
+		w.WriteHeader(http.StatusOK)
+	default:
+		w.WriteHeader(http.StatusNotFound)
+	}
+}
+
+// Put stores the body at p. It reports whether the body was stored.
+func Put(w http.ResponseWriter, s storage.Storage, p string, body io.Reader) bool {
+	if err := s.Put(p, body); err != nil {
+		log.Errorf("Failed to store %s: %s", p, err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return false
+	}
+
+	w.WriteHeader(http.StatusOK)
+	return true
+}
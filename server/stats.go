@@ -0,0 +1,66 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"sync/atomic"
+
+	"github.com/yingce/drone-oss-cache/lib/cache/storage"
+)
+
+// Stats counts the requests of a cache.
+type Stats struct {
+	hits   uint64
+	misses uint64
+	puts   uint64
+}
+
+// StatsSnapshot is a copy of the counters at one point in time.
+type StatsSnapshot struct {
+	Hits   uint64 `json:"hits"`
+	Misses uint64 `json:"misses"`
+	Puts   uint64 `json:"puts"`
+}
+
+// Hit counts a request that found an entry.
+func (s *Stats) Hit() { atomic.AddUint64(&s.hits, 1) }
+
+// Miss counts a request that did not find an entry.
+func (s *Stats) Miss() { atomic.AddUint64(&s.misses, 1) }
+
+// Get counts the result of server.Get, a hit when the entry was sent and a
+// miss when it does not exist. Failures of the storage are not counted.
+func (s *Stats) Get(err error) {
+	switch {
+	case err == nil:
+		s.Hit()
+	case errors.Is(err, storage.ErrNotFound):
+		s.Miss()
+	}
+}
+
+// Put counts a stored entry.
+func (s *Stats) Put() { atomic.AddUint64(&s.puts, 1) }
+
+// Snapshot returns the current counters.
+func (s *Stats) Snapshot() StatsSnapshot {
+	return StatsSnapshot{
+		Hits:   atomic.LoadUint64(&s.hits),
+		Misses: atomic.LoadUint64(&s.misses),
+		Puts:   atomic.LoadUint64(&s.puts),
+	}
+}
+
+// StatsHandler responds with the counters of every named cache as JSON.
+func StatsHandler(stats map[string]*Stats) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		snapshots := make(map[string]StatsSnapshot, len(stats))
+		for name, s := range stats {
+			snapshots[name] = s.Snapshot()
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(snapshots)
+	})
+}
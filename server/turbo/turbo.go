@@ -0,0 +1,179 @@
+package turbo
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io"
+	"io/ioutil"
+	"net/http"
+	pathutil "path"
+	"regexp"
+	"strings"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/yingce/drone-oss-cache/lib/cache/storage"
+	"github.com/yingce/drone-oss-cache/server"
+)
+
+const (
+	// Prefix is the path the artifacts are served at.
+	Prefix = "/v8/artifacts"
+
+	// tagHeader holds the signature of an artifact, durationHeader the time
+	// it took to create it in milliseconds.
+	tagHeader      = "x-artifact-tag"
+	durationHeader = "x-artifact-duration"
+
+	// metadataSuffix is appended to an artifact for the object that keeps
+	// its headers. Hashes have no dots, so it never is an artifact.
+	metadataSuffix = ".json"
+)
+
+var (
+	// hashPattern matches the task hashes Turborepo sends.
+	hashPattern = regexp.MustCompile(`^[A-Za-z0-9]+$`)
+	// teamPattern matches the team id or slug used as a directory.
+	teamPattern = regexp.MustCompile(`^[A-Za-z0-9_-]+$`)
+)
+
+// Options contains configuration for the Turborepo remote cache.
+type Options struct {
+	// Root is the storage path the artifacts are kept under, e.g. bucket/turbo
+	Root string
+}
+
+// metadata are the headers sent with an artifact.
+type metadata struct {
+	Tag      string `json:"tag,omitempty"`
+	Duration string `json:"duration,omitempty"`
+}
+
+// Handler speaks the Turborepo remote cache API.
+type Handler struct {
+	s     storage.Storage
+	opts  *Options
+	stats server.Stats
+}
+
+// New creates a handler that keeps the Turborepo artifacts in the storage,
+// one directory per team.
+func New(s storage.Storage, opts *Options) *Handler {
+	return &Handler{
+		s:    s,
+		opts: opts,
+	}
+}
+
+func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	rest := strings.TrimPrefix(r.URL.Path, Prefix)
+
+	// Usage events are accepted but not recorded
+	if rest == "/events" && r.Method == http.MethodPost {
+		io.Copy(ioutil.Discard, r.Body)
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+
+	hash := strings.TrimPrefix(rest, "/")
+	if hash == rest || !hashPattern.MatchString(hash) {
+		http.NotFound(w, r)
+		return
+	}
+
+	// Artifacts are scoped by team, given as id or slug
+	team := r.URL.Query().Get("teamId")
+	if team == "" {
+		team = r.URL.Query().Get("slug")
+	}
+	if !teamPattern.MatchString(team) {
+		http.Error(w, "Missing or invalid teamId or slug", http.StatusBadRequest)
+		return
+	}
+
+	p := pathutil.Join(h.opts.Root, team, hash)
+
+	switch r.Method {
+	case http.MethodGet:
+		h.writeMetadata(w, p)
+		h.stats.Get(server.Get(w, h.s, p))
+	case http.MethodHead:
+		h.writeMetadata(w, p)
+		server.Head(w, h.s, p)
+	case http.MethodPut:
+		if err := h.s.Put(p, r.Body); err != nil {
+			log.Errorf("Failed to store %s: %s", p, err)
+			http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+			return
+		}
+
+		if err := h.putMetadata(p, r.Header); err != nil {
+			log.Errorf("Failed to store the metadata of %s: %s", p, err)
+			http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+			return
+		}
+		h.stats.Put()
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string][]string{
+			"urls": {pathutil.Join(team, hash)},
+		})
+	default:
+		w.Header().Set("Allow", "GET, HEAD, PUT")
+		http.Error(w, "Method Not Allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// putMetadata stores the signature and duration sent with the artifact at p,
+// or removes the ones of an artifact it replaces.
+func (h *Handler) putMetadata(p string, header http.Header) error {
+	m := metadata{
+		Tag:      header.Get(tagHeader),
+		Duration: header.Get(durationHeader),
+	}
+
+	if m == (metadata{}) {
+		if err := h.s.Delete(p + metadataSuffix); err != nil && !errors.Is(err, storage.ErrNotFound) {
+			return err
+		}
+		return nil
+	}
+
+	data, err := json.Marshal(m)
+	if err != nil {
+		return err
+	}
+
+	return h.s.Put(p+metadataSuffix, bytes.NewReader(data))
+}
+
+// writeMetadata sets the headers of the metadata stored for the artifact at
+// p. Without them signed artifacts fail the verification, like a miss.
+func (h *Handler) writeMetadata(w http.ResponseWriter, p string) {
+	var buf bytes.Buffer
+
+	if err := h.s.Get(p+metadataSuffix, &buf); err != nil {
+		if !errors.Is(err, storage.ErrNotFound) {
+			log.Warnf("Failed to retrieve the metadata of %s: %s", p, err)
+		}
+		return
+	}
+
+	var m metadata
+	if err := json.Unmarshal(buf.Bytes(), &m); err != nil {
+		log.Warnf("Invalid metadata of %s: %s", p, err)
+		return
+	}
+
+	if m.Tag != "" {
+		w.Header().Set(tagHeader, m.Tag)
+	}
+	if m.Duration != "" {
+		w.Header().Set(durationHeader, m.Duration)
+	}
+}
+
+// Stats returns the request counters of the remote cache.
+func (h *Handler) Stats() *server.Stats {
+	return &h.stats
+}
@@ -0,0 +1,102 @@
+package turbo
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/franela/goblin"
+	"github.com/yingce/drone-oss-cache/lib/cache/storage/memory"
+	"github.com/yingce/drone-oss-cache/server"
+)
+
+func TestTurbo(t *testing.T) {
+	g := goblin.Goblin(t)
+
+	g.Describe("turbo handler", func() {
+		var h http.Handler
+
+		g.BeforeEach(func() {
+			h = server.BearerAuth([]string{"secret"}, New(memory.New(), &Options{Root: "bucket/turbo"}))
+		})
+
+		g.It("Should store and return an artifact", func() {
+			w := do(h, http.MethodPut, "/v8/artifacts/abc123?teamId=team_1", "artifact")
+			g.Assert(w.Code).Equal(http.StatusOK)
+			g.Assert(strings.TrimSpace(w.Body.String())).Equal(`{"urls":["team_1/abc123"]}`)
+
+			w = do(h, http.MethodHead, "/v8/artifacts/abc123?teamId=team_1", "")
+			g.Assert(w.Code).Equal(http.StatusOK)
+
+			w = do(h, http.MethodGet, "/v8/artifacts/abc123?teamId=team_1", "")
+			g.Assert(w.Code).Equal(http.StatusOK)
+			g.Assert(w.Body.String()).Equal("artifact")
+		})
+
+		g.It("Should return the signature and duration of an artifact", func() {
+			r := httptest.NewRequest(http.MethodPut, "/v8/artifacts/abc123?teamId=team_1", strings.NewReader("artifact"))
+			r.Header.Set("Authorization", "Bearer secret")
+			r.Header.Set("x-artifact-tag", "c2lnbmF0dXJl")
+			r.Header.Set("x-artifact-duration", "1500")
+			w := httptest.NewRecorder()
+			h.ServeHTTP(w, r)
+			g.Assert(w.Code).Equal(http.StatusOK)
+
+			w = do(h, http.MethodGet, "/v8/artifacts/abc123?teamId=team_1", "")
+			g.Assert(w.Body.String()).Equal("artifact")
+			g.Assert(w.Header().Get("x-artifact-tag")).Equal("c2lnbmF0dXJl")
+			g.Assert(w.Header().Get("x-artifact-duration")).Equal("1500")
+
+			// Replaced without a signature
+			do(h, http.MethodPut, "/v8/artifacts/abc123?teamId=team_1", "unsigned")
+
+			w = do(h, http.MethodGet, "/v8/artifacts/abc123?teamId=team_1", "")
+			g.Assert(w.Body.String()).Equal("unsigned")
+			g.Assert(w.Header().Get("x-artifact-tag")).Equal("")
+		})
+
+		g.It("Should scope artifacts by team", func() {
+			do(h, http.MethodPut, "/v8/artifacts/abc123?teamId=team_1", "artifact")
+
+			w := do(h, http.MethodGet, "/v8/artifacts/abc123?slug=other", "")
+			g.Assert(w.Code).Equal(http.StatusNotFound)
+		})
+
+		g.It("Should require a team", func() {
+			w := do(h, http.MethodGet, "/v8/artifacts/abc123", "")
+			g.Assert(w.Code).Equal(http.StatusBadRequest)
+		})
+
+		g.It("Should accept events", func() {
+			w := do(h, http.MethodPost, "/v8/artifacts/events?teamId=team_1", "[]")
+			g.Assert(w.Code).Equal(http.StatusOK)
+		})
+
+		g.It("Should require a bearer token", func() {
+			r := httptest.NewRequest(http.MethodGet, "/v8/artifacts/abc123?teamId=team_1", nil)
+			r.Header.Set("Authorization", "Bearer wrong")
+			w := httptest.NewRecorder()
+			h.ServeHTTP(w, r)
+			g.Assert(w.Code).Equal(http.StatusUnauthorized)
+		})
+
+		g.It("Should require the bearer scheme", func() {
+			for _, header := range []string{"secret", "Basic secret", "bearer secret", ""} {
+				r := httptest.NewRequest(http.MethodGet, "/v8/artifacts/abc123?teamId=team_1", nil)
+				r.Header.Set("Authorization", header)
+				w := httptest.NewRecorder()
+				h.ServeHTTP(w, r)
+				g.Assert(w.Code).Equal(http.StatusUnauthorized)
+			}
+		})
+	})
+}
+
+func do(h http.Handler, method, path, body string) *httptest.ResponseRecorder {
+	r := httptest.NewRequest(method, path, strings.NewReader(body))
+	r.Header.Set("Authorization", "Bearer secret")
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, r)
+	return w
+}
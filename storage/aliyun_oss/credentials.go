@@ -0,0 +1,301 @@
+package aliyun_oss
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aliyun/aliyun-oss-go-sdk/oss"
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	// defaultSTSEndpoint is the public STS endpoint
+	defaultSTSEndpoint = "https://sts.aliyuncs.com"
+
+	// defaultSessionName is the session name of assumed roles
+	defaultSessionName = "drone-cache"
+
+	// expiryWindow refreshes temporary credentials before they expire, so
+	// requests are never signed with expired credentials
+	expiryWindow = 5 * time.Minute
+)
+
+// metadataEndpoint is the ECS instance metadata server.
+var metadataEndpoint = "http://100.100.100.200"
+
+// credentials are the keys requests are signed with. Temporary credentials
+// have a security token and expire.
+type credentials struct {
+	accessKeyID     string
+	accessKeySecret string
+	securityToken   string
+	expiration      time.Time
+}
+
+func (c *credentials) GetAccessKeyID() string {
+	return c.accessKeyID
+}
+
+func (c *credentials) GetAccessKeySecret() string {
+	return c.accessKeySecret
+}
+
+func (c *credentials) GetSecurityToken() string {
+	return c.securityToken
+}
+
+// expired reports whether temporary credentials are about to expire.
+func (c *credentials) expired() bool {
+	return !c.expiration.IsZero() && time.Now().Add(expiryWindow).After(c.expiration)
+}
+
+// refreshing provides the credentials of fetch to the client, fetching them
+// again before they expire.
+type refreshing struct {
+	mu    sync.Mutex
+	name  string
+	fetch func() (*credentials, error)
+	creds *credentials
+}
+
+// newProvider returns the credentials of the options. Without keys it
+// searches the environment, RRSA on ACK clusters and the RAM role of the ECS
+// instance named by ram_role or ALIBABA_CLOUD_ECS_METADATA in that order. Roles are assumed through the transport of the
+// storage.
+func newProvider(opts *Options, tr http.RoundTripper) (*refreshing, error) {
+	if len(opts.Key) != 0 && len(opts.Secret) != 0 {
+		return newRefreshing("static", staticCredentials(opts.Key, opts.Secret, opts.Token))
+	}
+
+	id, secret := os.Getenv("ALIBABA_CLOUD_ACCESS_KEY_ID"), os.Getenv("ALIBABA_CLOUD_ACCESS_KEY_SECRET")
+	if len(id) != 0 && len(secret) != 0 {
+		return newRefreshing("environment", staticCredentials(id, secret, os.Getenv("ALIBABA_CLOUD_SECURITY_TOKEN")))
+	}
+
+	oidc := &oidcRole{
+		endpoint:    opts.STSEndpoint,
+		roleARN:     firstOf(opts.OIDCRoleARN, os.Getenv("ALIBABA_CLOUD_ROLE_ARN")),
+		providerARN: firstOf(opts.OIDCProviderARN, os.Getenv("ALIBABA_CLOUD_OIDC_PROVIDER_ARN")),
+		tokenFile:   firstOf(opts.OIDCTokenFile, os.Getenv("ALIBABA_CLOUD_OIDC_TOKEN_FILE")),
+		sessionName: firstOf(opts.SessionName, os.Getenv("ALIBABA_CLOUD_ROLE_SESSION_NAME"), defaultSessionName),
+		client:      &http.Client{Transport: tr, Timeout: 30 * time.Second},
+	}
+
+	if len(oidc.roleARN) != 0 && len(oidc.providerARN) != 0 && len(oidc.tokenFile) != 0 {
+		if len(oidc.endpoint) == 0 {
+			oidc.endpoint = defaultSTSEndpoint
+		}
+
+		return newRefreshing("oidc", oidc.fetch)
+	}
+
+	// Probing the metadata server outside of ECS would stall until it times
+	// out, so the role is only read when one is named
+	name := firstOf(opts.RAMRole, os.Getenv("ALIBABA_CLOUD_ECS_METADATA"))
+
+	if len(name) == 0 {
+		// Keep the empty keys of public buckets
+		log.Warnf("No OSS credentials found, using empty keys")
+
+		return newRefreshing("static", staticCredentials(opts.Key, opts.Secret, opts.Token))
+	}
+
+	ram := &ramRole{
+		name:   name,
+		client: &http.Client{Timeout: 5 * time.Second},
+	}
+
+	return newRefreshing("ram role", ram.fetch)
+}
+
+// newRefreshing fetches the credentials once to fail early.
+func newRefreshing(name string, fetch func() (*credentials, error)) (*refreshing, error) {
+	creds, err := fetch()
+	if err != nil {
+		return nil, err
+	}
+
+	log.Debugf("Using %s credentials", name)
+
+	return &refreshing{
+		name:  name,
+		fetch: fetch,
+		creds: creds,
+	}, nil
+}
+
+// GetCredentials is called by the client before signing every request.
+func (r *refreshing) GetCredentials() oss.Credentials {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.creds.expired() {
+		creds, err := r.fetch()
+
+		if err != nil {
+			// The old credentials may still be valid for a few minutes
+			log.Warnf("Failed to refresh %s credentials: %s", r.name, err)
+		} else {
+			log.Debugf("Refreshed %s credentials until %s", r.name, creds.expiration)
+			r.creds = creds
+		}
+	}
+
+	return r.creds
+}
+
+func staticCredentials(id, secret, token string) func() (*credentials, error) {
+	return func() (*credentials, error) {
+		return &credentials{
+			accessKeyID:     id,
+			accessKeySecret: secret,
+			securityToken:   token,
+		}, nil
+	}
+}
+
+// temporaryCredentials is the JSON of credentials returned by STS and the
+// instance metadata.
+type temporaryCredentials struct {
+	AccessKeyID     string `json:"AccessKeyId"`
+	AccessKeySecret string
+	SecurityToken   string
+	Expiration      time.Time
+}
+
+func (t temporaryCredentials) credentials() (*credentials, error) {
+	if len(t.AccessKeyID) == 0 || len(t.AccessKeySecret) == 0 {
+		return nil, errors.New("No credentials returned")
+	}
+
+	return &credentials{
+		accessKeyID:     t.AccessKeyID,
+		accessKeySecret: t.AccessKeySecret,
+		securityToken:   t.SecurityToken,
+		expiration:      t.Expiration,
+	}, nil
+}
+
+// oidcRole exchanges the OIDC token of a service account for the credentials
+// of a role, RRSA on ACK clusters.
+type oidcRole struct {
+	endpoint    string
+	roleARN     string
+	providerARN string
+	tokenFile   string
+	sessionName string
+	client      *http.Client
+}
+
+func (o *oidcRole) fetch() (*credentials, error) {
+	// The token is rotated on disk, read it on every refresh
+	token, err := ioutil.ReadFile(o.tokenFile)
+	if err != nil {
+		return nil, err
+	}
+
+	query := url.Values{
+		"Action":    {"AssumeRoleWithOIDC"},
+		"Format":    {"JSON"},
+		"Version":   {"2015-04-01"},
+		"Timestamp": {time.Now().UTC().Format("2006-01-02T15:04:05Z")},
+	}
+
+	form := url.Values{
+		"RoleArn":         {o.roleARN},
+		"OIDCProviderArn": {o.providerARN},
+		"OIDCToken":       {strings.TrimSpace(string(token))},
+		"RoleSessionName": {o.sessionName},
+	}
+
+	resp, err := o.client.Post(o.endpoint+"/?"+query.Encode(), "application/x-www-form-urlencoded", strings.NewReader(form.Encode()))
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if err := checkResponse(resp); err != nil {
+		return nil, fmt.Errorf("AssumeRoleWithOIDC failed: %s", err)
+	}
+
+	var r struct {
+		Credentials temporaryCredentials
+	}
+
+	if err := json.NewDecoder(resp.Body).Decode(&r); err != nil {
+		return nil, err
+	}
+
+	return r.Credentials.credentials()
+}
+
+// ramRole reads the credentials of the RAM role attached to the ECS instance.
+type ramRole struct {
+	name   string
+	client *http.Client
+}
+
+func (r *ramRole) fetch() (*credentials, error) {
+	body, err := r.get(metadataEndpoint + "/latest/meta-data/ram/security-credentials/" + r.name)
+	if err != nil {
+		return nil, err
+	}
+
+	var creds struct {
+		temporaryCredentials
+		Code string
+	}
+
+	if err := json.Unmarshal([]byte(body), &creds); err != nil {
+		return nil, err
+	}
+
+	if creds.Code != "Success" {
+		return nil, fmt.Errorf("Failed to get the credentials of ram role %s: %s", r.name, creds.Code)
+	}
+
+	return creds.credentials()
+}
+
+func (r *ramRole) get(u string) (string, error) {
+	resp, err := r.client.Get(u)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+
+	if err := checkResponse(resp); err != nil {
+		return "", err
+	}
+
+	body, err := ioutil.ReadAll(resp.Body)
+	return string(body), err
+}
+
+func checkResponse(resp *http.Response) error {
+	if resp.StatusCode == http.StatusOK {
+		return nil
+	}
+
+	body, _ := ioutil.ReadAll(io.LimitReader(resp.Body, 1024))
+	return fmt.Errorf("%s %s", resp.Status, strings.TrimSpace(string(body)))
+}
+
+func firstOf(values ...string) string {
+	for _, v := range values {
+		if len(v) != 0 {
+			return v
+		}
+	}
+
+	return ""
+}
@@ -0,0 +1,139 @@
+package aliyun_oss
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/franela/goblin"
+)
+
+func TestCredentials(t *testing.T) {
+	g := goblin.Goblin(t)
+
+	g.Describe("oss credentials", func() {
+		var server *httptest.Server
+		var requests []*http.Request
+		var expiration time.Duration
+
+		g.BeforeEach(func() {
+			requests = nil
+			expiration = time.Hour
+
+			server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				r.ParseForm()
+				requests = append(requests, r)
+
+				creds := fmt.Sprintf(`{"AccessKeyId":"STS.%d","AccessKeySecret":"secret","SecurityToken":"token","Expiration":"%s"}`,
+					len(requests), time.Now().Add(expiration).UTC().Format(time.RFC3339))
+
+				switch r.URL.Path {
+				case "/latest/meta-data/ram/security-credentials/cache-role":
+					fmt.Fprintf(w, `{"Code":"Success",%s`, creds[1:])
+				case "/":
+					fmt.Fprintf(w, `{"RequestId":"1","Credentials":%s}`, creds)
+				default:
+					http.NotFound(w, r)
+				}
+			}))
+
+			metadataEndpoint = server.URL
+		})
+
+		g.AfterEach(func() {
+			server.Close()
+		})
+
+		g.It("Should use static keys with a security token", func() {
+			p, err := newProvider(&Options{Key: "key", Secret: "secret", Token: "token"}, http.DefaultTransport)
+			g.Assert(err == nil).IsTrue("failed to create provider")
+
+			creds := p.GetCredentials()
+			g.Assert(creds.GetAccessKeyID()).Equal("key")
+			g.Assert(creds.GetSecurityToken()).Equal("token")
+			g.Assert(len(requests)).Equal(0)
+		})
+
+		g.It("Should read the ram role named in the environment", func() {
+			os.Setenv("ALIBABA_CLOUD_ECS_METADATA", "cache-role")
+			defer os.Unsetenv("ALIBABA_CLOUD_ECS_METADATA")
+
+			p, err := newProvider(&Options{}, http.DefaultTransport)
+			g.Assert(err == nil).IsTrue("failed to create provider")
+
+			creds := p.GetCredentials()
+			g.Assert(creds.GetAccessKeyID()).Equal("STS.1")
+			g.Assert(creds.GetSecurityToken()).Equal("token")
+
+			// Not expiring yet
+			p.GetCredentials()
+			g.Assert(len(requests)).Equal(1)
+		})
+
+		g.It("Should use empty keys without probing the instance metadata", func() {
+			p, err := newProvider(&Options{}, http.DefaultTransport)
+			g.Assert(err == nil).IsTrue("failed to create provider")
+
+			creds := p.GetCredentials()
+			g.Assert(creds.GetAccessKeyID()).Equal("")
+			g.Assert(len(requests)).Equal(0)
+		})
+
+		g.It("Should fail when the named ram role has no credentials", func() {
+			_, err := newProvider(&Options{RAMRole: "other-role"}, http.DefaultTransport)
+			g.Assert(err != nil).IsTrue("expected an error")
+		})
+
+		g.It("Should refresh credentials before they expire", func() {
+			expiration = time.Minute
+
+			p, err := newProvider(&Options{RAMRole: "cache-role"}, http.DefaultTransport)
+			g.Assert(err == nil).IsTrue("failed to create provider")
+			g.Assert(len(requests)).Equal(1)
+
+			creds := p.GetCredentials()
+			g.Assert(creds.GetAccessKeyID()).Equal("STS.2")
+		})
+
+		g.It("Should keep the credentials when the refresh fails", func() {
+			expiration = time.Minute
+
+			p, err := newProvider(&Options{RAMRole: "cache-role"}, http.DefaultTransport)
+			g.Assert(err == nil).IsTrue("failed to create provider")
+
+			server.Close()
+
+			creds := p.GetCredentials()
+			g.Assert(creds.GetAccessKeyID()).Equal("STS.1")
+		})
+
+		g.It("Should assume a role with an oidc token", func() {
+			dir, _ := ioutil.TempDir("", "oss")
+			defer os.RemoveAll(dir)
+
+			tokenFile := filepath.Join(dir, "token")
+			ioutil.WriteFile(tokenFile, []byte("jwt\n"), 0600)
+
+			p, err := newProvider(&Options{
+				OIDCRoleARN:     "acs:ram::123:role/cache",
+				OIDCProviderARN: "acs:ram::123:oidc-provider/ack",
+				OIDCTokenFile:   tokenFile,
+				STSEndpoint:     server.URL,
+			}, http.DefaultTransport)
+			g.Assert(err == nil).IsTrue("failed to create provider")
+
+			creds := p.GetCredentials()
+			g.Assert(creds.GetAccessKeyID()).Equal("STS.1")
+
+			r := requests[0]
+			g.Assert(r.URL.Query().Get("Action")).Equal("AssumeRoleWithOIDC")
+			g.Assert(r.PostForm.Get("OIDCToken")).Equal("jwt")
+			g.Assert(r.PostForm.Get("RoleSessionName")).Equal(defaultSessionName)
+		})
+	})
+}
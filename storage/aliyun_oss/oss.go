@@ -1,15 +1,20 @@
 package aliyun_oss
 
 import (
+	"errors"
 	"fmt"
 	"io"
+	"net/http"
+	"sort"
 	"strconv"
 	"strings"
+	"time"
 
 	"github.com/aliyun/aliyun-oss-go-sdk/oss"
 	"github.com/dustin/go-humanize"
 	log "github.com/sirupsen/logrus"
 	"github.com/yingce/drone-oss-cache/lib/cache/storage"
+	"github.com/yingce/drone-oss-cache/lib/cache/storage/transport"
 )
 
 // Options contains configuration for the S3 connection.
@@ -17,25 +22,185 @@ type Options struct {
 	Endpoint string
 	Key      string
 	Secret   string
+
+	// Token is the security token of STS temporary keys
+	Token string
+
+	// RAMRole is the RAM role of the ECS instance, found in the instance
+	// metadata when empty
+	RAMRole string
+
+	// OIDCRoleARN, OIDCProviderARN and OIDCTokenFile assume a role with the
+	// OIDC token of a service account (RRSA), they default to the
+	// ALIBABA_CLOUD_* variables set on ACK clusters
+	OIDCRoleARN     string
+	OIDCProviderARN string
+	OIDCTokenFile   string
+	SessionName     string
+
+	// STSEndpoint is the STS server roles are assumed with
+	STSEndpoint string
+
+	// SSE is the server side encryption of uploads, AES256 or KMS with
+	// SSEKMSKeyID, the OSS managed key by default
+	SSE         string
+	SSEKMSKeyID string
+
+	// StorageClass of uploads, Standard, IA or Archive
+	StorageClass string
+
+	// ObjectACL of uploads, defaults to private
+	ObjectACL string
+
+	// Tags are set on uploads
+	Tags map[string]string
+
+	// BucketLookup is the addressing of buckets: dns (virtual-hosted) or
+	// cname for a custom domain of the bucket, auto is dns. Path-style is
+	// only used for IP endpoints, path is ignored since bucket_lookup is
+	// shared with S3
+	BucketLookup string
+
+	// CACert and CACertPath are PEM encoded CAs trusted in addition to the
+	// system CAs, InsecureSkipVerify accepts any server certificate
+	CACert             string
+	CACertPath         string
+	InsecureSkipVerify bool
+
+	// CreateBucket creates a missing bucket on Put instead of failing
+	CreateBucket bool
+	// BucketACL is the ACL of created buckets: private, public-read or
+	// public-read-write, defaults to private
+	BucketACL string
 }
 
 type ossStorage struct {
-	client *oss.Client
-	opts   *Options
+	client  *oss.Client
+	opts    *Options
+	options []oss.Option
 }
 
 // New method creates an implementation of Storage with S3 as the backend.
 func New(opts *Options) (storage.Storage, error) {
-	client, err := oss.New(opts.Endpoint, opts.Key, opts.Secret)
+	switch oss.ACLType(opts.BucketACL) {
+	case "", oss.ACLPrivate, oss.ACLPublicRead, oss.ACLPublicReadWrite:
+	default:
+		return nil, fmt.Errorf("Invalid bucket ACL %s", opts.BucketACL)
+	}
+
+	options, err := objectOptions(opts)
+	if err != nil {
+		return nil, err
+	}
+
+	var cname bool
+
+	switch opts.BucketLookup {
+	case "", "auto", "dns":
+	case "cname":
+		cname = true
+	case "path":
+		log.Warnf("Ignoring bucket lookup path, OSS only uses path-style for IP endpoints")
+	default:
+		return nil, fmt.Errorf("Invalid bucket lookup %s. Needs to be auto, dns or cname", opts.BucketLookup)
+	}
+
+	tr, err := transport.New(&transport.Options{
+		CACert:             opts.CACert,
+		CACertPath:         opts.CACertPath,
+		InsecureSkipVerify: opts.InsecureSkipVerify,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	// The header timeout of the default oss transport
+	tr.ResponseHeaderTimeout = 60 * time.Second
+
+	provider, err := newProvider(opts, tr)
+	if err != nil {
+		return nil, err
+	}
+
+	client, err := oss.New(opts.Endpoint, opts.Key, opts.Secret,
+		oss.SetCredentialsProvider(provider),
+		oss.HTTPClient(&http.Client{Transport: tr}),
+		oss.UseCname(cname),
+	)
 	if err != nil {
 		return nil, err
 	}
 	return &ossStorage{
-		client: client,
-		opts:   opts,
+		client:  client,
+		opts:    opts,
+		options: options,
 	}, nil
 }
 
+// objectOptions returns the options uploads and copies are written with. The
+// object ACL is set explicitly, so objects never inherit a public bucket ACL.
+func objectOptions(opts *Options) ([]oss.Option, error) {
+	acl := oss.ACLPrivate
+	if opts.ObjectACL != "" {
+		acl = oss.ACLType(opts.ObjectACL)
+	}
+
+	switch acl {
+	case oss.ACLPrivate, oss.ACLPublicRead, oss.ACLPublicReadWrite, oss.ACLDefault:
+	default:
+		return nil, fmt.Errorf("Invalid object ACL %s", opts.ObjectACL)
+	}
+
+	options := []oss.Option{oss.ObjectACL(acl)}
+
+	sse := opts.SSE
+	if sse == "" && opts.SSEKMSKeyID != "" {
+		sse = "KMS"
+	}
+
+	switch sse {
+	case "":
+	case "AES256":
+		options = append(options, oss.ServerSideEncryption(sse))
+	case "KMS":
+		options = append(options, oss.ServerSideEncryption(sse))
+
+		if opts.SSEKMSKeyID != "" {
+			options = append(options, oss.ServerSideEncryptionKeyID(opts.SSEKMSKeyID))
+		}
+	default:
+		return nil, fmt.Errorf("Invalid sse %s. Needs to be AES256 or KMS", opts.SSE)
+	}
+
+	switch class := oss.StorageClassType(opts.StorageClass); class {
+	case "":
+	case oss.StorageStandard, oss.StorageIA, oss.StorageArchive:
+		options = append(options, oss.ObjectStorageClass(class))
+	default:
+		return nil, fmt.Errorf("Invalid storage class %s. Needs to be Standard, IA or Archive", opts.StorageClass)
+	}
+
+	if len(opts.Tags) > 10 {
+		return nil, errors.New("Invalid tags. OSS objects have at most 10 tags")
+	}
+
+	if len(opts.Tags) > 0 {
+		var tagging oss.Tagging
+
+		for k, v := range opts.Tags {
+			tagging.Tags = append(tagging.Tags, oss.Tag{Key: k, Value: v})
+		}
+
+		sort.Slice(tagging.Tags, func(i, j int) bool {
+			return tagging.Tags[i].Key < tagging.Tags[j].Key
+		})
+
+		options = append(options, oss.SetTagging(tagging))
+	}
+
+	return options, nil
+}
+
 func (s *ossStorage) Get(p string, dst io.Writer) error {
 	bucket, key := splitBucket(p)
 
@@ -47,10 +212,12 @@ func (s *ossStorage) Get(p string, dst io.Writer) error {
 
 	exists, err := s.client.IsBucketExist(bucket)
 
-	if !exists {
-		log.Infof("Bucket %s not exists", bucket)
+	if err != nil {
 		return err
 	}
+	if !exists {
+		return fmt.Errorf("%w: bucket %s", storage.ErrNotFound, bucket)
+	}
 
 	bkt, err := s.client.Bucket(bucket)
 
@@ -61,8 +228,9 @@ func (s *ossStorage) Get(p string, dst io.Writer) error {
 	object, err := bkt.GetObject(key)
 
 	if err != nil {
-		return err
+		return notFound(err)
 	}
+	defer object.Close()
 
 	log.Infof("Copying object from the server")
 
@@ -84,10 +252,20 @@ func (s *ossStorage) findOrCreateBucket(bucketName string) (bucket *oss.Bucket,
 	}
 
 	if !exists {
-		if err = s.client.CreateBucket(bucketName); err != nil {
+		if !s.opts.CreateBucket {
+			err = fmt.Errorf("%w: bucket %s, enable create_bucket to create it", storage.ErrNotFound, bucketName)
+			return
+		}
+
+		acl := oss.ACLPrivate
+		if s.opts.BucketACL != "" {
+			acl = oss.ACLType(s.opts.BucketACL)
+		}
+
+		if err = s.client.CreateBucket(bucketName, oss.ACL(acl)); err != nil {
 			return
 		}
-		log.Infof("Bucket %s created", bucketName)
+		log.Infof("Bucket %s created with %s ACL", bucketName, acl)
 	} else {
 		log.Infof("Bucket %s already exists", bucketName)
 	}
@@ -115,7 +293,7 @@ func (s *ossStorage) Put(p string, src io.Reader) error {
 
 	log.Infof("Putting file in %s at %s", bucket, key)
 
-	options := []oss.Option{oss.ContentType("application/tar")}
+	options := append([]oss.Option{oss.ContentType("application/tar")}, s.options...)
 
 	err = bkt.PutObject(key, src, options...)
 
@@ -149,10 +327,10 @@ func (s *ossStorage) List(p string) ([]storage.FileEntry, error) {
 	exists, err := s.client.IsBucketExist(bucket)
 
 	if err != nil {
-		return nil, fmt.Errorf("%s does not exist: %s", p, err)
+		return nil, err
 	}
 	if !exists {
-		return nil, fmt.Errorf("%s does not exist", p)
+		return nil, fmt.Errorf("%w: %s", storage.ErrNotFound, p)
 	}
 
 	bkt, err := s.client.Bucket(bucket)
@@ -186,10 +364,25 @@ func (s *ossStorage) List(p string) ([]storage.FileEntry, error) {
 
 func (s *ossStorage) Exists(p string) (bool, error) {
 	bucket, key := splitBucket(p)
-	bkt, err := s.findOrCreateBucket(bucket)
+
+	if len(bucket) == 0 || len(key) == 0 {
+		return false, fmt.Errorf("Invalid path %s", p)
+	}
+
+	exists, err := s.client.IsBucketExist(bucket)
+
 	if err != nil {
+		return false, err
+	}
+	if !exists {
 		return false, nil
 	}
+
+	bkt, err := s.client.Bucket(bucket)
+	if err != nil {
+		return false, err
+	}
+
 	return bkt.IsObjectExist(key)
 }
 
@@ -205,10 +398,10 @@ func (s *ossStorage) Delete(p string) error {
 	exists, err := s.client.IsBucketExist(bucket)
 
 	if err != nil {
-		return fmt.Errorf("%s does not exist: %s", p, err)
+		return err
 	}
 	if !exists {
-		return fmt.Errorf("%s does not exist", p)
+		return fmt.Errorf("%w: %s", storage.ErrNotFound, p)
 	}
 
 	bkt, err := s.client.Bucket(bucket)
@@ -217,6 +410,69 @@ func (s *ossStorage) Delete(p string) error {
 	}
 
 	err = bkt.DeleteObject(key)
+	return notFound(err)
+}
+
+const (
+	// maxCopySize is the largest object a single CopyObject request can
+	// copy, larger objects are copied in parts.
+	maxCopySize = 1024 * 1024 * 1024
+	// copyPartSize is the size of each part of a multipart copy.
+	copyPartSize = 100 * 1024 * 1024
+)
+
+func (s *ossStorage) Copy(src, dst string) error {
+	srcBucket, srcKey := splitBucket(src)
+	dstBucket, dstKey := splitBucket(dst)
+
+	if len(srcBucket) == 0 || len(srcKey) == 0 {
+		return fmt.Errorf("Invalid path %s", src)
+	}
+	if len(dstBucket) == 0 || len(dstKey) == 0 {
+		return fmt.Errorf("Invalid path %s", dst)
+	}
+
+	log.Infof("Copying object in bucket %s at %s to bucket %s at %s", srcBucket, srcKey, dstBucket, dstKey)
+
+	sbkt, err := s.client.Bucket(srcBucket)
+	if err != nil {
+		return err
+	}
+
+	h, err := sbkt.GetObjectMeta(srcKey)
+	if err != nil {
+		return notFound(err)
+	}
+
+	size, _ := strconv.ParseInt(h.Get("Content-Length"), 10, 64)
+
+	dbkt, err := s.client.Bucket(dstBucket)
+	if err != nil {
+		return err
+	}
+
+	if size > maxCopySize {
+		log.Infof("Copying %s in parts", humanize.Bytes(uint64(size)))
+		err = dbkt.CopyFile(srcBucket, srcKey, dstKey, copyPartSize, s.options...)
+	} else {
+		_, err = dbkt.CopyObjectFrom(srcBucket, srcKey, dstKey, s.options...)
+	}
+
+	if err != nil {
+		return notFound(err)
+	}
+
+	log.Infof("Copied %s on server", humanize.Bytes(uint64(size)))
+
+	return nil
+}
+
+// notFound maps the not found responses of OSS to storage.ErrNotFound.
+func notFound(err error) error {
+	if serr, ok := err.(oss.ServiceError); ok && serr.StatusCode == http.StatusNotFound {
+		return fmt.Errorf("%w: %s", storage.ErrNotFound, err)
+	}
+
 	return err
 }
 
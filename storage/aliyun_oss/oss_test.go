@@ -0,0 +1,191 @@
+package aliyun_oss
+
+import (
+	"encoding/pem"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/aliyun/aliyun-oss-go-sdk/oss"
+	"github.com/franela/goblin"
+	"github.com/yingce/drone-oss-cache/lib/cache/storage"
+)
+
+func TestOSS(t *testing.T) {
+	g := goblin.Goblin(t)
+
+	g.Describe("oss uploads", func() {
+		var server *httptest.Server
+		var put *http.Request
+
+		g.BeforeEach(func() {
+			put = nil
+
+			server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				switch {
+				case r.Method == http.MethodGet && r.URL.Path == "/":
+					w.Write([]byte(`<ListAllMyBucketsResult><Buckets><Bucket><Name>bucket</Name></Bucket></Buckets></ListAllMyBucketsResult>`))
+				case r.Method == http.MethodPut && r.Header.Get("X-Oss-Copy-Source") != "":
+					put = r
+					w.Write([]byte(`<CopyObjectResult><LastModified>2006-01-02T15:04:05.000Z</LastModified><ETag>"etag"</ETag></CopyObjectResult>`))
+				case r.Method == http.MethodPut:
+					body, _ := ioutil.ReadAll(r.Body)
+					r.Header.Set("X-Test-Length", strconv.Itoa(len(body)))
+					put = r
+				case r.Method == http.MethodHead:
+					w.Header().Set("Content-Length", put.Header.Get("X-Test-Length"))
+				default:
+					http.NotFound(w, r)
+				}
+			}))
+		})
+
+		g.AfterEach(func() {
+			server.Close()
+		})
+
+		g.It("Should write private objects by default", func() {
+			s, err := New(&Options{Endpoint: server.URL, Key: "key", Secret: "secret"})
+			g.Assert(err == nil).IsTrue("failed to create storage")
+
+			err = s.Put("bucket/owner/repo/archive.tar", strings.NewReader("archive"))
+			g.Assert(err == nil).IsTrue("failed to put")
+
+			g.Assert(put.URL.Path).Equal("/bucket/owner/repo/archive.tar")
+			g.Assert(put.Header.Get("X-Oss-Object-Acl")).Equal("private")
+			g.Assert(put.Header.Get("X-Oss-Server-Side-Encryption")).Equal("")
+		})
+
+		g.It("Should write encrypted and tagged objects", func() {
+			s, err := New(&Options{
+				Endpoint:     server.URL,
+				Key:          "key",
+				Secret:       "secret",
+				SSEKMSKeyID:  "cache-key",
+				StorageClass: "IA",
+				Tags:         map[string]string{"team": "ci", "cost": "cache"},
+			})
+			g.Assert(err == nil).IsTrue("failed to create storage")
+
+			err = s.Put("bucket/owner/repo/archive.tar", strings.NewReader("archive"))
+			g.Assert(err == nil).IsTrue("failed to put")
+
+			g.Assert(put.Header.Get("X-Oss-Server-Side-Encryption")).Equal("KMS")
+			g.Assert(put.Header.Get("X-Oss-Server-Side-Encryption-Key-Id")).Equal("cache-key")
+			g.Assert(put.Header.Get("X-Oss-Storage-Class")).Equal("IA")
+			g.Assert(put.Header.Get("X-Oss-Tagging")).Equal("cost=cache&team=ci")
+		})
+
+		g.It("Should copy with the storage class of uploads", func() {
+			s, err := New(&Options{Endpoint: server.URL, Key: "key", Secret: "secret", StorageClass: "IA"})
+			g.Assert(err == nil).IsTrue("failed to create storage")
+
+			s.Put("bucket/feature/archive.tar", strings.NewReader("archive"))
+
+			err = s.Copy("bucket/feature/archive.tar", "bucket/master/archive.tar")
+			g.Assert(err == nil).IsTrue("failed to copy")
+
+			g.Assert(put.URL.Path).Equal("/bucket/master/archive.tar")
+			g.Assert(put.Header.Get("X-Oss-Storage-Class")).Equal("IA")
+		})
+
+		g.It("Should reject invalid options", func() {
+			_, err := New(&Options{Endpoint: server.URL, Key: "key", Secret: "secret", SSE: "DES"})
+			g.Assert(err != nil).IsTrue("expected an invalid sse error")
+
+			_, err = New(&Options{Endpoint: server.URL, Key: "key", Secret: "secret", StorageClass: "Glacier"})
+			g.Assert(err != nil).IsTrue("expected an invalid storage class error")
+
+			_, err = New(&Options{Endpoint: server.URL, Key: "key", Secret: "secret", ObjectACL: "public"})
+			g.Assert(err != nil).IsTrue("expected an invalid acl error")
+
+			_, err = New(&Options{Endpoint: server.URL, Key: "key", Secret: "secret", BucketLookup: "virtual"})
+			g.Assert(err != nil).IsTrue("expected an invalid bucket lookup error")
+		})
+
+		g.It("Should ignore the path bucket lookup of S3", func() {
+			_, err := New(&Options{Endpoint: server.URL, Key: "key", Secret: "secret", BucketLookup: "path"})
+			g.Assert(err == nil).IsTrue("failed with path lookup")
+		})
+
+		g.It("Should trust the ca cert", func() {
+			tlsServer := httptest.NewTLSServer(server.Config.Handler)
+			defer tlsServer.Close()
+
+			cert := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: tlsServer.Certificate().Raw})
+
+			s, err := New(&Options{Endpoint: tlsServer.URL, Key: "key", Secret: "secret", CACert: string(cert)})
+			g.Assert(err == nil).IsTrue("failed to create storage")
+
+			err = s.Put("bucket/owner/repo/archive.tar", strings.NewReader("archive"))
+			g.Assert(err == nil).IsTrue("failed to put")
+			g.Assert(put.TLS != nil).IsTrue("expected a tls request")
+		})
+	})
+
+	g.Describe("oss errors", func() {
+		g.It("Should map not found responses", func() {
+			for _, tt := range []struct {
+				err      error
+				notFound bool
+			}{
+				{oss.ServiceError{Code: "NoSuchKey", StatusCode: http.StatusNotFound}, true},
+				{oss.ServiceError{Code: "NoSuchBucket", StatusCode: http.StatusNotFound}, true},
+				{oss.ServiceError{Code: "AccessDenied", StatusCode: http.StatusForbidden}, false},
+				{oss.ServiceError{Code: "InternalError", StatusCode: http.StatusInternalServerError}, false},
+				{errors.New("connection refused"), false},
+			} {
+				err := notFound(tt.err)
+				g.Assert(errors.Is(err, storage.ErrNotFound)).Equal(tt.notFound)
+			}
+		})
+	})
+	g.Describe("oss copies", func() {
+		var server *httptest.Server
+		var copied *http.Request
+
+		g.BeforeEach(func() {
+			copied = nil
+
+			server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				switch {
+				case r.Method == http.MethodHead && r.URL.Path == "/bucket/feature/archive.tar":
+					w.Header().Set("Content-Length", "7")
+				case r.Method == http.MethodPut && r.Header.Get("X-Oss-Copy-Source") != "":
+					copied = r
+					w.Write([]byte(`<CopyObjectResult><LastModified>2006-01-02T15:04:05.000Z</LastModified><ETag>"etag"</ETag></CopyObjectResult>`))
+				default:
+					http.NotFound(w, r)
+				}
+			}))
+		})
+
+		g.AfterEach(func() {
+			server.Close()
+		})
+
+		g.It("Should copy on the server", func() {
+			s, err := New(&Options{Endpoint: server.URL, Key: "key", Secret: "secret"})
+			g.Assert(err == nil).IsTrue("failed to create storage")
+
+			err = s.Copy("bucket/feature/archive.tar", "bucket/master/archive.tar")
+			g.Assert(err == nil).IsTrue("failed to copy")
+
+			g.Assert(copied.URL.Path).Equal("/bucket/master/archive.tar")
+			g.Assert(copied.Header.Get("X-Oss-Copy-Source")).Equal("/bucket/feature%2Farchive.tar")
+		})
+
+		g.It("Should report a missing source", func() {
+			s, err := New(&Options{Endpoint: server.URL, Key: "key", Secret: "secret"})
+			g.Assert(err == nil).IsTrue("failed to create storage")
+
+			err = s.Copy("bucket/missing/archive.tar", "bucket/master/archive.tar")
+			g.Assert(errors.Is(err, storage.ErrNotFound)).IsTrue("expected a not found error")
+			g.Assert(copied == nil).IsTrue("expected no copy")
+		})
+	})
+}
@@ -0,0 +1,95 @@
+package aliyun_oss
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/yingce/drone-oss-cache/lib/cache/storage"
+)
+
+// defaultEndpoint is used without endpoint and region.
+const defaultEndpoint = "https://oss-cn-beijing.aliyuncs.com"
+
+func init() {
+	storage.Register(storage.Provider{
+		Name:  "oss",
+		Usage: "Alibaba Cloud OSS, e.g. oss://bucket/prefix",
+		Options: []storage.Option{
+			{Name: "endpoint", Usage: "oss server, defaults to the endpoint of the region", EnvVars: []string{"PLUGIN_OSS_SERVER"}, Shared: "server"},
+			{Name: "region", Usage: "oss region, e.g. cn-hangzhou", Shared: "region"},
+			{Name: "access_key", Usage: "oss access key", Shared: "access-key"},
+			{Name: "secret_key", Usage: "oss secret key", Shared: "secret-key"},
+			{Name: "token", Usage: "oss security token of sts temporary keys", Shared: "session-token"},
+			{Name: "ram_role", Usage: "ram role of the ecs instance to read from the instance metadata"},
+			{Name: "oidc_role_arn", Usage: "role assumed with the oidc token (rrsa), defaults to ALIBABA_CLOUD_ROLE_ARN"},
+			{Name: "oidc_provider_arn", Usage: "oidc provider of the role, defaults to ALIBABA_CLOUD_OIDC_PROVIDER_ARN"},
+			{Name: "oidc_token_file", Usage: "oidc token of the service account, defaults to ALIBABA_CLOUD_OIDC_TOKEN_FILE"},
+			{Name: "session_name", Usage: "session name of the assumed role, defaults to drone-cache"},
+			{Name: "sts_endpoint", Usage: "sts server roles are assumed with, defaults to sts.aliyuncs.com"},
+			{Name: "sse", Usage: "server side encryption of uploads: AES256 or KMS"},
+			{Name: "sse_kms_key_id", Usage: "kms key of KMS encryption, defaults to the oss managed key"},
+			{Name: "storage_class", Usage: "storage class of uploads: Standard, IA or Archive"},
+			{Name: "object_acl", Usage: "acl of uploads: private, public-read, public-read-write or default, defaults to private"},
+			{Name: "tags", Usage: "tags of uploads, e.g. team=ci", Type: storage.Strings},
+			{Name: "bucket_lookup", Usage: "addressing of buckets: dns (virtual-hosted), cname or auto, path is ignored", Shared: "bucket_lookup"},
+			{Name: "ca_cert", Usage: "pem encoded ca trusted in addition to the system cas", Shared: "ca_cert"},
+			{Name: "ca_cert_path", Usage: "file of a pem encoded ca trusted in addition to the system cas", Shared: "ca_cert_path"},
+			{Name: "insecure_skip_verify", Usage: "accept any server certificate, only meant for labs", Type: storage.Bool, Shared: "insecure_skip_verify"},
+			{Name: "create_bucket", Usage: "create the bucket if it does not exist", Type: storage.Bool, Shared: "create_bucket"},
+			{Name: "bucket_acl", Usage: "acl of created buckets, defaults to private", Shared: "bucket_acl"},
+		},
+		New: FromValues,
+	})
+}
+
+// FromValues creates the storage from the options of the provider. The
+// region picks the public endpoint of the region when no endpoint is given.
+func FromValues(opts storage.Values) (storage.Storage, error) {
+	endpoint := opts.String("endpoint")
+	if len(endpoint) == 0 {
+		endpoint = defaultEndpoint
+
+		if region := opts.String("region"); len(region) != 0 {
+			endpoint = "https://oss-" + region + ".aliyuncs.com"
+		}
+	}
+
+	tags := make(map[string]string)
+
+	for _, t := range opts.Strings("tags") {
+		i := strings.Index(t, "=")
+		if i <= 0 {
+			return nil, fmt.Errorf("Invalid tag %s. Needs to be key=value", t)
+		}
+
+		tags[strings.TrimSpace(t[:i])] = strings.TrimSpace(t[i+1:])
+	}
+
+	return New(&Options{
+		Endpoint: endpoint,
+		Key:      opts.String("access_key"),
+		Secret:   opts.String("secret_key"),
+		Token:    opts.String("token"),
+		RAMRole:  opts.String("ram_role"),
+
+		OIDCRoleARN:     opts.String("oidc_role_arn"),
+		OIDCProviderARN: opts.String("oidc_provider_arn"),
+		OIDCTokenFile:   opts.String("oidc_token_file"),
+		SessionName:     opts.String("session_name"),
+		STSEndpoint:     opts.String("sts_endpoint"),
+
+		SSE:          opts.String("sse"),
+		SSEKMSKeyID:  opts.String("sse_kms_key_id"),
+		StorageClass: opts.String("storage_class"),
+		ObjectACL:    opts.String("object_acl"),
+		Tags:         tags,
+
+		BucketLookup:       opts.String("bucket_lookup"),
+		CACert:             opts.String("ca_cert"),
+		CACertPath:         opts.String("ca_cert_path"),
+		InsecureSkipVerify: opts.Bool("insecure_skip_verify"),
+
+		CreateBucket: opts.Bool("create_bucket"),
+		BucketACL:    opts.String("bucket_acl"),
+	})
+}
@@ -0,0 +1,206 @@
+package azblob
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// authorizer adds credentials to a request.
+type authorizer interface {
+	authorize(req *http.Request) error
+}
+
+// sasAuth appends a shared access signature to the query.
+type sasAuth struct {
+	query url.Values
+}
+
+func newSASAuth(token string) (*sasAuth, error) {
+	query, err := url.ParseQuery(strings.TrimPrefix(token, "?"))
+	if err != nil {
+		return nil, fmt.Errorf("Invalid SAS token: %s", err)
+	}
+
+	return &sasAuth{query: query}, nil
+}
+
+func (a *sasAuth) authorize(req *http.Request) error {
+	query := req.URL.Query()
+
+	for k, v := range a.query {
+		query[k] = v
+	}
+
+	req.URL.RawQuery = query.Encode()
+	return nil
+}
+
+// sharedKeyAuth signs requests with the account key.
+type sharedKeyAuth struct {
+	account string
+	key     []byte
+}
+
+func newSharedKeyAuth(account, key string) (*sharedKeyAuth, error) {
+	decoded, err := base64.StdEncoding.DecodeString(key)
+	if err != nil {
+		return nil, fmt.Errorf("Invalid account key: %s", err)
+	}
+
+	return &sharedKeyAuth{account: account, key: decoded}, nil
+}
+
+func (a *sharedKeyAuth) authorize(req *http.Request) error {
+	mac := hmac.New(sha256.New, a.key)
+	mac.Write([]byte(a.stringToSign(req)))
+	signature := base64.StdEncoding.EncodeToString(mac.Sum(nil))
+
+	req.Header.Set("Authorization", fmt.Sprintf("SharedKey %s:%s", a.account, signature))
+	return nil
+}
+
+// stringToSign builds the string signed for the Blob service, see
+// https://docs.microsoft.com/rest/api/storageservices/authorize-with-shared-key
+func (a *sharedKeyAuth) stringToSign(req *http.Request) string {
+	contentLength := ""
+	if req.ContentLength > 0 {
+		contentLength = strconv.FormatInt(req.ContentLength, 10)
+	}
+
+	lines := []string{
+		req.Method,
+		req.Header.Get("Content-Encoding"),
+		req.Header.Get("Content-Language"),
+		contentLength,
+		req.Header.Get("Content-MD5"),
+		req.Header.Get("Content-Type"),
+		"", // Date, x-ms-date is used instead
+		req.Header.Get("If-Modified-Since"),
+		req.Header.Get("If-Match"),
+		req.Header.Get("If-None-Match"),
+		req.Header.Get("If-Unmodified-Since"),
+		req.Header.Get("Range"),
+	}
+
+	// Canonicalized headers
+	var names []string
+	for name := range req.Header {
+		if name = strings.ToLower(name); strings.HasPrefix(name, "x-ms-") {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+
+	var b strings.Builder
+	b.WriteString(strings.Join(lines, "\n"))
+	b.WriteString("\n")
+
+	for _, name := range names {
+		b.WriteString(name + ":" + strings.TrimSpace(req.Header.Get(name)) + "\n")
+	}
+
+	// Canonicalized resource
+	b.WriteString("/" + a.account + req.URL.EscapedPath())
+
+	query := req.URL.Query()
+	var params []string
+	for name := range query {
+		params = append(params, name)
+	}
+	sort.Strings(params)
+
+	for _, name := range params {
+		values := query[name]
+		sort.Strings(values)
+		b.WriteString("\n" + strings.ToLower(name) + ":" + strings.Join(values, ","))
+	}
+
+	return b.String()
+}
+
+// imdsEndpoint is the instance metadata service handing out managed identity
+// tokens.
+const imdsEndpoint = "http://169.254.169.254/metadata/identity/oauth2/token"
+
+// managedIdentityAuth sends bearer tokens of the managed identity of the
+// machine, refreshing them before they expire.
+type managedIdentityAuth struct {
+	clientID string
+	client   *http.Client
+
+	mu      sync.Mutex
+	token   string
+	expires time.Time
+}
+
+func (a *managedIdentityAuth) authorize(req *http.Request) error {
+	token, err := a.getToken()
+	if err != nil {
+		return err
+	}
+
+	req.Header.Set("Authorization", "Bearer "+token)
+	return nil
+}
+
+func (a *managedIdentityAuth) getToken() (string, error) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	// Refresh a few minutes early so requests never carry an expired token
+	if a.token != "" && time.Now().Add(5*time.Minute).Before(a.expires) {
+		return a.token, nil
+	}
+
+	query := url.Values{
+		"api-version": {"2018-02-01"},
+		"resource":    {"https://storage.azure.com/"},
+	}
+	if a.clientID != "" {
+		query.Set("client_id", a.clientID)
+	}
+
+	req, err := http.NewRequest(http.MethodGet, imdsEndpoint+"?"+query.Encode(), nil)
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Metadata", "true")
+
+	resp, err := a.client.Do(req)
+	if err != nil {
+		return "", fmt.Errorf("Failed to retrieve managed identity token: %s", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("Failed to retrieve managed identity token: %s", resp.Status)
+	}
+
+	var result struct {
+		AccessToken string `json:"access_token"`
+		ExpiresOn   string `json:"expires_on"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return "", err
+	}
+
+	expires, err := strconv.ParseInt(result.ExpiresOn, 10, 64)
+	if err != nil {
+		return "", fmt.Errorf("Invalid token expiry %s", result.ExpiresOn)
+	}
+
+	a.token = result.AccessToken
+	a.expires = time.Unix(expires, 0)
+
+	return a.token, nil
+}
@@ -0,0 +1,520 @@
+package azblob
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/dustin/go-humanize"
+	log "github.com/sirupsen/logrus"
+	"github.com/yingce/drone-oss-cache/lib/cache/storage"
+	"github.com/yingce/drone-oss-cache/lib/cache/storage/transport"
+)
+
+const (
+	// apiVersion is the Blob service version, bearer tokens need 2017-11-09
+	// or later.
+	apiVersion = "2019-12-12"
+
+	// defaultBlockSize is the size of the staged blocks.
+	defaultBlockSize = 8 * 1024 * 1024
+
+	// copyPollInterval is how often a pending copy is checked.
+	copyPollInterval = time.Second
+)
+
+// Options contains configuration for the Azure Blob connection.
+type Options struct {
+	// Endpoint of the Blob service, defaults to
+	// https://<account>.blob.core.windows.net. Azurite includes the account
+	// in the path, e.g. http://127.0.0.1:10000/devstoreaccount1
+	Endpoint string
+
+	Account string
+
+	// Key is the shared key of the account
+	Key string
+
+	// SAS is a shared access signature, used instead of the key
+	SAS string
+
+	// ClientID selects a user assigned managed identity, which is used when
+	// neither SAS nor key are set
+	ClientID string
+
+	// BlockSize is the size of the blocks uploads are staged in
+	BlockSize int64
+
+	// CACert and CACertPath are PEM encoded CAs trusted in addition to the
+	// system CAs, InsecureSkipVerify accepts any server certificate
+	CACert             string
+	CACertPath         string
+	InsecureSkipVerify bool
+}
+
+type azblobStorage struct {
+	client *http.Client
+	auth   authorizer
+	opts   *Options
+}
+
+// New method creates an implementation of Storage with Azure Blob as the
+// backend. The bucket of a path is the container.
+func New(opts *Options) (storage.Storage, error) {
+	if opts.Endpoint == "" {
+		if opts.Account == "" {
+			return nil, errors.New("No account or endpoint specified")
+		}
+
+		opts.Endpoint = fmt.Sprintf("https://%s.blob.core.windows.net", opts.Account)
+	}
+	opts.Endpoint = strings.TrimSuffix(opts.Endpoint, "/")
+
+	if opts.BlockSize <= 0 {
+		opts.BlockSize = defaultBlockSize
+	}
+
+	tr, err := transport.New(&transport.Options{
+		CACert:             opts.CACert,
+		CACertPath:         opts.CACertPath,
+		InsecureSkipVerify: opts.InsecureSkipVerify,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	var auth authorizer
+
+	switch {
+	case opts.SAS != "":
+		auth, err = newSASAuth(opts.SAS)
+	case opts.Key != "":
+		if opts.Account == "" {
+			return nil, errors.New("No account specified for the shared key")
+		}
+		auth, err = newSharedKeyAuth(opts.Account, opts.Key)
+	default:
+		mi := &managedIdentityAuth{clientID: opts.ClientID, client: http.DefaultClient}
+
+		// See if the managed identity can be retrieved
+		_, err = mi.getToken()
+		auth = mi
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	return &azblobStorage{
+		client: &http.Client{Transport: tr},
+		auth:   auth,
+		opts:   opts,
+	}, nil
+}
+
+func (s *azblobStorage) Get(p string, dst io.Writer) error {
+	container, blob := splitBucket(p)
+
+	if len(container) == 0 || len(blob) == 0 {
+		return fmt.Errorf("Invalid path %s", p)
+	}
+
+	log.Infof("Retrieving file in %s at %s", container, blob)
+
+	resp, err := s.do(http.MethodGet, s.blobURL(container, blob), nil, nil, nil)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if err := checkResponse(resp, http.StatusOK); err != nil {
+		return err
+	}
+
+	log.Infof("Copying object from the server")
+
+	numBytes, err := io.Copy(dst, resp.Body)
+
+	if err != nil {
+		return err
+	}
+
+	log.Infof("Downloaded %s from server", humanize.Bytes(uint64(numBytes)))
+
+	return nil
+}
+
+// Put stages the file in blocks as it is read and commits the block list at
+// the end, so a failed upload never replaces the blob.
+func (s *azblobStorage) Put(p string, src io.Reader) error {
+	container, blob := splitBucket(p)
+
+	log.Infof("Uploading to container %s at %s", container, blob)
+
+	if len(container) == 0 || len(blob) == 0 {
+		return fmt.Errorf("Invalid path %s", p)
+	}
+
+	// Uncommitted blocks of concurrent uploads to the same blob are shared,
+	// a random prefix keeps their IDs apart. All IDs of a blob need the same
+	// length.
+	prefix := make([]byte, 16)
+	if _, err := rand.Read(prefix); err != nil {
+		return err
+	}
+
+	u := s.blobURL(container, blob)
+	buf := make([]byte, s.opts.BlockSize)
+
+	var blocks []string
+	var total int64
+
+	for {
+		n, err := io.ReadFull(src, buf)
+
+		if n > 0 {
+			id := base64.StdEncoding.EncodeToString([]byte(fmt.Sprintf("%x-%08d", prefix, len(blocks))))
+
+			query := url.Values{"comp": {"block"}, "blockid": {id}}
+			if err := s.send(http.MethodPut, u, query, buf[:n], nil, http.StatusCreated); err != nil {
+				return err
+			}
+
+			blocks = append(blocks, id)
+			total += int64(n)
+		}
+
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			break
+		} else if err != nil {
+			return err
+		}
+	}
+
+	list := blockList{Latest: blocks}
+	body, err := xml.Marshal(list)
+	if err != nil {
+		return err
+	}
+
+	err = s.send(http.MethodPut, u, url.Values{"comp": {"blocklist"}}, append([]byte(xml.Header), body...), map[string]string{
+		"x-ms-blob-content-type": "application/tar",
+	}, http.StatusCreated)
+
+	if err != nil {
+		return err
+	}
+
+	log.Infof("Uploaded %s to server", humanize.Bytes(uint64(total)))
+
+	return nil
+}
+
+func (s *azblobStorage) List(p string) ([]storage.FileEntry, error) {
+	container, prefix := splitBucket(p)
+
+	log.Infof("Retrieving objects in container %s at %s", container, prefix)
+
+	if len(container) == 0 || len(prefix) == 0 {
+		return nil, fmt.Errorf("Invalid path %s", p)
+	}
+
+	var objects []storage.FileEntry
+
+	// Virtual directories are listed one level at a time, like a file system
+	dirs := []string{strings.TrimSuffix(prefix, "/") + "/"}
+
+	for len(dirs) > 0 {
+		dir := dirs[0]
+		dirs = dirs[1:]
+
+		blobs, subdirs, err := s.listDir(container, dir)
+		if err != nil {
+			return nil, err
+		}
+
+		objects = append(objects, blobs...)
+		dirs = append(dirs, subdirs...)
+	}
+
+	log.Infof("Found %d objects in container %s at %s", len(objects), container, prefix)
+
+	return objects, nil
+}
+
+// listDir returns the blobs and the virtual directories directly below dir.
+func (s *azblobStorage) listDir(container, dir string) ([]storage.FileEntry, []string, error) {
+	var objects []storage.FileEntry
+	var dirs []string
+	var marker string
+
+	for {
+		query := url.Values{
+			"restype":   {"container"},
+			"comp":      {"list"},
+			"prefix":    {dir},
+			"delimiter": {"/"},
+		}
+		if marker != "" {
+			query.Set("marker", marker)
+		}
+
+		resp, err := s.do(http.MethodGet, s.opts.Endpoint+"/"+url.PathEscape(container), query, nil, nil)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		var result enumerationResults
+		if err = checkResponse(resp, http.StatusOK); err == nil {
+			err = xml.NewDecoder(resp.Body).Decode(&result)
+		}
+		resp.Body.Close()
+
+		if err != nil {
+			return nil, nil, err
+		}
+
+		for _, b := range result.Blobs {
+			lastModified, _ := time.Parse(time.RFC1123, b.Properties.LastModified)
+			path := container + "/" + b.Name
+
+			objects = append(objects, storage.FileEntry{
+				Path:         path,
+				Size:         b.Properties.ContentLength,
+				LastModified: lastModified,
+			})
+			log.Debugf("Found object %s: Path=%s Size=%d LastModified=%s", b.Name, path, b.Properties.ContentLength, lastModified)
+		}
+
+		for _, prefix := range result.Prefixes {
+			dirs = append(dirs, prefix.Name)
+		}
+
+		if marker = result.NextMarker; marker == "" {
+			break
+		}
+	}
+
+	return objects, dirs, nil
+}
+
+func (s *azblobStorage) Exists(p string) (bool, error) {
+	container, blob := splitBucket(p)
+
+	if len(container) == 0 || len(blob) == 0 {
+		return false, fmt.Errorf("Invalid path %s", p)
+	}
+
+	resp, err := s.do(http.MethodHead, s.blobURL(container, blob), nil, nil, nil)
+	if err != nil {
+		return false, err
+	}
+	defer resp.Body.Close()
+
+	if err := checkResponse(resp, http.StatusOK); err != nil {
+		if errors.Is(err, storage.ErrNotFound) {
+			return false, nil
+		}
+		return false, err
+	}
+
+	return true, nil
+}
+
+func (s *azblobStorage) Delete(p string) error {
+	container, blob := splitBucket(p)
+
+	log.Infof("Deleting object in container %s at %s", container, blob)
+
+	if len(container) == 0 || len(blob) == 0 {
+		return fmt.Errorf("Invalid path %s", p)
+	}
+
+	return s.send(http.MethodDelete, s.blobURL(container, blob), nil, nil, nil, http.StatusAccepted)
+}
+
+// Copy copies the blob on the server and waits for pending copies to
+// finish.
+func (s *azblobStorage) Copy(src, dst string) error {
+	srcContainer, srcBlob := splitBucket(src)
+	dstContainer, dstBlob := splitBucket(dst)
+
+	if len(srcContainer) == 0 || len(srcBlob) == 0 {
+		return fmt.Errorf("Invalid path %s", src)
+	}
+	if len(dstContainer) == 0 || len(dstBlob) == 0 {
+		return fmt.Errorf("Invalid path %s", dst)
+	}
+
+	log.Infof("Copying object in container %s at %s to container %s at %s", srcContainer, srcBlob, dstContainer, dstBlob)
+
+	// The source needs the signature as well when a SAS is used
+	source, err := http.NewRequest(http.MethodGet, s.blobURL(srcContainer, srcBlob), nil)
+	if err != nil {
+		return err
+	}
+	if sas, ok := s.auth.(*sasAuth); ok {
+		sas.authorize(source)
+	}
+
+	u := s.blobURL(dstContainer, dstBlob)
+
+	resp, err := s.do(http.MethodPut, u, nil, nil, map[string]string{
+		"x-ms-copy-source": source.URL.String(),
+	})
+	if err != nil {
+		return err
+	}
+
+	err = checkResponse(resp, http.StatusAccepted)
+	resp.Body.Close()
+
+	if err != nil {
+		return err
+	}
+
+	status := resp.Header.Get("x-ms-copy-status")
+
+	for status == "pending" {
+		time.Sleep(copyPollInterval)
+
+		if resp, err = s.do(http.MethodHead, u, nil, nil, nil); err != nil {
+			return err
+		}
+
+		err = checkResponse(resp, http.StatusOK)
+		resp.Body.Close()
+
+		if err != nil {
+			return err
+		}
+
+		status = resp.Header.Get("x-ms-copy-status")
+	}
+
+	if status != "success" {
+		return fmt.Errorf("Failed to copy %s: %s %s", src, status, resp.Header.Get("x-ms-copy-status-description"))
+	}
+
+	log.Infof("Copied %s to %s on server", src, dst)
+
+	return nil
+}
+
+func (s *azblobStorage) blobURL(container, blob string) string {
+	return s.opts.Endpoint + "/" + url.PathEscape(container) + "/" + escapeBlob(blob)
+}
+
+// send does the request and checks the response, discarding its body.
+func (s *azblobStorage) send(method, u string, query url.Values, body []byte, header map[string]string, codes ...int) error {
+	resp, err := s.do(method, u, query, body, header)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	return checkResponse(resp, codes...)
+}
+
+func (s *azblobStorage) do(method, u string, query url.Values, body []byte, header map[string]string) (*http.Response, error) {
+	if len(query) > 0 {
+		u += "?" + query.Encode()
+	}
+
+	var r io.Reader
+	if body != nil {
+		r = bytes.NewReader(body)
+	}
+
+	req, err := http.NewRequest(method, u, r)
+	if err != nil {
+		return nil, err
+	}
+
+	req.Header.Set("x-ms-version", apiVersion)
+	req.Header.Set("x-ms-date", time.Now().UTC().Format(http.TimeFormat))
+
+	for k, v := range header {
+		req.Header.Set(k, v)
+	}
+
+	if err := s.auth.authorize(req); err != nil {
+		return nil, err
+	}
+
+	return s.client.Do(req)
+}
+
+// checkResponse maps unexpected responses to errors, 404 to
+// storage.ErrNotFound.
+func checkResponse(resp *http.Response, codes ...int) error {
+	for _, code := range codes {
+		if resp.StatusCode == code {
+			return nil
+		}
+	}
+
+	body, _ := ioutil.ReadAll(io.LimitReader(resp.Body, 1024))
+	err := fmt.Errorf("%s %s: %s %s %s", resp.Request.Method, resp.Request.URL.Path, resp.Status, resp.Header.Get("x-ms-error-code"), strings.TrimSpace(string(body)))
+
+	if resp.StatusCode == http.StatusNotFound {
+		return fmt.Errorf("%w: %s", storage.ErrNotFound, err)
+	}
+
+	return err
+}
+
+// escapeBlob escapes the blob name but keeps the slashes, which Azure
+// treats as virtual directories.
+func escapeBlob(blob string) string {
+	parts := strings.Split(blob, "/")
+
+	for i, part := range parts {
+		parts[i] = url.PathEscape(part)
+	}
+
+	return strings.Join(parts, "/")
+}
+
+type blockList struct {
+	XMLName xml.Name `xml:"BlockList"`
+	Latest  []string `xml:"Latest"`
+}
+
+type enumerationResults struct {
+	Blobs []struct {
+		Name       string `xml:"Name"`
+		Properties struct {
+			LastModified  string `xml:"Last-Modified"`
+			ContentLength int64  `xml:"Content-Length"`
+		} `xml:"Properties"`
+	} `xml:"Blobs>Blob"`
+	Prefixes []struct {
+		Name string `xml:"Name"`
+	} `xml:"Blobs>BlobPrefix"`
+	NextMarker string `xml:"NextMarker"`
+}
+
+func splitBucket(p string) (string, string) {
+	// Remove initial forward slash
+	full := strings.TrimPrefix(p, "/")
+
+	// Get first index
+	i := strings.Index(full, "/")
+
+	if i != -1 && len(full) != i+1 {
+		// Container names need to be all lower case for the blob it doesnt matter
+		return strings.ToLower(full[0:i]), full[i+1:]
+	}
+
+	return "", ""
+}
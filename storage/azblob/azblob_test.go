@@ -0,0 +1,215 @@
+package azblob
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/xml"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/franela/goblin"
+	"github.com/yingce/drone-oss-cache/lib/cache/storage"
+	"github.com/yingce/drone-oss-cache/lib/cache/storage/storagetest"
+)
+
+func TestAzblob(t *testing.T) {
+	g := goblin.Goblin(t)
+
+	g.Describe("azblob storage", func() {
+		var fake *fakeAzure
+		var ts *httptest.Server
+		var s storage.Storage
+
+		g.BeforeEach(func() {
+			fake = &fakeAzure{blobs: make(map[string][]byte), blocks: make(map[string][]byte)}
+			ts = httptest.NewServer(fake)
+
+			var err error
+			s, err = New(&Options{
+				Endpoint:  ts.URL + "/devstoreaccount1",
+				Account:   "devstoreaccount1",
+				Key:       base64.StdEncoding.EncodeToString([]byte("secret")),
+				BlockSize: 4,
+			})
+			if err != nil {
+				g.Fail(err)
+			}
+		})
+
+		g.AfterEach(func() {
+			ts.Close()
+		})
+
+		g.It("Should upload in blocks and download", func() {
+			err := s.Put("container/owner/repo/archive.tar", strings.NewReader("0123456789"))
+			g.Assert(err == nil).IsTrue(fmt.Sprintf("failed to put: %v", err))
+			g.Assert(fake.staged).Equal(3)
+
+			var buf bytes.Buffer
+			err = s.Get("container/owner/repo/archive.tar", &buf)
+			g.Assert(err == nil).IsTrue("failed to get")
+			g.Assert(buf.String()).Equal("0123456789")
+		})
+
+		g.It("Should use unique block IDs of the same length", func() {
+			s.Put("container/archive.tar", strings.NewReader("0123456789"))
+			s.Put("container/archive.tar", strings.NewReader("0123456789"))
+			g.Assert(len(fake.ids)).Equal(6)
+
+			seen := make(map[string]bool)
+			for _, id := range fake.ids {
+				g.Assert(seen[id]).IsFalse("reused block ID " + id)
+				g.Assert(len(id)).Equal(len(fake.ids[0]))
+				seen[id] = true
+			}
+		})
+
+		g.It("Should report the reason of a failed copy", func() {
+			err := s.Copy("container/missing.tar", "container/b.tar")
+			g.Assert(strings.Contains(err.Error(), "The specified blob does not exist.")).IsTrue(err.Error())
+		})
+
+		g.It("Should list virtual directories one level at a time", func() {
+			s.Put("container/owner/repo/a.tar", strings.NewReader("a"))
+			s.Put("container/owner/repo/master/b.tar", strings.NewReader("b"))
+			s.Put("container/owner/repository/c.tar", strings.NewReader("c"))
+
+			files, err := s.List("container/owner/repo")
+			g.Assert(err == nil).IsTrue("failed to list")
+			g.Assert(len(files)).Equal(2)
+			g.Assert(fake.delimiters).Equal([]string{"/", "/"})
+		})
+
+		g.It("Should sign requests with the shared key", func() {
+			s.Exists("container/a.tar")
+			g.Assert(strings.HasPrefix(fake.auth, "SharedKey devstoreaccount1:")).IsTrue(fake.auth)
+		})
+
+		g.It("Should append the SAS token", func() {
+			s, _ = New(&Options{Endpoint: ts.URL + "/devstoreaccount1", SAS: "?sv=2019-12-12&sig=abc"})
+
+			s.Exists("container/a.tar")
+			g.Assert(fake.sig).Equal("abc")
+		})
+
+		storagetest.Run(g, func() storage.Storage { return s })
+	})
+}
+
+// fakeAzure implements the parts of the Blob service the storage uses, with
+// the account in the path like Azurite. Blobs are keyed by container/name.
+type fakeAzure struct {
+	mu     sync.Mutex
+	blobs  map[string][]byte
+	blocks map[string][]byte
+	staged int
+	ids    []string
+
+	delimiters []string
+	auth       string
+	sig        string
+}
+
+func (f *fakeAzure) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.auth = r.Header.Get("Authorization")
+	f.sig = r.URL.Query().Get("sig")
+
+	parts := strings.SplitN(strings.TrimPrefix(r.URL.Path, "/devstoreaccount1/"), "/", 2)
+	query := r.URL.Query()
+
+	if len(parts) == 1 {
+		f.list(w, parts[0], query.Get("prefix"), query.Get("delimiter"))
+		return
+	}
+
+	name := parts[0] + "/" + parts[1]
+
+	switch {
+	case r.Method == http.MethodPut && query.Get("comp") == "block":
+		f.blocks[name+"/"+query.Get("blockid")], _ = ioutil.ReadAll(r.Body)
+		f.staged++
+		f.ids = append(f.ids, query.Get("blockid"))
+		w.WriteHeader(http.StatusCreated)
+	case r.Method == http.MethodPut && query.Get("comp") == "blocklist":
+		var list blockList
+		xml.NewDecoder(r.Body).Decode(&list)
+
+		var data []byte
+		for _, id := range list.Latest {
+			data = append(data, f.blocks[name+"/"+id]...)
+		}
+		f.blobs[name] = data
+		w.WriteHeader(http.StatusCreated)
+	case r.Method == http.MethodPut && r.Header.Get("x-ms-copy-source") != "":
+		source := strings.TrimPrefix(r.Header.Get("x-ms-copy-source"), "http://"+r.Host+"/devstoreaccount1/")
+		data, ok := f.blobs[source]
+		if !ok {
+			w.Header().Set("x-ms-error-code", "CannotVerifyCopySource")
+			w.WriteHeader(http.StatusNotFound)
+			w.Write([]byte("The specified blob does not exist."))
+			return
+		}
+		f.blobs[name] = data
+		w.Header().Set("x-ms-copy-status", "success")
+		w.WriteHeader(http.StatusAccepted)
+	default:
+		data, ok := f.blobs[name]
+		if !ok {
+			w.Header().Set("x-ms-error-code", "BlobNotFound")
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+
+		switch r.Method {
+		case http.MethodDelete:
+			delete(f.blobs, name)
+			w.WriteHeader(http.StatusAccepted)
+		case http.MethodGet:
+			w.Write(data)
+		}
+	}
+}
+
+func (f *fakeAzure) list(w http.ResponseWriter, container, prefix, delimiter string) {
+	f.delimiters = append(f.delimiters, delimiter)
+
+	var names []string
+	prefixes := make(map[string]bool)
+
+	for name := range f.blobs {
+		name = strings.TrimPrefix(name, container+"/")
+		if !strings.HasPrefix(name, prefix) {
+			continue
+		}
+
+		if i := strings.Index(name[len(prefix):], delimiter); delimiter != "" && i >= 0 {
+			prefixes[name[:len(prefix)+i+1]] = true
+		} else {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+
+	var b strings.Builder
+	b.WriteString(xml.Header + "<EnumerationResults><Blobs>")
+	for _, name := range names {
+		fmt.Fprintf(&b, "<Blob><Name>%s</Name><Properties><Last-Modified>%s</Last-Modified><Content-Length>%d</Content-Length></Properties></Blob>",
+			name, time.Now().UTC().Format(time.RFC1123), len(f.blobs[container+"/"+name]))
+	}
+	for p := range prefixes {
+		fmt.Fprintf(&b, "<BlobPrefix><Name>%s</Name></BlobPrefix>", p)
+	}
+	b.WriteString("</Blobs><NextMarker /></EnumerationResults>")
+
+	w.Write([]byte(b.String()))
+}
@@ -0,0 +1,40 @@
+package azblob
+
+import (
+	"github.com/yingce/drone-oss-cache/lib/cache/storage"
+)
+
+func init() {
+	storage.Register(storage.Provider{
+		Name:  "azblob",
+		Usage: "Azure Blob Storage, e.g. azblob://container/prefix",
+		Options: []storage.Option{
+			{Name: "endpoint", Usage: "azure blob endpoint, e.g. azurite", EnvVars: []string{"PLUGIN_AZBLOB_SERVER"}, Shared: "server"},
+			{Name: "account", Usage: "azure storage account", EnvVars: []string{"AZURE_STORAGE_ACCOUNT"}, Shared: "access-key"},
+			{Name: "key", Usage: "azure storage account key", EnvVars: []string{"AZURE_STORAGE_KEY"}, Shared: "secret-key"},
+			{Name: "sas", Usage: "azure shared access signature used instead of the key", EnvVars: []string{"AZURE_STORAGE_SAS_TOKEN"}},
+			{Name: "client_id", Usage: "client id of a user assigned managed identity, used without key and sas", EnvVars: []string{"AZURE_CLIENT_ID"}},
+			{Name: "block_size", Usage: "size of the blocks uploads are staged in, defaults to 8MiB", Type: storage.Size},
+			{Name: "ca_cert", Usage: "pem encoded ca trusted in addition to the system cas", Shared: "ca_cert"},
+			{Name: "ca_cert_path", Usage: "file of a pem encoded ca trusted in addition to the system cas", Shared: "ca_cert_path"},
+			{Name: "insecure_skip_verify", Usage: "accept any server certificate, only meant for labs", Type: storage.Bool, Shared: "insecure_skip_verify"},
+		},
+		New: FromValues,
+	})
+}
+
+// FromValues creates the storage from the options of the provider.
+func FromValues(opts storage.Values) (storage.Storage, error) {
+	return New(&Options{
+		Endpoint:  opts.String("endpoint"),
+		Account:   opts.String("account"),
+		Key:       opts.String("key"),
+		SAS:       opts.String("sas"),
+		ClientID:  opts.String("client_id"),
+		BlockSize: opts.Size("block_size"),
+
+		CACert:             opts.String("ca_cert"),
+		CACertPath:         opts.String("ca_cert_path"),
+		InsecureSkipVerify: opts.Bool("insecure_skip_verify"),
+	})
+}
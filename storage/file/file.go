@@ -0,0 +1,195 @@
+package file
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	pathutil "path"
+	"path/filepath"
+
+	"github.com/dustin/go-humanize"
+	log "github.com/sirupsen/logrus"
+	"github.com/yingce/drone-oss-cache/lib/cache/storage"
+)
+
+// Options contains configuration for the local filesystem.
+type Options struct {
+	// Root is the directory the paths are relative to
+	Root string
+}
+
+type fileStorage struct {
+	opts *Options
+}
+
+// New method creates an implementation of Storage with a local directory,
+// e.g. a network mount, as the backend.
+func New(opts *Options) (storage.Storage, error) {
+	if len(opts.Root) == 0 {
+		return nil, errors.New("No root directory specified")
+	}
+
+	return &fileStorage{
+		opts: opts,
+	}, nil
+}
+
+func (s *fileStorage) Get(p string, dst io.Writer) error {
+	local := s.local(p)
+
+	log.Infof("Retrieving file at %s", local)
+
+	f, err := os.Open(local)
+	if err != nil {
+		return notFound(err)
+	}
+	defer f.Close()
+
+	numBytes, err := io.Copy(dst, f)
+
+	if err != nil {
+		return err
+	}
+
+	log.Infof("Read %s from disk", humanize.Bytes(uint64(numBytes)))
+
+	return nil
+}
+
+func (s *fileStorage) Put(p string, src io.Reader) error {
+	local := s.local(p)
+
+	log.Infof("Writing file at %s", local)
+
+	numBytes, err := s.write(local, src)
+
+	if err != nil {
+		return err
+	}
+
+	log.Infof("Wrote %s to disk", humanize.Bytes(uint64(numBytes)))
+
+	return nil
+}
+
+func (s *fileStorage) List(p string) ([]storage.FileEntry, error) {
+	local := s.local(p)
+
+	log.Infof("Retrieving files at %s", local)
+
+	var files []storage.FileEntry
+
+	err := filepath.Walk(local, func(path string, fi os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+
+		if fi.IsDir() {
+			return nil
+		}
+
+		rel, err := filepath.Rel(s.opts.Root, path)
+		if err != nil {
+			return err
+		}
+
+		files = append(files, storage.FileEntry{
+			Path:         filepath.ToSlash(rel),
+			Size:         fi.Size(),
+			LastModified: fi.ModTime(),
+		})
+
+		return nil
+	})
+
+	if err != nil {
+		return nil, notFound(err)
+	}
+
+	log.Infof("Found %d files at %s", len(files), local)
+
+	return files, nil
+}
+
+func (s *fileStorage) Exists(p string) (bool, error) {
+	if _, err := os.Stat(s.local(p)); err != nil {
+		if os.IsNotExist(err) {
+			return false, nil
+		}
+
+		return false, err
+	}
+
+	return true, nil
+}
+
+func (s *fileStorage) Delete(p string) error {
+	local := s.local(p)
+
+	log.Infof("Deleting %s", local)
+
+	return notFound(os.Remove(local))
+}
+
+func (s *fileStorage) Copy(src, dst string) error {
+	localSrc := s.local(src)
+	localDst := s.local(dst)
+
+	log.Infof("Copying %s to %s", localSrc, localDst)
+
+	f, err := os.Open(localSrc)
+	if err != nil {
+		return notFound(err)
+	}
+	defer f.Close()
+
+	_, err = s.write(localDst, f)
+	return err
+}
+
+// write writes to a temporary file next to local and renames it into place
+// once complete, so readers never see a partial file.
+func (s *fileStorage) write(local string, src io.Reader) (int64, error) {
+	if err := os.MkdirAll(filepath.Dir(local), 0755); err != nil {
+		return 0, err
+	}
+
+	f, err := ioutil.TempFile(filepath.Dir(local), filepath.Base(local)+".*.tmp")
+	if err != nil {
+		return 0, err
+	}
+
+	numBytes, err := io.Copy(f, src)
+
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+
+	if err == nil {
+		err = os.Rename(f.Name(), local)
+	}
+
+	if err != nil {
+		os.Remove(f.Name())
+		return 0, err
+	}
+
+	return numBytes, nil
+}
+
+// local returns the path of p below the root. Cleaning it as an absolute
+// path keeps it inside the root.
+func (s *fileStorage) local(p string) string {
+	return filepath.Join(s.opts.Root, filepath.FromSlash(pathutil.Clean("/"+p)))
+}
+
+// notFound maps missing files to storage.ErrNotFound.
+func notFound(err error) error {
+	if os.IsNotExist(err) {
+		return fmt.Errorf("%w: %s", storage.ErrNotFound, err)
+	}
+
+	return err
+}
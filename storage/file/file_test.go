@@ -0,0 +1,95 @@
+package file
+
+import (
+	"bytes"
+	"errors"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/franela/goblin"
+	"github.com/yingce/drone-oss-cache/lib/cache/storage"
+)
+
+func TestFile(t *testing.T) {
+	g := goblin.Goblin(t)
+
+	g.Describe("file storage", func() {
+		var dir string
+		var s storage.Storage
+
+		g.BeforeEach(func() {
+			var err error
+			dir, err = ioutil.TempDir("", "file")
+			if err != nil {
+				g.Fail(err)
+			}
+
+			s, _ = New(&Options{Root: dir})
+		})
+
+		g.AfterEach(func() {
+			os.RemoveAll(dir)
+		})
+
+		g.It("Should write and read", func() {
+			err := s.Put("bucket/owner/repo/archive.tar", strings.NewReader("archive"))
+			g.Assert(err == nil).IsTrue("failed to put")
+
+			var buf bytes.Buffer
+			err = s.Get("bucket/owner/repo/archive.tar", &buf)
+			g.Assert(err == nil).IsTrue("failed to get")
+			g.Assert(buf.String()).Equal("archive")
+
+			files, _ := ioutil.ReadDir(filepath.Join(dir, "bucket", "owner", "repo"))
+			g.Assert(len(files)).Equal(1)
+		})
+
+		g.It("Should report missing files", func() {
+			err := s.Get("bucket/missing.tar", ioutil.Discard)
+			g.Assert(errors.Is(err, storage.ErrNotFound)).IsTrue("expected not found")
+
+			err = s.Delete("bucket/missing.tar")
+			g.Assert(errors.Is(err, storage.ErrNotFound)).IsTrue("expected not found")
+		})
+
+		g.It("Should list relative to the root", func() {
+			s.Put("bucket/owner/repo/a.tar", strings.NewReader("a"))
+			s.Put("bucket/owner/repo/master/b.tar", strings.NewReader("bb"))
+
+			files, err := s.List("bucket/owner/repo")
+			g.Assert(err == nil).IsTrue("failed to list")
+			g.Assert(len(files)).Equal(2)
+			g.Assert(files[0].Path).Equal("bucket/owner/repo/a.tar")
+			g.Assert(files[1].Size).Equal(int64(2))
+		})
+
+		g.It("Should keep paths inside the root", func() {
+			s.Put("../../escape.tar", strings.NewReader("x"))
+
+			_, err := os.Stat(filepath.Join(dir, "escape.tar"))
+			g.Assert(err == nil).IsTrue("wrote outside the root")
+		})
+
+		g.It("Should be opened from a URL", func() {
+			u, _ := url.Parse("file://" + filepath.ToSlash(dir) + "/cache/")
+
+			s, location, err := storage.Open(u)
+			g.Assert(err == nil).IsTrue("failed to open")
+			g.Assert(location).Equal(strings.Trim(filepath.ToSlash(dir), "/") + "/cache")
+
+			err = s.Put(location+"/a.tar", strings.NewReader("a"))
+			g.Assert(err == nil).IsTrue("failed to put")
+
+			_, err = os.Stat(filepath.Join(dir, "cache", "a.tar"))
+			g.Assert(err == nil).IsTrue("missing file")
+
+			u, _ = url.Parse("file:///srv/cache?size=1")
+			_, _, err = storage.Open(u)
+			g.Assert(err != nil).IsTrue("expected an unknown option error")
+		})
+	})
+}
@@ -0,0 +1,39 @@
+package file
+
+import (
+	"errors"
+	"net/url"
+	"strings"
+
+	"github.com/yingce/drone-oss-cache/lib/cache/storage"
+)
+
+func init() {
+	storage.Register(storage.Provider{
+		Name:  "file",
+		Usage: "Local directory, e.g. a network mount, e.g. file:///srv/cache",
+		Options: []storage.Option{
+			{Name: "root", Usage: "directory the cache is kept in"},
+		},
+		URL: FromURL,
+		New: FromValues,
+	})
+}
+
+// FromURL returns the options of file:///dir URLs. The directory is
+// absolute.
+func FromURL(u *url.URL) (url.Values, string, error) {
+	if len(u.Host) != 0 {
+		return nil, "", errors.New("Invalid file URL. Needs an absolute path, e.g. file:///srv/cache")
+	}
+
+	opts := u.Query()
+	opts.Set("root", "/")
+
+	return opts, strings.Trim(u.Path, "/"), nil
+}
+
+// FromValues creates the storage from the options of the provider.
+func FromValues(opts storage.Values) (storage.Storage, error) {
+	return New(&Options{Root: opts.String("root")})
+}
@@ -0,0 +1,520 @@
+package gcs
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/dustin/go-humanize"
+	log "github.com/sirupsen/logrus"
+	"github.com/yingce/drone-oss-cache/lib/cache/storage"
+	"github.com/yingce/drone-oss-cache/lib/cache/storage/transport"
+	"golang.org/x/oauth2"
+	"golang.org/x/oauth2/google"
+)
+
+const (
+	// defaultEndpoint is the public endpoint of the JSON API.
+	defaultEndpoint = "https://storage.googleapis.com"
+
+	// defaultChunkSize is the size of the parts of a resumable upload.
+	defaultChunkSize = 16 * 1024 * 1024
+
+	// chunkAlignment is the multiple every part but the last needs to be.
+	chunkAlignment = 256 * 1024
+
+	// scope allows reading and writing objects.
+	scope = "https://www.googleapis.com/auth/devstorage.read_write"
+)
+
+// Options contains configuration for the GCS connection.
+type Options struct {
+	// Endpoint of the JSON API, defaults to storage.googleapis.com. Set it to
+	// a fake server for testing.
+	Endpoint string
+
+	// JSONKey is a service account key. Without it the application default
+	// credentials are used, e.g. workload identity on GKE.
+	JSONKey string
+
+	// Anonymous sends requests without credentials, e.g. to a fake server.
+	Anonymous bool
+
+	// ChunkSize is the size of the parts of resumable uploads, rounded up to
+	// a multiple of 256KiB.
+	ChunkSize int64
+
+	// CACert and CACertPath are PEM encoded CAs trusted in addition to the
+	// system CAs, InsecureSkipVerify accepts any server certificate
+	CACert             string
+	CACertPath         string
+	InsecureSkipVerify bool
+}
+
+type gcsStorage struct {
+	client *http.Client
+	opts   *Options
+}
+
+// New method creates an implementation of Storage with GCS as the backend.
+func New(opts *Options) (storage.Storage, error) {
+	if opts.Endpoint == "" {
+		opts.Endpoint = defaultEndpoint
+	}
+	opts.Endpoint = strings.TrimSuffix(opts.Endpoint, "/")
+
+	if opts.ChunkSize <= 0 {
+		opts.ChunkSize = defaultChunkSize
+	}
+	if rem := opts.ChunkSize % chunkAlignment; rem != 0 {
+		opts.ChunkSize += chunkAlignment - rem
+	}
+
+	tr, err := transport.New(&transport.Options{
+		CACert:             opts.CACert,
+		CACertPath:         opts.CACertPath,
+		InsecureSkipVerify: opts.InsecureSkipVerify,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	client := &http.Client{Transport: tr}
+
+	if !opts.Anonymous {
+		ctx := context.Background()
+
+		var creds *google.Credentials
+		var err error
+
+		if len(opts.JSONKey) != 0 {
+			creds, err = google.CredentialsFromJSON(ctx, []byte(opts.JSONKey), scope)
+		} else {
+			creds, err = google.FindDefaultCredentials(ctx, scope)
+		}
+
+		if err != nil {
+			return nil, err
+		}
+
+		// See if a token can be retrieved
+		if _, err := creds.TokenSource.Token(); err != nil {
+			return nil, err
+		}
+
+		client = &http.Client{Transport: &oauth2.Transport{
+			Source: oauth2.ReuseTokenSource(nil, creds.TokenSource),
+			Base:   tr,
+		}}
+	}
+
+	return &gcsStorage{
+		client: client,
+		opts:   opts,
+	}, nil
+}
+
+func (s *gcsStorage) Get(p string, dst io.Writer) error {
+	bucket, key := splitBucket(p)
+
+	if len(bucket) == 0 || len(key) == 0 {
+		return fmt.Errorf("Invalid path %s", p)
+	}
+
+	log.Infof("Retrieving file in %s at %s", bucket, key)
+
+	resp, err := s.do(http.MethodGet, s.objectURL(bucket, key)+"?alt=media", nil, nil)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if err := checkResponse(resp, http.StatusOK); err != nil {
+		return err
+	}
+
+	log.Infof("Copying object from the server")
+
+	numBytes, err := io.Copy(dst, resp.Body)
+
+	if err != nil {
+		return err
+	}
+
+	log.Infof("Downloaded %s from server", humanize.Bytes(uint64(numBytes)))
+
+	return nil
+}
+
+// Put uploads the file with a resumable upload, sending it in chunks as it
+// is read.
+func (s *gcsStorage) Put(p string, src io.Reader) error {
+	bucket, key := splitBucket(p)
+
+	log.Infof("Uploading to bucket %s at %s", bucket, key)
+
+	if len(bucket) == 0 || len(key) == 0 {
+		return fmt.Errorf("Invalid path %s", p)
+	}
+
+	session, err := s.startUpload(bucket, key)
+	if err != nil {
+		return err
+	}
+
+	buf := make([]byte, 0, s.opts.ChunkSize)
+	var offset int64
+	eof := false
+
+	for {
+		if !eof {
+			n, err := io.ReadFull(src, buf[len(buf):cap(buf)])
+			buf = buf[:len(buf)+n]
+
+			if err == io.EOF || err == io.ErrUnexpectedEOF {
+				eof = true
+			} else if err != nil {
+				s.cancelUpload(session)
+				return err
+			}
+		}
+
+		committed, done, err := s.uploadChunk(session, buf, offset, eof)
+		if err != nil {
+			s.cancelUpload(session)
+			return err
+		}
+
+		if done {
+			break
+		}
+
+		// The server tells how much it kept, the rest is sent again
+		buf = buf[:copy(buf, buf[committed-offset:])]
+		offset = committed
+	}
+
+	log.Infof("Uploaded %s to server", humanize.Bytes(uint64(offset+int64(len(buf)))))
+
+	return nil
+}
+
+func (s *gcsStorage) List(p string) ([]storage.FileEntry, error) {
+	bucket, key := splitBucket(p)
+
+	log.Infof("Retrieving objects in bucket %s at %s", bucket, key)
+
+	if len(bucket) == 0 || len(key) == 0 {
+		return nil, fmt.Errorf("Invalid path %s", p)
+	}
+
+	var objects []storage.FileEntry
+	var pageToken string
+
+	for {
+		query := url.Values{"prefix": {key}}
+		if pageToken != "" {
+			query.Set("pageToken", pageToken)
+		}
+
+		u := fmt.Sprintf("%s/storage/v1/b/%s/o?%s", s.opts.Endpoint, url.PathEscape(bucket), query.Encode())
+
+		var list objectList
+		if err := s.getJSON(u, &list); err != nil {
+			return nil, err
+		}
+
+		for _, object := range list.Items {
+			size, _ := strconv.ParseInt(object.Size, 10, 64)
+			path := bucket + "/" + object.Name
+
+			objects = append(objects, storage.FileEntry{
+				Path:         path,
+				Size:         size,
+				LastModified: object.Updated,
+			})
+			log.Debugf("Found object %s: Path=%s Size=%d LastModified=%s", object.Name, path, size, object.Updated)
+		}
+
+		if pageToken = list.NextPageToken; pageToken == "" {
+			break
+		}
+	}
+
+	log.Infof("Found %d objects in bucket %s at %s", len(objects), bucket, key)
+
+	return objects, nil
+}
+
+func (s *gcsStorage) Exists(p string) (bool, error) {
+	bucket, key := splitBucket(p)
+
+	if len(bucket) == 0 || len(key) == 0 {
+		return false, fmt.Errorf("Invalid path %s", p)
+	}
+
+	var object objectInfo
+	if err := s.getJSON(s.objectURL(bucket, key), &object); err != nil {
+		if errors.Is(err, storage.ErrNotFound) {
+			return false, nil
+		}
+		return false, err
+	}
+
+	return true, nil
+}
+
+func (s *gcsStorage) Delete(p string) error {
+	bucket, key := splitBucket(p)
+
+	log.Infof("Deleting object in bucket %s at %s", bucket, key)
+
+	if len(bucket) == 0 || len(key) == 0 {
+		return fmt.Errorf("Invalid path %s", p)
+	}
+
+	resp, err := s.do(http.MethodDelete, s.objectURL(bucket, key), nil, nil)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	return checkResponse(resp, http.StatusNoContent, http.StatusOK)
+}
+
+// Copy rewrites the object on the server, large objects take several calls.
+func (s *gcsStorage) Copy(src, dst string) error {
+	srcBucket, srcKey := splitBucket(src)
+	dstBucket, dstKey := splitBucket(dst)
+
+	if len(srcBucket) == 0 || len(srcKey) == 0 {
+		return fmt.Errorf("Invalid path %s", src)
+	}
+	if len(dstBucket) == 0 || len(dstKey) == 0 {
+		return fmt.Errorf("Invalid path %s", dst)
+	}
+
+	log.Infof("Copying object in bucket %s at %s to bucket %s at %s", srcBucket, srcKey, dstBucket, dstKey)
+
+	u := fmt.Sprintf("%s/rewriteTo/b/%s/o/%s", s.objectURL(srcBucket, srcKey), url.PathEscape(dstBucket), url.PathEscape(dstKey))
+	var token string
+
+	for {
+		reqURL := u
+		if token != "" {
+			reqURL += "?rewriteToken=" + url.QueryEscape(token)
+		}
+
+		resp, err := s.do(http.MethodPost, reqURL, nil, nil)
+		if err != nil {
+			return err
+		}
+
+		var result rewriteResponse
+		err = decodeResponse(resp, &result)
+		resp.Body.Close()
+
+		if err != nil {
+			return err
+		}
+
+		if result.Done {
+			size, _ := strconv.ParseInt(result.ObjectSize, 10, 64)
+			log.Infof("Copied %s on server", humanize.Bytes(uint64(size)))
+			return nil
+		}
+
+		if token = result.RewriteToken; token == "" {
+			return errors.New("No rewrite token returned")
+		}
+	}
+}
+
+// startUpload starts a resumable upload and returns its session URL.
+func (s *gcsStorage) startUpload(bucket, key string) (string, error) {
+	u := fmt.Sprintf("%s/upload/storage/v1/b/%s/o?uploadType=resumable&name=%s", s.opts.Endpoint, url.PathEscape(bucket), url.QueryEscape(key))
+
+	body, err := json.Marshal(map[string]string{
+		"name":        key,
+		"contentType": "application/tar",
+	})
+	if err != nil {
+		return "", err
+	}
+
+	resp, err := s.do(http.MethodPost, u, bytes.NewReader(body), map[string]string{
+		"Content-Type": "application/json; charset=UTF-8",
+	})
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+
+	if err := checkResponse(resp, http.StatusOK, http.StatusCreated); err != nil {
+		return "", err
+	}
+
+	session := resp.Header.Get("Location")
+	if session == "" {
+		return "", errors.New("No upload session returned")
+	}
+
+	return session, nil
+}
+
+// uploadChunk sends the chunk starting at offset. It returns the offset the
+// server has committed up to and whether the upload is complete.
+func (s *gcsStorage) uploadChunk(session string, chunk []byte, offset int64, last bool) (int64, bool, error) {
+	end := offset + int64(len(chunk))
+
+	total := "*"
+	if last {
+		total = strconv.FormatInt(end, 10)
+	}
+
+	var contentRange string
+	if len(chunk) == 0 {
+		contentRange = fmt.Sprintf("bytes */%s", total)
+	} else {
+		contentRange = fmt.Sprintf("bytes %d-%d/%s", offset, end-1, total)
+	}
+
+	resp, err := s.do(http.MethodPut, session, bytes.NewReader(chunk), map[string]string{
+		"Content-Range": contentRange,
+	})
+	if err != nil {
+		return 0, false, err
+	}
+	defer resp.Body.Close()
+
+	// 308 Resume Incomplete, the Range header holds what was kept
+	if resp.StatusCode == http.StatusPermanentRedirect {
+		committed := offset
+
+		if r := resp.Header.Get("Range"); r != "" {
+			i := strings.LastIndex(r, "-")
+			last, err := strconv.ParseInt(r[i+1:], 10, 64)
+			if i == -1 || err != nil {
+				return 0, false, fmt.Errorf("Invalid range %s", r)
+			}
+			committed = last + 1
+		}
+
+		if committed < offset || committed > end {
+			return 0, false, fmt.Errorf("Invalid range %d-%d committed", offset, committed)
+		}
+
+		return committed, false, nil
+	}
+
+	if err := checkResponse(resp, http.StatusOK, http.StatusCreated); err != nil {
+		return 0, false, err
+	}
+
+	return end, true, nil
+}
+
+// cancelUpload discards a failed upload so nothing partial is kept.
+func (s *gcsStorage) cancelUpload(session string) {
+	resp, err := s.do(http.MethodDelete, session, nil, nil)
+	if err != nil {
+		log.Warnf("Failed to cancel upload: %s", err)
+		return
+	}
+	resp.Body.Close()
+}
+
+func (s *gcsStorage) objectURL(bucket, key string) string {
+	return fmt.Sprintf("%s/storage/v1/b/%s/o/%s", s.opts.Endpoint, url.PathEscape(bucket), url.PathEscape(key))
+}
+
+func (s *gcsStorage) getJSON(u string, v interface{}) error {
+	resp, err := s.do(http.MethodGet, u, nil, nil)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	return decodeResponse(resp, v)
+}
+
+func (s *gcsStorage) do(method, u string, body io.Reader, header map[string]string) (*http.Response, error) {
+	req, err := http.NewRequest(method, u, body)
+	if err != nil {
+		return nil, err
+	}
+
+	for k, v := range header {
+		req.Header.Set(k, v)
+	}
+
+	return s.client.Do(req)
+}
+
+func decodeResponse(resp *http.Response, v interface{}) error {
+	if err := checkResponse(resp, http.StatusOK); err != nil {
+		return err
+	}
+
+	return json.NewDecoder(resp.Body).Decode(v)
+}
+
+// checkResponse maps unexpected responses to errors, 404 to
+// storage.ErrNotFound.
+func checkResponse(resp *http.Response, codes ...int) error {
+	for _, code := range codes {
+		if resp.StatusCode == code {
+			return nil
+		}
+	}
+
+	body, _ := ioutil.ReadAll(io.LimitReader(resp.Body, 1024))
+	err := fmt.Errorf("%s %s: %s %s", resp.Request.Method, resp.Request.URL.Path, resp.Status, strings.TrimSpace(string(body)))
+
+	if resp.StatusCode == http.StatusNotFound {
+		return fmt.Errorf("%w: %s", storage.ErrNotFound, err)
+	}
+
+	return err
+}
+
+type objectInfo struct {
+	Name    string    `json:"name"`
+	Size    string    `json:"size"`
+	Updated time.Time `json:"updated"`
+}
+
+type objectList struct {
+	Items         []objectInfo `json:"items"`
+	NextPageToken string       `json:"nextPageToken"`
+}
+
+type rewriteResponse struct {
+	Done         bool   `json:"done"`
+	ObjectSize   string `json:"objectSize"`
+	RewriteToken string `json:"rewriteToken"`
+}
+
+func splitBucket(p string) (string, string) {
+	// Remove initial forward slash
+	full := strings.TrimPrefix(p, "/")
+
+	// Get first index
+	i := strings.Index(full, "/")
+
+	if i != -1 && len(full) != i+1 {
+		// Bucket names need to be all lower case for the key it doesnt matter
+		return strings.ToLower(full[0:i]), full[i+1:]
+	}
+
+	return "", ""
+}
@@ -0,0 +1,176 @@
+package gcs
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/franela/goblin"
+	"github.com/yingce/drone-oss-cache/lib/cache/storage"
+	"github.com/yingce/drone-oss-cache/lib/cache/storage/storagetest"
+)
+
+func TestGCS(t *testing.T) {
+	g := goblin.Goblin(t)
+
+	g.Describe("gcs storage", func() {
+		var fake *fakeGCS
+		var ts *httptest.Server
+		var s storage.Storage
+
+		g.BeforeEach(func() {
+			fake = &fakeGCS{objects: make(map[string][]byte), uploads: make(map[string]*bytes.Buffer)}
+			ts = httptest.NewServer(fake)
+
+			var err error
+			s, err = New(&Options{Endpoint: ts.URL, Anonymous: true, ChunkSize: 1})
+			if err != nil {
+				g.Fail(err)
+			}
+		})
+
+		g.AfterEach(func() {
+			ts.Close()
+		})
+
+		g.It("Should upload in chunks and download", func() {
+			data := strings.Repeat("x", chunkAlignment*2+10)
+
+			err := s.Put("bucket/owner/repo/archive.tar", strings.NewReader(data))
+			g.Assert(err == nil).IsTrue(fmt.Sprintf("failed to put: %v", err))
+			g.Assert(fake.chunks).Equal(3)
+
+			var buf bytes.Buffer
+			err = s.Get("bucket/owner/repo/archive.tar", &buf)
+			g.Assert(err == nil).IsTrue("failed to get")
+			g.Assert(buf.String() == data).IsTrue("content differs")
+		})
+
+		g.It("Should upload an empty file", func() {
+			err := s.Put("bucket/empty.tar", strings.NewReader(""))
+			g.Assert(err == nil).IsTrue(fmt.Sprintf("failed to put: %v", err))
+
+			exists, _ := s.Exists("bucket/empty.tar")
+			g.Assert(exists).IsTrue("missing empty file")
+		})
+
+		storagetest.Run(g, func() storage.Storage { return s })
+	})
+}
+
+// fakeGCS implements the parts of the JSON API the storage uses. Objects
+// are keyed by bucket/name.
+type fakeGCS struct {
+	mu      sync.Mutex
+	objects map[string][]byte
+	uploads map[string]*bytes.Buffer
+	chunks  int
+}
+
+func (f *fakeGCS) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	path := r.URL.EscapedPath()
+
+	switch {
+	case strings.HasPrefix(path, "/upload/storage/v1/b/") && r.Method == http.MethodPost:
+		bucket := strings.Split(strings.TrimPrefix(path, "/upload/storage/v1/b/"), "/")[0]
+		name := bucket + "/" + r.URL.Query().Get("name")
+		f.uploads[name] = &bytes.Buffer{}
+		w.Header().Set("Location", "http://"+r.Host+"/session?name="+name)
+	case path == "/session" && r.Method == http.MethodPut:
+		f.putChunk(w, r)
+	case path == "/session" && r.Method == http.MethodDelete:
+		delete(f.uploads, r.URL.Query().Get("name"))
+	case strings.HasPrefix(path, "/storage/v1/b/"):
+		f.object(w, r, strings.Split(strings.TrimPrefix(path, "/storage/v1/b/"), "/"))
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+func (f *fakeGCS) putChunk(w http.ResponseWriter, r *http.Request) {
+	name := r.URL.Query().Get("name")
+	upload := f.uploads[name]
+	data, _ := ioutil.ReadAll(r.Body)
+
+	if len(data) > 0 {
+		f.chunks++
+	}
+	upload.Write(data)
+
+	if strings.HasSuffix(r.Header.Get("Content-Range"), "/*") {
+		w.Header().Set("Range", fmt.Sprintf("bytes=0-%d", upload.Len()-1))
+		w.WriteHeader(http.StatusPermanentRedirect)
+		return
+	}
+
+	f.objects[name] = upload.Bytes()
+	delete(f.uploads, name)
+}
+
+// object handles /storage/v1/b/{bucket}/o[/{object}[/rewriteTo/b/{bucket}/o/{object}]].
+func (f *fakeGCS) object(w http.ResponseWriter, r *http.Request, parts []string) {
+	bucket := parts[0]
+
+	if len(parts) == 2 {
+		f.list(w, bucket, r.URL.Query().Get("prefix"))
+		return
+	}
+
+	name := bucket + "/" + unescape(parts[2])
+	data, ok := f.objects[name]
+
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+
+	switch {
+	case len(parts) == 8 && parts[3] == "rewriteTo":
+		f.objects[parts[5]+"/"+unescape(parts[7])] = data
+		json.NewEncoder(w).Encode(map[string]interface{}{"done": true, "objectSize": strconv.Itoa(len(data))})
+	case r.Method == http.MethodDelete:
+		delete(f.objects, name)
+		w.WriteHeader(http.StatusNoContent)
+	case r.URL.Query().Get("alt") == "media":
+		w.Write(data)
+	default:
+		json.NewEncoder(w).Encode(objectInfo{Name: unescape(parts[2]), Size: strconv.Itoa(len(data))})
+	}
+}
+
+func (f *fakeGCS) list(w http.ResponseWriter, bucket, prefix string) {
+	var list objectList
+
+	for name, data := range f.objects {
+		if strings.HasPrefix(name, bucket+"/"+prefix) {
+			list.Items = append(list.Items, objectInfo{
+				Name:    strings.TrimPrefix(name, bucket+"/"),
+				Size:    strconv.Itoa(len(data)),
+				Updated: time.Now(),
+			})
+		}
+	}
+
+	// Sorted like the real API
+	sort.Slice(list.Items, func(i, j int) bool {
+		return list.Items[i].Name < list.Items[j].Name
+	})
+
+	json.NewEncoder(w).Encode(list)
+}
+
+func unescape(s string) string {
+	return strings.Replace(s, "%2F", "/", -1)
+}
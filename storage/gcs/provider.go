@@ -0,0 +1,37 @@
+package gcs
+
+import (
+	"github.com/yingce/drone-oss-cache/lib/cache/storage"
+)
+
+func init() {
+	storage.Register(storage.Provider{
+		Name:    "gcs",
+		Usage:   "Google Cloud Storage, e.g. gs://bucket/prefix",
+		Schemes: []string{"gs"},
+		Options: []storage.Option{
+			{Name: "endpoint", Usage: "gcs server, e.g. a fake server for testing", EnvVars: []string{"PLUGIN_GCS_SERVER"}, Shared: "server"},
+			{Name: "json_key", Usage: "gcs service account key, defaults to the application default credentials", EnvVars: []string{"PLUGIN_JSON_KEY"}},
+			{Name: "anonymous", Usage: "send gcs requests without credentials", Type: storage.Bool},
+			{Name: "chunk_size", Usage: "size of the chunks of resumable uploads, defaults to 16MiB", Type: storage.Size},
+			{Name: "ca_cert", Usage: "pem encoded ca trusted in addition to the system cas", Shared: "ca_cert"},
+			{Name: "ca_cert_path", Usage: "file of a pem encoded ca trusted in addition to the system cas", Shared: "ca_cert_path"},
+			{Name: "insecure_skip_verify", Usage: "accept any server certificate, only meant for labs", Type: storage.Bool, Shared: "insecure_skip_verify"},
+		},
+		New: FromValues,
+	})
+}
+
+// FromValues creates the storage from the options of the provider.
+func FromValues(opts storage.Values) (storage.Storage, error) {
+	return New(&Options{
+		Endpoint:  opts.String("endpoint"),
+		JSONKey:   opts.String("json_key"),
+		Anonymous: opts.Bool("anonymous"),
+		ChunkSize: opts.Size("chunk_size"),
+
+		CACert:             opts.String("ca_cert"),
+		CACertPath:         opts.String("ca_cert_path"),
+		InsecureSkipVerify: opts.Bool("insecure_skip_verify"),
+	})
+}
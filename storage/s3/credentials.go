@@ -0,0 +1,585 @@
+package s3
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/go-ini/ini"
+	"github.com/minio/minio-go/pkg/credentials"
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	// defaultSTSEndpoint is the global STS endpoint
+	defaultSTSEndpoint = "https://sts.amazonaws.com"
+
+	// defaultSessionName is the session name of assumed roles
+	defaultSessionName = "drone-cache"
+
+	// expiryWindow refreshes temporary credentials before they expire, so
+	// the parts of long uploads are signed with valid credentials
+	expiryWindow = 5 * time.Minute
+
+	iso8601 = "20060102T150405Z"
+
+	// ecsEndpoint serves the credentials of ECS tasks below
+	// AWS_CONTAINER_CREDENTIALS_RELATIVE_URI
+	ecsEndpoint = "http://169.254.170.2"
+)
+
+// newCredentials returns the credentials of the options. Without static keys
+// it searches the environment, the shared credentials and config files, web
+// identity, the ECS task role and the instance role in that order. RoleARN is assumed on top of
+// the credentials found. Roles are assumed through the transport of the
+// storage.
+func newCredentials(opts *Options, tr http.RoundTripper) (*credentials.Credentials, error) {
+	sts := &stsClient{
+		endpoint: opts.STSEndpoint,
+		region:   opts.Region,
+		client:   &http.Client{Transport: tr, Timeout: 30 * time.Second},
+	}
+
+	// The global endpoint only signs in us-east-1
+	if len(sts.endpoint) == 0 {
+		sts.endpoint = defaultSTSEndpoint
+		sts.region = "us-east-1"
+	}
+
+	if len(sts.region) == 0 {
+		sts.region = "us-east-1"
+	}
+
+	var creds *credentials.Credentials
+
+	if len(opts.Access) != 0 && len(opts.Secret) != 0 {
+		creds = credentials.NewStaticV4(opts.Access, opts.Secret, opts.Token)
+	} else {
+		providers := []credentials.Provider{
+			&credentials.EnvAWS{},
+			&credentials.EnvMinio{},
+			&cached{credentials.NewFileAWSCredentials("", opts.Profile)},
+		}
+
+		profile, err := profileProvider(sts, opts.Profile)
+		if err != nil {
+			return nil, err
+		}
+
+		if profile != nil {
+			providers = append(providers, profile)
+		}
+
+		if tokenFile := os.Getenv("AWS_WEB_IDENTITY_TOKEN_FILE"); len(tokenFile) != 0 {
+			providers = append(providers, &webIdentity{
+				sts:         sts,
+				tokenFile:   tokenFile,
+				roleARN:     os.Getenv("AWS_ROLE_ARN"),
+				sessionName: os.Getenv("AWS_ROLE_SESSION_NAME"),
+			})
+		}
+
+		if hasContainerCredentials() {
+			providers = append(providers, newContainerCredentials())
+		}
+
+		providers = append(providers, &credentials.IAM{
+			Client: &http.Client{Timeout: 5 * time.Second},
+		})
+
+		creds = credentials.New(&chain{providers: providers})
+	}
+
+	if len(opts.RoleARN) != 0 {
+		creds = credentials.New(&assumeRole{
+			sts:         sts,
+			source:      creds,
+			roleARN:     opts.RoleARN,
+			externalID:  opts.ExternalID,
+			sessionName: opts.SessionName,
+		})
+	}
+
+	return creds, nil
+}
+
+// chain returns the credentials of the first provider that has some, like
+// credentials.Chain, but logs why providers were skipped and keeps anonymous
+// access instead of searching again on every request.
+type chain struct {
+	providers []credentials.Provider
+	curr      credentials.Provider
+	anonymous bool
+}
+
+func (c *chain) Retrieve() (credentials.Value, error) {
+	for _, p := range c.providers {
+		v, err := p.Retrieve()
+
+		if err != nil {
+			log.Debugf("Skipping %T credentials: %s", p, err)
+			continue
+		}
+
+		if len(v.AccessKeyID) == 0 || len(v.SecretAccessKey) == 0 {
+			continue
+		}
+
+		c.curr = p
+		c.anonymous = false
+
+		return v, nil
+	}
+
+	log.Warnf("No S3 credentials found, sending anonymous requests")
+
+	c.curr = nil
+	c.anonymous = true
+
+	return credentials.Value{SignerType: credentials.SignatureAnonymous}, nil
+}
+
+func (c *chain) IsExpired() bool {
+	if c.curr != nil {
+		return c.curr.IsExpired()
+	}
+
+	return !c.anonymous
+}
+
+// cached adapts credentials to a provider of a chain.
+type cached struct {
+	creds *credentials.Credentials
+}
+
+func (c *cached) Retrieve() (credentials.Value, error) {
+	return c.creds.Get()
+}
+
+func (c *cached) IsExpired() bool {
+	return c.creds.IsExpired()
+}
+
+// profileProvider returns the role of a profile of the shared config file,
+// with web_identity_token_file or a source_profile or credential_source to
+// assume role_arn with. Profiles without a role return nil.
+func profileProvider(sts *stsClient, profile string) (credentials.Provider, error) {
+	if len(profile) == 0 {
+		profile = os.Getenv("AWS_PROFILE")
+	}
+
+	if len(profile) == 0 {
+		profile = "default"
+	}
+
+	section, err := loadConfig(profile)
+	if err != nil || section == nil {
+		return nil, err
+	}
+
+	roleARN := section.Key("role_arn").String()
+	if len(roleARN) == 0 {
+		return nil, nil
+	}
+
+	if tokenFile := section.Key("web_identity_token_file").String(); len(tokenFile) != 0 {
+		return &webIdentity{
+			sts:         sts,
+			tokenFile:   tokenFile,
+			roleARN:     roleARN,
+			sessionName: section.Key("role_session_name").String(),
+		}, nil
+	}
+
+	var source *credentials.Credentials
+
+	if sourceProfile := section.Key("source_profile").String(); len(sourceProfile) != 0 {
+		source = credentials.NewFileAWSCredentials("", sourceProfile)
+	} else {
+		switch credentialSource := section.Key("credential_source").String(); credentialSource {
+		case "Environment":
+			source = credentials.NewEnvAWS()
+		case "Ec2InstanceMetadata":
+			source = credentials.NewIAM("")
+		case "EcsContainer":
+			if !hasContainerCredentials() {
+				return nil, fmt.Errorf("Profile %s uses EcsContainer outside of an ECS task", profile)
+			}
+
+			source = credentials.New(newContainerCredentials())
+		default:
+			return nil, fmt.Errorf("Profile %s needs source_profile or credential_source to assume %s", profile, roleARN)
+		}
+	}
+
+	return &assumeRole{
+		sts:         sts,
+		source:      source,
+		roleARN:     roleARN,
+		externalID:  section.Key("external_id").String(),
+		sessionName: section.Key("role_session_name").String(),
+	}, nil
+}
+
+// loadConfig returns the section of a profile in the shared config file,
+// nil when there is none.
+func loadConfig(profile string) (*ini.Section, error) {
+	filename := os.Getenv("AWS_CONFIG_FILE")
+
+	if len(filename) == 0 {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return nil, nil
+		}
+
+		filename = filepath.Join(home, ".aws", "config")
+	}
+
+	if _, err := os.Stat(filename); os.IsNotExist(err) {
+		return nil, nil
+	}
+
+	cfg, err := ini.Load(filename)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to read %s: %s", filename, err)
+	}
+
+	name := "profile " + profile
+	if profile == "default" {
+		name = profile
+	}
+
+	section, err := cfg.GetSection(name)
+	if err != nil {
+		return nil, nil
+	}
+
+	return section, nil
+}
+
+// webIdentity exchanges an OIDC token, e.g. of an EKS service account, for
+// the credentials of a role.
+type webIdentity struct {
+	credentials.Expiry
+
+	sts         *stsClient
+	tokenFile   string
+	roleARN     string
+	sessionName string
+}
+
+func (w *webIdentity) Retrieve() (credentials.Value, error) {
+	if len(w.roleARN) == 0 {
+		return credentials.Value{}, fmt.Errorf("No role specified for the web identity token %s", w.tokenFile)
+	}
+
+	// The token is rotated on disk, read it on every refresh
+	token, err := ioutil.ReadFile(w.tokenFile)
+	if err != nil {
+		return credentials.Value{}, err
+	}
+
+	sessionName := w.sessionName
+	if len(sessionName) == 0 {
+		sessionName = defaultSessionName
+	}
+
+	creds, err := w.sts.call(url.Values{
+		"Action":           {"AssumeRoleWithWebIdentity"},
+		"RoleArn":          {w.roleARN},
+		"RoleSessionName":  {sessionName},
+		"WebIdentityToken": {strings.TrimSpace(string(token))},
+	}, nil)
+	if err != nil {
+		return credentials.Value{}, err
+	}
+
+	w.SetExpiration(creds.Expiration, expiryWindow)
+
+	log.Debugf("Assumed %s with web identity until %s", w.roleARN, creds.Expiration)
+
+	return creds.value(), nil
+}
+
+// containerCredentials returns the credentials of the task role of ECS
+// containers, served at AWS_CONTAINER_CREDENTIALS_RELATIVE_URI of the ECS
+// endpoint or at AWS_CONTAINER_CREDENTIALS_FULL_URI.
+type containerCredentials struct {
+	credentials.Expiry
+
+	endpoint string
+	client   *http.Client
+}
+
+func newContainerCredentials() *containerCredentials {
+	return &containerCredentials{
+		endpoint: ecsEndpoint,
+		client:   &http.Client{Timeout: 5 * time.Second},
+	}
+}
+
+// hasContainerCredentials reports whether ECS provides the credentials of a
+// task role.
+func hasContainerCredentials() bool {
+	return len(os.Getenv("AWS_CONTAINER_CREDENTIALS_RELATIVE_URI")) != 0 ||
+		len(os.Getenv("AWS_CONTAINER_CREDENTIALS_FULL_URI")) != 0
+}
+
+func (c *containerCredentials) Retrieve() (credentials.Value, error) {
+	u := os.Getenv("AWS_CONTAINER_CREDENTIALS_FULL_URI")
+
+	if relative := os.Getenv("AWS_CONTAINER_CREDENTIALS_RELATIVE_URI"); len(relative) != 0 {
+		u = c.endpoint + relative
+	}
+
+	if len(u) == 0 {
+		return credentials.Value{}, errors.New("No ECS container credentials endpoint")
+	}
+
+	req, err := http.NewRequest(http.MethodGet, u, nil)
+	if err != nil {
+		return credentials.Value{}, err
+	}
+
+	if token := os.Getenv("AWS_CONTAINER_AUTHORIZATION_TOKEN"); len(token) != 0 {
+		req.Header.Set("Authorization", token)
+	}
+
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return credentials.Value{}, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		msg, _ := ioutil.ReadAll(io.LimitReader(resp.Body, 1024))
+		return credentials.Value{}, fmt.Errorf("ECS container credentials failed: %s %s", resp.Status, strings.TrimSpace(string(msg)))
+	}
+
+	var creds struct {
+		AccessKeyID     string    `json:"AccessKeyId"`
+		SecretAccessKey string    `json:"SecretAccessKey"`
+		Token           string    `json:"Token"`
+		Expiration      time.Time `json:"Expiration"`
+	}
+
+	if err := json.NewDecoder(resp.Body).Decode(&creds); err != nil {
+		return credentials.Value{}, err
+	}
+
+	c.SetExpiration(creds.Expiration, expiryWindow)
+
+	return credentials.Value{
+		AccessKeyID:     creds.AccessKeyID,
+		SecretAccessKey: creds.SecretAccessKey,
+		SessionToken:    creds.Token,
+		SignerType:      credentials.SignatureV4,
+	}, nil
+}
+
+// assumeRole assumes a role with the source credentials, e.g. of another
+// account.
+type assumeRole struct {
+	credentials.Expiry
+
+	sts         *stsClient
+	source      *credentials.Credentials
+	roleARN     string
+	externalID  string
+	sessionName string
+}
+
+func (a *assumeRole) Retrieve() (credentials.Value, error) {
+	source, err := a.source.Get()
+	if err != nil {
+		return credentials.Value{}, err
+	}
+
+	if len(source.AccessKeyID) == 0 || len(source.SecretAccessKey) == 0 {
+		return credentials.Value{}, fmt.Errorf("No credentials to assume %s with", a.roleARN)
+	}
+
+	sessionName := a.sessionName
+	if len(sessionName) == 0 {
+		sessionName = defaultSessionName
+	}
+
+	params := url.Values{
+		"Action":          {"AssumeRole"},
+		"RoleArn":         {a.roleARN},
+		"RoleSessionName": {sessionName},
+	}
+
+	if len(a.externalID) != 0 {
+		params.Set("ExternalId", a.externalID)
+	}
+
+	creds, err := a.sts.call(params, &source)
+	if err != nil {
+		return credentials.Value{}, err
+	}
+
+	a.SetExpiration(creds.Expiration, expiryWindow)
+
+	log.Debugf("Assumed %s until %s", a.roleARN, creds.Expiration)
+
+	return creds.value(), nil
+}
+
+// stsClient sends requests to the STS query API.
+type stsClient struct {
+	endpoint string
+	region   string
+	client   *http.Client
+}
+
+type stsCredentials struct {
+	AccessKeyID     string    `xml:"AccessKeyId"`
+	SecretAccessKey string    `xml:"SecretAccessKey"`
+	SessionToken    string    `xml:"SessionToken"`
+	Expiration      time.Time `xml:"Expiration"`
+}
+
+func (c stsCredentials) value() credentials.Value {
+	return credentials.Value{
+		AccessKeyID:     c.AccessKeyID,
+		SecretAccessKey: c.SecretAccessKey,
+		SessionToken:    c.SessionToken,
+		SignerType:      credentials.SignatureV4,
+	}
+}
+
+type stsResponse struct {
+	AssumeRole  stsCredentials `xml:"AssumeRoleResult>Credentials"`
+	WebIdentity stsCredentials `xml:"AssumeRoleWithWebIdentityResult>Credentials"`
+}
+
+// call sends an action, signed when creds are given.
+func (c *stsClient) call(params url.Values, creds *credentials.Value) (*stsCredentials, error) {
+	params.Set("Version", "2011-06-15")
+	body := params.Encode()
+
+	req, err := http.NewRequest(http.MethodPost, c.endpoint, strings.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded; charset=utf-8")
+
+	if creds != nil {
+		c.sign(req, body, *creds, time.Now().UTC())
+	}
+
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		msg, _ := ioutil.ReadAll(io.LimitReader(resp.Body, 1024))
+		return nil, fmt.Errorf("%s failed: %s %s", params.Get("Action"), resp.Status, strings.TrimSpace(string(msg)))
+	}
+
+	var r stsResponse
+	if err := xml.NewDecoder(resp.Body).Decode(&r); err != nil {
+		return nil, err
+	}
+
+	result := r.AssumeRole
+	if len(result.AccessKeyID) == 0 {
+		result = r.WebIdentity
+	}
+
+	if len(result.AccessKeyID) == 0 {
+		return nil, fmt.Errorf("%s returned no credentials", params.Get("Action"))
+	}
+
+	return &result, nil
+}
+
+// sign adds a signature version 4 for the sts service to the request.
+func (c *stsClient) sign(req *http.Request, body string, creds credentials.Value, t time.Time) {
+	req.Header.Set("X-Amz-Date", t.Format(iso8601))
+
+	if len(creds.SessionToken) != 0 {
+		req.Header.Set("X-Amz-Security-Token", creds.SessionToken)
+	}
+
+	headers := []string{"content-type", "host", "x-amz-date"}
+	if len(creds.SessionToken) != 0 {
+		headers = append(headers, "x-amz-security-token")
+	}
+
+	var canonicalHeaders strings.Builder
+	for _, h := range headers {
+		v := req.Header.Get(h)
+		if h == "host" {
+			v = req.URL.Host
+		}
+
+		canonicalHeaders.WriteString(h + ":" + strings.TrimSpace(v) + "\n")
+	}
+
+	path := req.URL.EscapedPath()
+	if len(path) == 0 {
+		path = "/"
+	}
+
+	signedHeaders := strings.Join(headers, ";")
+
+	canonicalRequest := strings.Join([]string{
+		req.Method,
+		path,
+		req.URL.RawQuery,
+		canonicalHeaders.String(),
+		signedHeaders,
+		hexSHA256(body),
+	}, "\n")
+
+	date := t.Format("20060102")
+	scope := date + "/" + c.region + "/sts/aws4_request"
+
+	stringToSign := strings.Join([]string{
+		"AWS4-HMAC-SHA256",
+		t.Format(iso8601),
+		scope,
+		hexSHA256(canonicalRequest),
+	}, "\n")
+
+	key := hmacSHA256([]byte("AWS4"+creds.SecretAccessKey), date)
+	key = hmacSHA256(key, c.region)
+	key = hmacSHA256(key, "sts")
+	key = hmacSHA256(key, "aws4_request")
+
+	req.Header.Set("Authorization", fmt.Sprintf(
+		"AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		creds.AccessKeyID,
+		scope,
+		signedHeaders,
+		hex.EncodeToString(hmacSHA256(key, stringToSign)),
+	))
+}
+
+func hexSHA256(s string) string {
+	sum := sha256.Sum256([]byte(s))
+	return hex.EncodeToString(sum[:])
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	h := hmac.New(sha256.New, key)
+	h.Write([]byte(data))
+	return h.Sum(nil)
+}
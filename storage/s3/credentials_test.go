@@ -0,0 +1,208 @@
+package s3
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/franela/goblin"
+	"github.com/minio/minio-go/pkg/credentials"
+)
+
+const stsResult = `<%[1]sResponse xmlns="https://sts.amazonaws.com/doc/2011-06-15/">
+  <%[1]sResult>
+    <Credentials>
+      <AccessKeyId>ASIA%[2]d</AccessKeyId>
+      <SecretAccessKey>secret</SecretAccessKey>
+      <SessionToken>token</SessionToken>
+      <Expiration>%[3]s</Expiration>
+    </Credentials>
+  </%[1]sResult>
+</%[1]sResponse>`
+
+func TestCredentials(t *testing.T) {
+	g := goblin.Goblin(t)
+
+	g.Describe("s3 credentials", func() {
+		var server *httptest.Server
+		var requests []*http.Request
+		var expiration time.Duration
+		var dir string
+
+		g.BeforeEach(func() {
+			requests = nil
+			expiration = time.Hour
+
+			server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				r.ParseForm()
+				requests = append(requests, r)
+
+				fmt.Fprintf(w, stsResult, r.Form.Get("Action"), len(requests), time.Now().Add(expiration).UTC().Format(time.RFC3339))
+			}))
+
+			dir, _ = ioutil.TempDir("", "s3")
+		})
+
+		g.AfterEach(func() {
+			server.Close()
+			os.RemoveAll(dir)
+		})
+
+		g.It("Should exchange a web identity token", func() {
+			tokenFile := filepath.Join(dir, "token")
+			ioutil.WriteFile(tokenFile, []byte("jwt\n"), 0600)
+
+			creds := credentials.New(&webIdentity{
+				sts:       &stsClient{endpoint: server.URL, region: "us-east-1", client: http.DefaultClient},
+				tokenFile: tokenFile,
+				roleARN:   "arn:aws:iam::123456789012:role/cache",
+			})
+
+			v, err := creds.Get()
+			g.Assert(err == nil).IsTrue("failed to get credentials")
+			g.Assert(v.AccessKeyID).Equal("ASIA1")
+			g.Assert(v.SessionToken).Equal("token")
+			g.Assert(creds.IsExpired()).IsFalse()
+
+			g.Assert(requests[0].Form.Get("WebIdentityToken")).Equal("jwt")
+			g.Assert(requests[0].Form.Get("RoleSessionName")).Equal(defaultSessionName)
+			g.Assert(requests[0].Header.Get("Authorization")).Equal("")
+		})
+
+		g.It("Should assume a role and refresh it before it expires", func() {
+			expiration = time.Minute
+
+			creds := credentials.New(&assumeRole{
+				sts:         &stsClient{endpoint: server.URL, region: "eu-west-1", client: http.DefaultClient},
+				source:      credentials.NewStaticV4("AKIDEXAMPLE", "secret", ""),
+				roleARN:     "arn:aws:iam::123456789012:role/cache",
+				externalID:  "external",
+				sessionName: "build",
+			})
+
+			v, err := creds.Get()
+			g.Assert(err == nil).IsTrue("failed to get credentials")
+			g.Assert(v.AccessKeyID).Equal("ASIA1")
+
+			auth := requests[0].Header.Get("Authorization")
+			g.Assert(strings.HasPrefix(auth, "AWS4-HMAC-SHA256 Credential=AKIDEXAMPLE/")).IsTrue(auth)
+			g.Assert(strings.Contains(auth, "/eu-west-1/sts/aws4_request")).IsTrue(auth)
+			g.Assert(requests[0].Form.Get("ExternalId")).Equal("external")
+			g.Assert(requests[0].Form.Get("RoleSessionName")).Equal("build")
+
+			// Within the expiry window
+			g.Assert(creds.IsExpired()).IsTrue()
+
+			v, err = creds.Get()
+			g.Assert(err == nil).IsTrue("failed to refresh credentials")
+			g.Assert(v.AccessKeyID).Equal("ASIA2")
+		})
+
+		g.It("Should not assume a role without credentials", func() {
+			creds := credentials.New(&assumeRole{
+				sts:     &stsClient{endpoint: server.URL, region: "us-east-1", client: http.DefaultClient},
+				source:  credentials.New(&chain{}),
+				roleARN: "arn:aws:iam::123456789012:role/cache",
+			})
+
+			_, err := creds.Get()
+			g.Assert(err != nil).IsTrue("expected an error")
+			g.Assert(len(requests)).Equal(0)
+		})
+
+		g.It("Should read roles of config profiles", func() {
+			config := filepath.Join(dir, "config")
+			ioutil.WriteFile(config, []byte(`[default]
+region = us-east-1
+
+[profile ci]
+role_arn = arn:aws:iam::123456789012:role/cache
+web_identity_token_file = /var/run/secrets/token
+
+[profile cross]
+role_arn = arn:aws:iam::210987654321:role/cache
+source_profile = default
+external_id = external
+`), 0600)
+
+			os.Setenv("AWS_CONFIG_FILE", config)
+			defer os.Unsetenv("AWS_CONFIG_FILE")
+
+			sts := &stsClient{endpoint: server.URL}
+
+			p, err := profileProvider(sts, "ci")
+			g.Assert(err == nil).IsTrue("failed to read profile")
+			g.Assert(p.(*webIdentity).tokenFile).Equal("/var/run/secrets/token")
+
+			p, err = profileProvider(sts, "cross")
+			g.Assert(err == nil).IsTrue("failed to read profile")
+			g.Assert(p.(*assumeRole).externalID).Equal("external")
+
+			p, err = profileProvider(sts, "default")
+			g.Assert(err == nil).IsTrue("failed to read profile")
+			g.Assert(p == nil).IsTrue("expected no role")
+		})
+
+		g.It("Should read the credentials of ECS containers", func() {
+			ecs := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if r.URL.Path != "/v2/credentials/task" || r.Header.Get("Authorization") != "auth" {
+					w.WriteHeader(http.StatusNotFound)
+					return
+				}
+
+				fmt.Fprintf(w, `{"AccessKeyId":"ASIAECS","SecretAccessKey":"secret","Token":"token","Expiration":"%s"}`, time.Now().Add(time.Hour).UTC().Format(time.RFC3339))
+			}))
+			defer ecs.Close()
+
+			os.Setenv("AWS_CONTAINER_CREDENTIALS_RELATIVE_URI", "/v2/credentials/task")
+			os.Setenv("AWS_CONTAINER_AUTHORIZATION_TOKEN", "auth")
+			defer os.Unsetenv("AWS_CONTAINER_CREDENTIALS_RELATIVE_URI")
+			defer os.Unsetenv("AWS_CONTAINER_AUTHORIZATION_TOKEN")
+
+			c := newContainerCredentials()
+			c.endpoint = ecs.URL
+
+			v, err := c.Retrieve()
+			g.Assert(err == nil).IsTrue("failed to retrieve")
+			g.Assert(v.AccessKeyID).Equal("ASIAECS")
+			g.Assert(v.SessionToken).Equal("token")
+			g.Assert(c.IsExpired()).IsFalse()
+		})
+
+		g.It("Should reject EcsContainer profiles outside of ECS", func() {
+			config := filepath.Join(dir, "config")
+			ioutil.WriteFile(config, []byte(`[profile ecs]
+role_arn = arn:aws:iam::123456789012:role/cache
+credential_source = EcsContainer
+`), 0600)
+
+			os.Setenv("AWS_CONFIG_FILE", config)
+			defer os.Unsetenv("AWS_CONFIG_FILE")
+
+			_, err := profileProvider(&stsClient{endpoint: server.URL}, "ecs")
+			g.Assert(err != nil).IsTrue("expected an error")
+
+			os.Setenv("AWS_CONTAINER_CREDENTIALS_RELATIVE_URI", "/v2/credentials/task")
+			defer os.Unsetenv("AWS_CONTAINER_CREDENTIALS_RELATIVE_URI")
+
+			p, err := profileProvider(&stsClient{endpoint: server.URL}, "ecs")
+			g.Assert(err == nil).IsTrue("failed to read profile")
+			g.Assert(p.(*assumeRole).roleARN).Equal("arn:aws:iam::123456789012:role/cache")
+		})
+
+		g.It("Should fall back to anonymous requests", func() {
+			c := &chain{providers: []credentials.Provider{&webIdentity{tokenFile: "missing"}}}
+
+			v, err := c.Retrieve()
+			g.Assert(err == nil).IsTrue("failed to retrieve")
+			g.Assert(v.SignerType).Equal(credentials.SignatureAnonymous)
+			g.Assert(c.IsExpired()).IsFalse()
+		})
+	})
+}
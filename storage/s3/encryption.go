@@ -0,0 +1,108 @@
+package s3
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/minio/minio-go/pkg/encrypt"
+)
+
+// Server side encryption types of the SSE option.
+const (
+	SSES3  = "AES256"
+	SSEKMS = "aws:kms"
+)
+
+// newEncryption returns the server side encryption of the options, nil
+// without encryption.
+func newEncryption(opts *Options) (encrypt.ServerSide, error) {
+	if len(opts.SSECustomerKey) != 0 {
+		if len(opts.SSE) != 0 || len(opts.SSEKMSKeyID) != 0 {
+			return nil, errors.New("Must use either sse or sse_c_key")
+		}
+
+		key, err := base64.StdEncoding.DecodeString(opts.SSECustomerKey)
+		if err != nil {
+			return nil, errors.New("Invalid sse_c_key. Needs to be a base64 encoded 256 bit key")
+		}
+
+		return encrypt.NewSSEC(key)
+	}
+
+	sse := opts.SSE
+	if len(sse) == 0 && len(opts.SSEKMSKeyID) != 0 {
+		sse = SSEKMS
+	}
+
+	switch sse {
+	case "":
+		return nil, nil
+	case SSES3:
+		return encrypt.NewSSE(), nil
+	case SSEKMS:
+		var context map[string]string
+
+		if len(opts.SSEKMSContext) != 0 {
+			if err := json.Unmarshal([]byte(opts.SSEKMSContext), &context); err != nil {
+				return nil, fmt.Errorf("Invalid sse_kms_context. Needs to be a JSON object: %s", err)
+			}
+		}
+
+		if len(opts.SSEKMSKeyID) == 0 {
+			return newDefaultKMS(context)
+		}
+
+		if context == nil {
+			return encrypt.NewSSEKMS(opts.SSEKMSKeyID, nil)
+		}
+
+		return encrypt.NewSSEKMS(opts.SSEKMSKeyID, context)
+	default:
+		return nil, fmt.Errorf("Invalid sse %s. Needs to be %s or %s", sse, SSES3, SSEKMS)
+	}
+}
+
+// defaultKMS encrypts with the AWS managed KMS key of the bucket. Unlike
+// encrypt.NewSSEKMS it leaves out the key id header, which S3 rejects when it
+// is empty.
+type defaultKMS struct {
+	context []byte
+}
+
+func newDefaultKMS(context map[string]string) (encrypt.ServerSide, error) {
+	if context == nil {
+		return defaultKMS{}, nil
+	}
+
+	serialized, err := json.Marshal(context)
+	if err != nil {
+		return nil, err
+	}
+
+	return defaultKMS{context: serialized}, nil
+}
+
+func (s defaultKMS) Type() encrypt.Type {
+	return encrypt.KMS
+}
+
+func (s defaultKMS) Marshal(h http.Header) {
+	h.Set("X-Amz-Server-Side-Encryption", SSEKMS)
+
+	if s.context != nil {
+		h.Set("X-Amz-Server-Side-Encryption-Context", base64.StdEncoding.EncodeToString(s.context))
+	}
+}
+
+// customerKey returns the SSE-C key objects are read with, other server side
+// encryption is transparent to reads.
+func (s *s3Storage) customerKey() encrypt.ServerSide {
+	if s.sse != nil && s.sse.Type() == encrypt.SSEC {
+		return s.sse
+	}
+
+	return nil
+}
@@ -0,0 +1,96 @@
+package s3
+
+import (
+	"fmt"
+	"net/url"
+
+	"github.com/yingce/drone-oss-cache/lib/cache/storage"
+)
+
+func init() {
+	storage.Register(storage.Provider{
+		Name:  "s3",
+		Usage: "Amazon S3 and compatible servers, e.g. s3://bucket/prefix",
+		Options: []storage.Option{
+			{Name: "endpoint", Usage: "s3 server, defaults to AWS", EnvVars: []string{"PLUGIN_S3_SERVER"}, Shared: "server"},
+			{Name: "accelerated_endpoint", Usage: "s3 accelerated endpoint", Shared: "accelerated-endpoint"},
+			{Name: "access_key", Usage: "s3 access key", Shared: "access-key"},
+			{Name: "secret_key", Usage: "s3 secret key", Shared: "secret-key"},
+			{Name: "session_token", Usage: "s3 session token", Shared: "session-token"},
+			{Name: "region", Usage: "s3 region", Shared: "region"},
+			{Name: "profile", Usage: "profile of the shared credentials and config files", EnvVars: []string{"AWS_PROFILE"}},
+			{Name: "role_arn", Usage: "role assumed with the credentials found, e.g. of another account"},
+			{Name: "external_id", Usage: "external id of the assumed role"},
+			{Name: "session_name", Usage: "session name of the assumed role, defaults to drone-cache"},
+			{Name: "sts_endpoint", Usage: "sts server roles are assumed with, defaults to the global endpoint"},
+			{Name: "sse", Usage: "server side encryption of uploads: AES256 or aws:kms"},
+			{Name: "sse_kms_key_id", Usage: "kms key of aws:kms encryption, defaults to the aws managed key"},
+			{Name: "sse_kms_context", Usage: "kms encryption context as a JSON object"},
+			{Name: "sse_c_key", Usage: "base64 encoded 256 bit customer key of SSE-C, needed to restore as well"},
+			{Name: "storage_class", Usage: "storage class of uploads, e.g. INTELLIGENT_TIERING"},
+			{Name: "tags", Usage: "tags of uploads, e.g. team=ci", Type: storage.Strings},
+			{Name: "bucket_lookup", Usage: "addressing of buckets: path, dns (virtual-hosted) or auto", Shared: "bucket_lookup"},
+			{Name: "ca_cert", Usage: "pem encoded ca trusted in addition to the system cas", Shared: "ca_cert"},
+			{Name: "ca_cert_path", Usage: "file of a pem encoded ca trusted in addition to the system cas", Shared: "ca_cert_path"},
+			{Name: "insecure_skip_verify", Usage: "accept any server certificate, only meant for labs", Type: storage.Bool, Shared: "insecure_skip_verify"},
+			{Name: "create_bucket", Usage: "create the bucket if it does not exist", Type: storage.Bool, Shared: "create_bucket"},
+			{Name: "bucket_acl", Usage: "acl of created buckets, defaults to private", Shared: "bucket_acl"},
+		},
+		New: FromValues,
+	})
+}
+
+// ParseEndpoint splits a server URL into the host and whether TLS is used.
+// An empty server is AWS.
+func ParseEndpoint(server string) (string, bool, error) {
+	if len(server) == 0 {
+		return "s3.amazonaws.com", true, nil
+	}
+
+	u, err := url.Parse(server)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || len(u.Host) == 0 {
+		return "", false, fmt.Errorf("Invalid server %s. Needs to be a HTTP URI", server)
+	}
+
+	return u.Host, u.Scheme == "https", nil
+}
+
+// FromValues creates the storage from the options of the provider.
+func FromValues(opts storage.Values) (storage.Storage, error) {
+	endpoint, useSSL, err := ParseEndpoint(opts.String("endpoint"))
+	if err != nil {
+		return nil, err
+	}
+
+	tags, err := parseTags(opts.Strings("tags"))
+	if err != nil {
+		return nil, err
+	}
+
+	return New(&Options{
+		Endpoint:            endpoint,
+		AcceleratedEndpoint: opts.String("accelerated_endpoint"),
+		Access:              opts.String("access_key"),
+		Secret:              opts.String("secret_key"),
+		Token:               opts.String("session_token"),
+		Region:              opts.String("region"),
+		UseSSL:              useSSL,
+		Profile:             opts.String("profile"),
+		RoleARN:             opts.String("role_arn"),
+		ExternalID:          opts.String("external_id"),
+		SessionName:         opts.String("session_name"),
+		STSEndpoint:         opts.String("sts_endpoint"),
+		CreateBucket:        opts.Bool("create_bucket"),
+		BucketACL:           opts.String("bucket_acl"),
+		SSE:                 opts.String("sse"),
+		SSEKMSKeyID:         opts.String("sse_kms_key_id"),
+		SSEKMSContext:       opts.String("sse_kms_context"),
+		SSECustomerKey:      opts.String("sse_c_key"),
+		StorageClass:        opts.String("storage_class"),
+		Tags:                tags,
+		BucketLookup:        opts.String("bucket_lookup"),
+		CACert:              opts.String("ca_cert"),
+		CACertPath:          opts.String("ca_cert_path"),
+		InsecureSkipVerify:  opts.Bool("insecure_skip_verify"),
+	})
+}
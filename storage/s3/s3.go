@@ -1,15 +1,19 @@
 package s3
 
 import (
+	"errors"
 	"fmt"
 	"io"
+	"net/http"
 	"strings"
 
 	"github.com/dustin/go-humanize"
 	"github.com/minio/minio-go"
 	"github.com/minio/minio-go/pkg/credentials"
+	"github.com/minio/minio-go/pkg/encrypt"
 	log "github.com/sirupsen/logrus"
 	"github.com/yingce/drone-oss-cache/lib/cache/storage"
+	"github.com/yingce/drone-oss-cache/lib/cache/storage/transport"
 )
 
 // Options contains configuration for the S3 connection.
@@ -32,40 +36,131 @@ type Options struct {
 	Region string
 
 	UseSSL bool
+
+	// Profile of the shared credentials and config files, defaults to
+	// AWS_PROFILE
+	Profile string
+
+	// RoleARN is assumed with the credentials found, ExternalID and
+	// SessionName are passed to sts:AssumeRole
+	RoleARN     string
+	ExternalID  string
+	SessionName string
+
+	// STSEndpoint is the STS server roles are assumed with, signed in
+	// Region, defaults to the global endpoint
+	STSEndpoint string
+
+	// CreateBucket creates a missing bucket in Region on Put instead of
+	// failing
+	CreateBucket bool
+	// BucketACL is the canned ACL of created buckets, only private is
+	// supported
+	BucketACL string
+
+	// SSE is the server side encryption of uploads, AES256 (SSE-S3) or
+	// aws:kms (SSE-KMS) with SSEKMSKeyID and the JSON SSEKMSContext
+	SSE           string
+	SSEKMSKeyID   string
+	SSEKMSContext string
+
+	// SSECustomerKey is the base64 encoded key of SSE-C, needed to read the
+	// objects as well
+	SSECustomerKey string
+
+	// StorageClass of uploads, e.g. INTELLIGENT_TIERING
+	StorageClass string
+
+	// Tags are set on uploads
+	Tags map[string]string
+
+	// BucketLookup is the addressing of buckets: path, dns (virtual-hosted)
+	// or auto, virtual-hosted for AWS and path-style for other servers
+	BucketLookup string
+
+	// CACert and CACertPath are PEM encoded CAs trusted in addition to the
+	// system CAs, InsecureSkipVerify accepts any server certificate
+	CACert             string
+	CACertPath         string
+	InsecureSkipVerify bool
 }
 
 type s3Storage struct {
-	client *minio.Client
-	opts   *Options
+	client     *minio.Client
+	httpClient *http.Client
+	creds      *credentials.Credentials
+	opts       *Options
+	lookup     minio.BucketLookupType
+	sse        encrypt.ServerSide
+	tags       map[string]string
 }
 
 // New method creates an implementation of Storage with S3 as the backend.
 func New(opts *Options) (storage.Storage, error) {
-	var creds *credentials.Credentials
-	if len(opts.Access) != 0 && len(opts.Secret) != 0 {
-		creds = credentials.NewStaticV4(opts.Access, opts.Secret, opts.Token)
-	} else {
-		creds = credentials.NewIAM("")
+	if opts.BucketACL != "" && opts.BucketACL != "private" {
+		return nil, fmt.Errorf("Bucket ACL %s is not supported, S3 buckets are created private", opts.BucketACL)
+	}
 
-		// See if the IAM role can be retrieved
-		_, err := creds.Get()
-		if err != nil {
-			return nil, err
-		}
+	if len(opts.Tags) > 10 {
+		return nil, errors.New("Invalid tags. S3 objects have at most 10 tags")
+	}
+
+	sse, err := newEncryption(opts)
+	if err != nil {
+		return nil, err
+	}
+
+	lookup, err := bucketLookup(opts.BucketLookup)
+	if err != nil {
+		return nil, err
+	}
+
+	tr, err := transport.New(&transport.Options{
+		CACert:             opts.CACert,
+		CACertPath:         opts.CACertPath,
+		InsecureSkipVerify: opts.InsecureSkipVerify,
+	})
+	if err != nil {
+		return nil, err
 	}
-	client, err := minio.NewWithCredentials(opts.Endpoint, creds, opts.UseSSL, "")
 
+	// Objects are stored as is, don't decode gzip encoded archives
+	tr.DisableCompression = true
+
+	creds, err := newCredentials(opts, tr)
 	if err != nil {
 		return nil, err
 	}
 
+	// See if the credentials can be retrieved
+	if _, err := creds.Get(); err != nil {
+		return nil, err
+	}
+
+	client, err := minio.NewWithOptions(opts.Endpoint, &minio.Options{
+		Creds:        creds,
+		Secure:       opts.UseSSL,
+		BucketLookup: lookup,
+	})
+
+	if err != nil {
+		return nil, err
+	}
+
+	client.SetCustomTransport(tr)
+
 	if opts.AcceleratedEndpoint != "" {
 		client.SetS3TransferAccelerate(opts.AcceleratedEndpoint)
 	}
 
 	return &s3Storage{
-		client: client,
-		opts:   opts,
+		client:     client,
+		httpClient: &http.Client{Transport: tr},
+		creds:      creds,
+		opts:       opts,
+		lookup:     lookup,
+		sse:        sse,
+		tags:       opts.Tags,
 	}, nil
 }
 
@@ -80,13 +175,18 @@ func (s *s3Storage) Get(p string, dst io.Writer) error {
 
 	exists, err := s.client.BucketExists(bucket)
 
-	if !exists {
+	if err != nil {
 		return err
 	}
+	if !exists {
+		return fmt.Errorf("%w: bucket %s", storage.ErrNotFound, bucket)
+	}
 
-	object, err := s.client.GetObject(bucket, key, minio.GetObjectOptions{})
+	object, err := s.client.GetObject(bucket, key, minio.GetObjectOptions{
+		ServerSideEncryption: s.customerKey(),
+	})
 	if err != nil {
-		return err
+		return notFound(err)
 	}
 
 	log.Infof("Copying object from the server")
@@ -94,7 +194,7 @@ func (s *s3Storage) Get(p string, dst io.Writer) error {
 	numBytes, err := io.Copy(dst, object)
 
 	if err != nil {
-		return err
+		return notFound(err)
 	}
 
 	log.Infof("Downloaded %s from server", humanize.Bytes(uint64(numBytes)))
@@ -117,22 +217,36 @@ func (s *s3Storage) Put(p string, src io.Reader) error {
 	}
 
 	if !exists {
+		if !s.opts.CreateBucket {
+			return fmt.Errorf("%w: bucket %s, enable create_bucket to create it", storage.ErrNotFound, bucket)
+		}
+
 		if err = s.client.MakeBucket(bucket, s.opts.Region); err != nil {
 			return err
 		}
-		log.Infof("Bucket %s created", bucket)
+		log.Infof("Bucket %s created in %s", bucket, s.opts.Region)
 	} else {
 		log.Infof("Bucket %s already exists", bucket)
 	}
 
 	log.Infof("Putting file in %s at %s", bucket, key)
 
-	numBytes, err := s.client.PutObject(bucket, key, src, -1, minio.PutObjectOptions{ContentType: "application/tar"})
+	numBytes, err := s.client.PutObject(bucket, key, src, -1, minio.PutObjectOptions{
+		ContentType:          "application/tar",
+		ServerSideEncryption: s.sse,
+		StorageClass:         s.opts.StorageClass,
+	})
 
 	if err != nil {
 		return err
 	}
 
+	if len(s.tags) > 0 {
+		if err := s.putTags(bucket, key); err != nil {
+			return err
+		}
+	}
+
 	log.Infof("Uploaded %s to server", humanize.Bytes(uint64(numBytes)))
 
 	return nil
@@ -150,10 +264,10 @@ func (s *s3Storage) List(p string) ([]storage.FileEntry, error) {
 	exists, err := s.client.BucketExists(bucket)
 
 	if err != nil {
-		return nil, fmt.Errorf("%s does not exist: %s", p, err)
+		return nil, err
 	}
 	if !exists {
-		return nil, fmt.Errorf("%s does not exist", p)
+		return nil, fmt.Errorf("%w: %s", storage.ErrNotFound, p)
 	}
 
 	// Create a done channel to control 'ListObjectsV2' go routine.
@@ -187,17 +301,24 @@ func (s *s3Storage) List(p string) ([]storage.FileEntry, error) {
 func (s *s3Storage) Exists(p string) (bool, error) {
 	bucket, key := splitBucket(p)
 
+	if len(bucket) == 0 || len(key) == 0 {
+		return false, fmt.Errorf("Invalid path %s", p)
+	}
+
 	exists, err := s.client.BucketExists(bucket)
 
 	if err != nil {
-		return false, fmt.Errorf("%s does not exist: %s", p, err)
+		return false, err
 	}
 	if !exists {
-		return false, fmt.Errorf("%s does not exist", p)
+		return false, nil
 	}
 
-	_, err = s.client.StatObject(bucket, key, minio.StatObjectOptions{})
+	_, err = s.client.StatObject(bucket, key, s.statOptions())
 	if err != nil {
+		if err = notFound(err); errors.Is(err, storage.ErrNotFound) {
+			return false, nil
+		}
 		return false, err
 	}
 	return true, nil
@@ -215,13 +336,103 @@ func (s *s3Storage) Delete(p string) error {
 	exists, err := s.client.BucketExists(bucket)
 
 	if err != nil {
-		return fmt.Errorf("%s does not exist: %s", p, err)
+		return err
 	}
 	if !exists {
-		return fmt.Errorf("%s does not exist", p)
+		return fmt.Errorf("%w: %s", storage.ErrNotFound, p)
 	}
 
 	err = s.client.RemoveObject(bucket, key)
+	return notFound(err)
+}
+
+// maxCopySize is the largest object a single CopyObject request can copy,
+// larger objects are copied in parts.
+const maxCopySize = 5 * 1024 * 1024 * 1024
+
+func (s *s3Storage) Copy(src, dst string) error {
+	srcBucket, srcKey := splitBucket(src)
+	dstBucket, dstKey := splitBucket(dst)
+
+	if len(srcBucket) == 0 || len(srcKey) == 0 {
+		return fmt.Errorf("Invalid path %s", src)
+	}
+	if len(dstBucket) == 0 || len(dstKey) == 0 {
+		return fmt.Errorf("Invalid path %s", dst)
+	}
+
+	log.Infof("Copying object in bucket %s at %s to bucket %s at %s", srcBucket, srcKey, dstBucket, dstKey)
+
+	info, err := s.client.StatObject(srcBucket, srcKey, s.statOptions())
+	if err != nil {
+		return notFound(err)
+	}
+
+	// Copies are stored, encrypted and tagged like uploads, replacing what
+	// the source had
+	meta := map[string]string{"Content-Type": "application/tar"}
+	if s.opts.StorageClass != "" {
+		meta["X-Amz-Storage-Class"] = s.opts.StorageClass
+	}
+
+	source := minio.NewSourceInfo(srcBucket, srcKey, s.customerKey())
+	destination, err := minio.NewDestinationInfo(dstBucket, dstKey, s.sse, meta)
+	if err != nil {
+		return err
+	}
+
+	if info.Size > maxCopySize {
+		log.Infof("Copying %s in parts", humanize.Bytes(uint64(info.Size)))
+		err = s.client.ComposeObject(destination, []minio.SourceInfo{source})
+	} else {
+		err = s.client.CopyObject(destination, source)
+	}
+
+	if err != nil {
+		return notFound(err)
+	}
+
+	// Multipart copies lose the tags of the source
+	if len(s.tags) > 0 {
+		if err := s.putTags(dstBucket, dstKey); err != nil {
+			return err
+		}
+	}
+
+	log.Infof("Copied %s on server", humanize.Bytes(uint64(info.Size)))
+
+	return nil
+}
+
+func (s *s3Storage) statOptions() minio.StatObjectOptions {
+	return minio.StatObjectOptions{
+		GetObjectOptions: minio.GetObjectOptions{ServerSideEncryption: s.customerKey()},
+	}
+}
+
+// bucketLookup returns the addressing of buckets of the bucket_lookup option.
+func bucketLookup(lookup string) (minio.BucketLookupType, error) {
+	switch lookup {
+	case "", "auto":
+		return minio.BucketLookupAuto, nil
+	case "path":
+		return minio.BucketLookupPath, nil
+	case "dns":
+		return minio.BucketLookupDNS, nil
+	default:
+		return minio.BucketLookupAuto, fmt.Errorf("Invalid bucket lookup %s. Needs to be auto, path or dns", lookup)
+	}
+}
+
+// notFound maps the not found responses of S3 to storage.ErrNotFound.
+func notFound(err error) error {
+	resp := minio.ToErrorResponse(err)
+
+	switch resp.Code {
+	case "NoSuchKey", "NoSuchBucket", "NotFound":
+		return fmt.Errorf("%w: %s", storage.ErrNotFound, err)
+	}
+
 	return err
 }
 
@@ -1 +1,272 @@
 package s3
+
+import (
+	"encoding/base64"
+	"encoding/xml"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/franela/goblin"
+	"github.com/minio/minio-go"
+	"github.com/minio/minio-go/pkg/credentials"
+	"github.com/minio/minio-go/pkg/encrypt"
+	"github.com/yingce/drone-oss-cache/lib/cache/storage"
+)
+
+func TestS3(t *testing.T) {
+	g := goblin.Goblin(t)
+
+	g.Describe("s3 encryption", func() {
+		g.It("Should pick the server side encryption", func() {
+			sse, err := newEncryption(&Options{})
+			g.Assert(err == nil).IsTrue("failed without encryption")
+			g.Assert(sse == nil).IsTrue("expected no encryption")
+
+			sse, err = newEncryption(&Options{SSE: SSES3})
+			g.Assert(err == nil).IsTrue("failed with SSE-S3")
+			g.Assert(sse.Type()).Equal(encrypt.S3)
+
+			sse, err = newEncryption(&Options{SSEKMSKeyID: "alias/cache", SSEKMSContext: `{"team":"ci"}`})
+			g.Assert(err == nil).IsTrue("failed with SSE-KMS")
+			g.Assert(sse.Type()).Equal(encrypt.KMS)
+
+			h := make(http.Header)
+			sse.Marshal(h)
+			g.Assert(h.Get("X-Amz-Server-Side-Encryption-Aws-Kms-Key-Id")).Equal("alias/cache")
+
+			sse, err = newEncryption(&Options{SSE: SSEKMS})
+			g.Assert(err == nil).IsTrue("failed with the default KMS key")
+			g.Assert(sse.Type()).Equal(encrypt.KMS)
+
+			h = make(http.Header)
+			sse.Marshal(h)
+			g.Assert(h.Get("X-Amz-Server-Side-Encryption")).Equal(SSEKMS)
+			_, ok := h["X-Amz-Server-Side-Encryption-Aws-Kms-Key-Id"]
+			g.Assert(ok).IsFalse()
+
+			key := base64.StdEncoding.EncodeToString([]byte(strings.Repeat("k", 32)))
+			sse, err = newEncryption(&Options{SSECustomerKey: key})
+			g.Assert(err == nil).IsTrue("failed with SSE-C")
+			g.Assert(sse.Type()).Equal(encrypt.SSEC)
+		})
+
+		g.It("Should reject invalid encryption", func() {
+			_, err := newEncryption(&Options{SSE: "des"})
+			g.Assert(err != nil).IsTrue("expected an invalid sse error")
+
+			_, err = newEncryption(&Options{SSE: SSEKMS, SSEKMSContext: "team=ci"})
+			g.Assert(err != nil).IsTrue("expected an invalid context error")
+
+			_, err = newEncryption(&Options{SSECustomerKey: base64.StdEncoding.EncodeToString([]byte("short"))})
+			g.Assert(err != nil).IsTrue("expected an invalid key error")
+
+			key := base64.StdEncoding.EncodeToString([]byte(strings.Repeat("k", 32)))
+			_, err = newEncryption(&Options{SSE: SSES3, SSECustomerKey: key})
+			g.Assert(err != nil).IsTrue("expected a conflict error")
+		})
+
+		g.It("Should only read with customer keys", func() {
+			s := &s3Storage{sse: encrypt.NewSSE()}
+			g.Assert(s.customerKey() == nil).IsTrue("expected no key for SSE-S3")
+		})
+	})
+
+	g.Describe("s3 tagging", func() {
+		g.It("Should parse tags", func() {
+			tags, err := parseTags([]string{"team=ci", " cost = cache "})
+			g.Assert(err == nil).IsTrue("failed to parse tags")
+			g.Assert(tags).Equal(map[string]string{"team": "ci", "cost": "cache"})
+
+			_, err = parseTags([]string{"team"})
+			g.Assert(err != nil).IsTrue("expected an invalid tag error")
+		})
+
+		g.It("Should tag objects", func() {
+			var req *http.Request
+			var body []byte
+
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				req = r
+				body, _ = ioutil.ReadAll(r.Body)
+			}))
+			defer server.Close()
+
+			u, _ := url.Parse(server.URL)
+
+			s := &s3Storage{
+				httpClient: http.DefaultClient,
+				creds:      credentials.NewStaticV4("AKIDEXAMPLE", "secret", ""),
+				opts:       &Options{Endpoint: u.Host, Region: "eu-west-1"},
+				tags:       map[string]string{"team": "ci"},
+			}
+
+			err := s.putTags("bucket", "owner/repo/archive 1.tar")
+			g.Assert(err == nil).IsTrue("failed to tag")
+
+			g.Assert(req.Method).Equal(http.MethodPut)
+			g.Assert(req.URL.Path).Equal("/bucket/owner/repo/archive 1.tar")
+			g.Assert(req.URL.RawQuery).Equal("tagging=")
+			g.Assert(req.Header.Get("Content-Md5") != "").IsTrue("expected a checksum")
+			g.Assert(strings.Contains(req.Header.Get("Authorization"), "/eu-west-1/s3/aws4_request")).IsTrue(req.Header.Get("Authorization"))
+
+			var t tagging
+			xml.Unmarshal(body, &t)
+			g.Assert(t.Tags).Equal([]tag{{Key: "team", Value: "ci"}})
+		})
+
+		g.It("Should sign tags in the region of the bucket", func() {
+			var req *http.Request
+
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if r.URL.RawQuery == "location=" {
+					w.Write([]byte(`<LocationConstraint xmlns="http://s3.amazonaws.com/doc/2006-03-01/">eu-central-1</LocationConstraint>`))
+					return
+				}
+				req = r
+			}))
+			defer server.Close()
+
+			u, _ := url.Parse(server.URL)
+
+			s, err := New(&Options{Endpoint: u.Host, Access: "key", Secret: "secret", Tags: map[string]string{"team": "ci"}})
+			g.Assert(err == nil).IsTrue("failed to create storage")
+
+			err = s.(*s3Storage).putTags("bucket", "archive.tar")
+			g.Assert(err == nil).IsTrue("failed to tag")
+			g.Assert(strings.Contains(req.Header.Get("Authorization"), "/eu-central-1/s3/aws4_request")).IsTrue(req.Header.Get("Authorization"))
+		})
+	})
+
+	g.Describe("s3 bucket lookup", func() {
+		g.It("Should parse the bucket lookup", func() {
+			lookup, err := bucketLookup("")
+			g.Assert(err == nil).IsTrue("failed without lookup")
+			g.Assert(lookup).Equal(minio.BucketLookupAuto)
+
+			lookup, err = bucketLookup("path")
+			g.Assert(err == nil).IsTrue("failed with path lookup")
+			g.Assert(lookup).Equal(minio.BucketLookupPath)
+
+			_, err = bucketLookup("cname")
+			g.Assert(err != nil).IsTrue("expected an invalid lookup error")
+		})
+
+		g.It("Should address buckets like minio-go", func() {
+			aws := url.URL{Scheme: "https", Host: "s3.amazonaws.com"}
+			server := url.URL{Scheme: "https", Host: "minio.example.com"}
+
+			s := &s3Storage{lookup: minio.BucketLookupAuto}
+			g.Assert(s.virtualHost(aws, "bucket")).IsTrue("expected virtual-hosted aws")
+			g.Assert(s.virtualHost(server, "bucket")).IsFalse("expected path-style minio")
+
+			s.lookup, _ = bucketLookup("dns")
+			g.Assert(s.virtualHost(server, "bucket")).IsTrue("expected virtual-hosted minio")
+
+			s.lookup, _ = bucketLookup("path")
+			g.Assert(s.virtualHost(aws, "bucket")).IsFalse("expected path-style aws")
+		})
+	})
+
+	g.Describe("s3 errors", func() {
+		g.It("Should map not found responses", func() {
+			for _, tt := range []struct {
+				err      error
+				notFound bool
+			}{
+				{minio.ErrorResponse{Code: "NoSuchKey", StatusCode: http.StatusNotFound}, true},
+				{minio.ErrorResponse{Code: "NoSuchBucket", StatusCode: http.StatusNotFound}, true},
+				{minio.ErrorResponse{Code: "NotFound", StatusCode: http.StatusNotFound}, true},
+				{minio.ErrorResponse{Code: "AccessDenied", StatusCode: http.StatusForbidden}, false},
+				{minio.ErrorResponse{Code: "InternalError", StatusCode: http.StatusInternalServerError}, false},
+				{errors.New("connection refused"), false},
+			} {
+				err := notFound(tt.err)
+				g.Assert(errors.Is(err, storage.ErrNotFound)).Equal(tt.notFound)
+			}
+		})
+	})
+	g.Describe("s3 copies", func() {
+		var server *httptest.Server
+		var objects map[string]bool
+		var copied, tagged *http.Request
+
+		g.BeforeEach(func() {
+			objects = map[string]bool{"/bucket/feature/archive.tar": true}
+			copied = nil
+			tagged = nil
+
+			server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				switch {
+				case r.URL.RawQuery == "location=":
+					w.Write([]byte(`<LocationConstraint xmlns="http://s3.amazonaws.com/doc/2006-03-01/">us-east-1</LocationConstraint>`))
+				case r.Method == http.MethodHead && objects[r.URL.Path]:
+					w.Header().Set("Content-Length", "7")
+					w.Header().Set("Last-Modified", "Mon, 02 Jan 2006 15:04:05 GMT")
+					w.Header().Set("ETag", `"etag"`)
+				case r.Method == http.MethodHead:
+					w.WriteHeader(http.StatusNotFound)
+				case r.Method == http.MethodPut && r.URL.RawQuery == "tagging=":
+					tagged = r
+				case r.Method == http.MethodPut && r.Header.Get("X-Amz-Copy-Source") != "":
+					copied = r
+					w.Write([]byte(`<CopyObjectResult><LastModified>2006-01-02T15:04:05.000Z</LastModified><ETag>"etag"</ETag></CopyObjectResult>`))
+				default:
+					http.NotFound(w, r)
+				}
+			}))
+		})
+
+		g.AfterEach(func() {
+			server.Close()
+		})
+
+		open := func(opts *Options) storage.Storage {
+			u, _ := url.Parse(server.URL)
+
+			opts.Endpoint = u.Host
+			opts.Access = "key"
+			opts.Secret = "secret"
+
+			s, err := New(opts)
+			g.Assert(err == nil).IsTrue("failed to create storage")
+
+			return s
+		}
+
+		g.It("Should copy on the server", func() {
+			s := open(&Options{})
+
+			err := s.Copy("bucket/feature/archive.tar", "bucket/master/archive.tar")
+			g.Assert(err == nil).IsTrue("failed to copy")
+
+			g.Assert(copied.URL.Path).Equal("/bucket/master/archive.tar")
+			g.Assert(copied.Header.Get("X-Amz-Copy-Source")).Equal("bucket/feature/archive.tar")
+			g.Assert(copied.Header.Get("Content-Type")).Equal("application/tar")
+			g.Assert(tagged == nil).IsTrue("expected no tags")
+		})
+
+		g.It("Should keep the storage class and tags of uploads", func() {
+			s := open(&Options{StorageClass: "STANDARD_IA", Tags: map[string]string{"team": "ci"}})
+
+			err := s.Copy("bucket/feature/archive.tar", "bucket/master/archive.tar")
+			g.Assert(err == nil).IsTrue("failed to copy")
+
+			g.Assert(copied.Header.Get("X-Amz-Storage-Class")).Equal("STANDARD_IA")
+			g.Assert(copied.Header.Get("X-Amz-Metadata-Directive")).Equal("REPLACE")
+			g.Assert(tagged.URL.Path).Equal("/bucket/master/archive.tar")
+		})
+
+		g.It("Should report a missing source", func() {
+			s := open(&Options{})
+
+			err := s.Copy("bucket/missing/archive.tar", "bucket/master/archive.tar")
+			g.Assert(errors.Is(err, storage.ErrNotFound)).IsTrue("expected a not found error")
+			g.Assert(copied == nil).IsTrue("expected no copy")
+		})
+	})
+}
@@ -0,0 +1,132 @@
+package s3
+
+import (
+	"bytes"
+	"crypto/md5"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/minio/minio-go"
+	"github.com/minio/minio-go/pkg/s3signer"
+	"github.com/minio/minio-go/pkg/s3utils"
+)
+
+// parseTags parses key=value tags.
+func parseTags(tags []string) (map[string]string, error) {
+	parsed := make(map[string]string)
+
+	for _, t := range tags {
+		i := strings.Index(t, "=")
+		if i <= 0 {
+			return nil, fmt.Errorf("Invalid tag %s. Needs to be key=value", t)
+		}
+
+		parsed[strings.TrimSpace(t[:i])] = strings.TrimSpace(t[i+1:])
+	}
+
+	return parsed, nil
+}
+
+type tagging struct {
+	XMLName xml.Name `xml:"Tagging"`
+	Tags    []tag    `xml:"TagSet>Tag"`
+}
+
+type tag struct {
+	Key   string `xml:"Key"`
+	Value string `xml:"Value"`
+}
+
+// virtualHost returns whether the bucket is addressed by the host of the
+// endpoint, like minio-go does for the bucket lookup.
+func (s *s3Storage) virtualHost(endpoint url.URL, bucket string) bool {
+	switch s.lookup {
+	case minio.BucketLookupDNS:
+		return true
+	case minio.BucketLookupPath:
+		return false
+	default:
+		return s3utils.IsVirtualHostSupported(endpoint, bucket)
+	}
+}
+
+// putTags sets the tags of an object with PutObjectTagging, which minio-go
+// has no API for.
+func (s *s3Storage) putTags(bucket, key string) error {
+	var t tagging
+	for k, v := range s.tags {
+		t.Tags = append(t.Tags, tag{Key: k, Value: v})
+	}
+
+	body, err := xml.Marshal(t)
+	if err != nil {
+		return err
+	}
+
+	scheme := "http"
+	if s.opts.UseSSL {
+		scheme = "https"
+	}
+
+	u := url.URL{
+		Scheme:   scheme,
+		Host:     s.opts.Endpoint,
+		Path:     "/" + bucket + "/" + key,
+		RawPath:  "/" + bucket + "/" + s3utils.EncodePath(key),
+		RawQuery: "tagging=",
+	}
+
+	if s.virtualHost(u, bucket) {
+		u.Host = bucket + "." + u.Host
+		u.Path = "/" + key
+		u.RawPath = "/" + s3utils.EncodePath(key)
+	}
+
+	req, err := http.NewRequest(http.MethodPut, u.String(), bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+
+	sum := md5.Sum(body)
+	hash := sha256.Sum256(body)
+
+	req.Header.Set("Content-Type", "application/xml")
+	req.Header.Set("Content-Md5", base64.StdEncoding.EncodeToString(sum[:]))
+	req.Header.Set("X-Amz-Content-Sha256", hex.EncodeToString(hash[:]))
+
+	creds, err := s.creds.Get()
+	if err != nil {
+		return err
+	}
+
+	// Signatures need the region of the bucket, minio-go caches it
+	region := s.opts.Region
+	if len(region) == 0 {
+		if region, err = s.client.GetBucketLocation(bucket); err != nil {
+			return err
+		}
+	}
+
+	req = s3signer.SignV4(*req, creds.AccessKeyID, creds.SecretAccessKey, creds.SessionToken, region)
+
+	resp, err := s.httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		msg, _ := ioutil.ReadAll(io.LimitReader(resp.Body, 1024))
+		return fmt.Errorf("Failed to tag %s/%s: %s %s", bucket, key, resp.Status, strings.TrimSpace(string(msg)))
+	}
+
+	return nil
+}
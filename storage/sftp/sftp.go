@@ -0,0 +1,332 @@
+package sftp
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	pathutil "path"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/dustin/go-humanize"
+	"github.com/pkg/sftp"
+	log "github.com/sirupsen/logrus"
+	"github.com/yingce/drone-oss-cache/lib/cache/storage"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/knownhosts"
+)
+
+// Options contains configuration for the SFTP connection.
+type Options struct {
+	// Server is the host and port, the port defaults to 22
+	Server   string
+	Username string
+
+	// Key is the PEM encoded private key, Passphrase decrypts it
+	Key        string
+	Passphrase string
+
+	// Password logs in with a password instead of or besides the key
+	Password string
+
+	// KnownHosts is the known_hosts file the host key is verified against,
+	// defaults to ~/.ssh/known_hosts
+	KnownHosts string
+
+	// Root is the directory on the server the paths are relative to
+	Root string
+
+	Timeout time.Duration
+}
+
+type sftpStorage struct {
+	conn   *ssh.Client
+	client *sftp.Client
+	opts   *Options
+}
+
+// New method creates an implementation of Storage with SFTP as the backend.
+// The storage keeps its connection open until it is closed with
+// storage.Close.
+func New(opts *Options) (storage.Storage, error) {
+	if len(opts.Server) == 0 {
+		return nil, errors.New("No server specified")
+	}
+
+	if _, _, err := net.SplitHostPort(opts.Server); err != nil {
+		opts.Server = net.JoinHostPort(opts.Server, "22")
+	}
+
+	if len(opts.Key) == 0 && len(opts.Password) == 0 {
+		return nil, errors.New("No private key or password specified")
+	}
+
+	var auth []ssh.AuthMethod
+
+	if len(opts.Key) != 0 {
+		var signer ssh.Signer
+		var err error
+
+		if len(opts.Passphrase) != 0 {
+			signer, err = ssh.ParsePrivateKeyWithPassphrase([]byte(opts.Key), []byte(opts.Passphrase))
+		} else {
+			signer, err = ssh.ParsePrivateKey([]byte(opts.Key))
+		}
+
+		if err != nil {
+			return nil, fmt.Errorf("Invalid private key: %s", err)
+		}
+
+		auth = append(auth, ssh.PublicKeys(signer))
+	}
+
+	if len(opts.Password) != 0 {
+		auth = append(auth, ssh.Password(opts.Password))
+	}
+
+	if len(opts.KnownHosts) == 0 {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return nil, err
+		}
+
+		opts.KnownHosts = filepath.Join(home, ".ssh", "known_hosts")
+	}
+
+	hostKeyCallback, err := knownhosts.New(opts.KnownHosts)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to read known hosts: %s", err)
+	}
+
+	conn, err := ssh.Dial("tcp", opts.Server, &ssh.ClientConfig{
+		User:            opts.Username,
+		Auth:            auth,
+		HostKeyCallback: hostKeyCallback,
+		Timeout:         opts.Timeout,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	client, err := sftp.NewClient(conn)
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+
+	return &sftpStorage{
+		conn:   conn,
+		client: client,
+		opts:   opts,
+	}, nil
+}
+
+// Close ends the sftp session and the ssh connection beneath it.
+func (s *sftpStorage) Close() error {
+	err := s.client.Close()
+
+	if cerr := s.conn.Close(); err == nil {
+		err = cerr
+	}
+
+	return err
+}
+
+func (s *sftpStorage) Get(p string, dst io.Writer) error {
+	remote := s.remote(p)
+
+	log.Infof("Retrieving file at %s", remote)
+
+	f, err := s.client.Open(remote)
+	if err != nil {
+		return notFound(err)
+	}
+	defer f.Close()
+
+	log.Infof("Copying object from the server")
+
+	numBytes, err := io.Copy(dst, f)
+
+	if err != nil {
+		return err
+	}
+
+	log.Infof("Downloaded %s from server", humanize.Bytes(uint64(numBytes)))
+
+	return nil
+}
+
+func (s *sftpStorage) Put(p string, src io.Reader) error {
+	remote := s.remote(p)
+
+	log.Infof("Uploading to %s", remote)
+
+	numBytes, err := s.upload(remote, src)
+
+	if err != nil {
+		return err
+	}
+
+	log.Infof("Uploaded %s to server", humanize.Bytes(uint64(numBytes)))
+
+	return nil
+}
+
+func (s *sftpStorage) List(p string) ([]storage.FileEntry, error) {
+	remote := s.remote(p)
+
+	log.Infof("Retrieving files at %s", remote)
+
+	var files []storage.FileEntry
+	walker := s.client.Walk(remote)
+
+	for walker.Step() {
+		if err := walker.Err(); err != nil {
+			return nil, notFound(err)
+		}
+
+		fi := walker.Stat()
+		if fi.IsDir() {
+			continue
+		}
+
+		path := s.relative(walker.Path())
+
+		files = append(files, storage.FileEntry{
+			Path:         path,
+			Size:         fi.Size(),
+			LastModified: fi.ModTime(),
+		})
+		log.Debugf("Found file %s: Size=%d LastModified=%s", path, fi.Size(), fi.ModTime())
+	}
+
+	log.Infof("Found %d files at %s", len(files), remote)
+
+	return files, nil
+}
+
+func (s *sftpStorage) Exists(p string) (bool, error) {
+	_, err := s.client.Stat(s.remote(p))
+
+	if err != nil {
+		if os.IsNotExist(err) {
+			return false, nil
+		}
+		return false, err
+	}
+
+	return true, nil
+}
+
+func (s *sftpStorage) Delete(p string) error {
+	remote := s.remote(p)
+
+	log.Infof("Deleting %s", remote)
+
+	return notFound(s.client.Remove(remote))
+}
+
+// Copy streams the file through the client, SFTP has no server side copy.
+func (s *sftpStorage) Copy(src, dst string) error {
+	remoteSrc := s.remote(src)
+	remoteDst := s.remote(dst)
+
+	log.Infof("Copying %s to %s", remoteSrc, remoteDst)
+
+	f, err := s.client.Open(remoteSrc)
+	if err != nil {
+		return notFound(err)
+	}
+	defer f.Close()
+
+	numBytes, err := s.upload(remoteDst, f)
+
+	if err != nil {
+		return err
+	}
+
+	log.Infof("Copied %s on server", humanize.Bytes(uint64(numBytes)))
+
+	return nil
+}
+
+// upload writes to a temporary file next to remote and renames it into place
+// once complete, so readers never see a partial file.
+func (s *sftpStorage) upload(remote string, src io.Reader) (int64, error) {
+	if err := s.client.MkdirAll(pathutil.Dir(remote)); err != nil {
+		return 0, err
+	}
+
+	tmp := fmt.Sprintf("%s.%d.tmp", remote, time.Now().UnixNano())
+
+	f, err := s.client.Create(tmp)
+	if err != nil {
+		return 0, err
+	}
+
+	numBytes, err := io.Copy(f, src)
+
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+
+	if err == nil {
+		err = s.rename(tmp, remote)
+	}
+
+	if err != nil {
+		s.client.Remove(tmp)
+		return 0, err
+	}
+
+	return numBytes, nil
+}
+
+// rename replaces newname atomically when the server supports the
+// posix-rename extension, otherwise the old file is removed first.
+func (s *sftpStorage) rename(oldname, newname string) error {
+	if err := s.client.PosixRename(oldname, newname); err == nil {
+		return nil
+	}
+
+	if err := s.client.Remove(newname); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+
+	return s.client.Rename(oldname, newname)
+}
+
+func (s *sftpStorage) root() string {
+	if len(s.opts.Root) == 0 {
+		return "."
+	}
+
+	return pathutil.Clean(s.opts.Root)
+}
+
+// relative returns the path of a remote file relative to the root, like the
+// paths passed in.
+func (s *sftpStorage) relative(remote string) string {
+	root := s.root()
+	if root == "." {
+		return remote
+	}
+
+	return strings.TrimPrefix(strings.TrimPrefix(remote, root), "/")
+}
+
+func (s *sftpStorage) remote(p string) string {
+	return pathutil.Join(s.root(), pathutil.Clean("/"+p))
+}
+
+// notFound maps missing files to storage.ErrNotFound.
+func notFound(err error) error {
+	if os.IsNotExist(err) {
+		return fmt.Errorf("%w: %s", storage.ErrNotFound, err)
+	}
+
+	return err
+}
@@ -0,0 +1,234 @@
+package sftp
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"io/ioutil"
+	"net"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/franela/goblin"
+	"github.com/pkg/sftp"
+	"github.com/yingce/drone-oss-cache/lib/cache/storage"
+	"github.com/yingce/drone-oss-cache/lib/cache/storage/storagetest"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/knownhosts"
+)
+
+func TestSFTP(t *testing.T) {
+	g := goblin.Goblin(t)
+
+	g.Describe("sftp storage", func() {
+		var dir string
+		var addr string
+		var listener net.Listener
+		var s storage.Storage
+
+		g.BeforeEach(func() {
+			var err error
+			dir, err = ioutil.TempDir("", "sftp")
+			if err != nil {
+				g.Fail(err)
+			}
+
+			clientKey, clientPEM := newKey()
+			hostKey, _ := newKey()
+
+			listener, err = serveSFTP(hostKey, clientKey.PublicKey())
+			if err != nil {
+				g.Fail(err)
+			}
+			addr = listener.Addr().String()
+
+			knownHosts := filepath.Join(dir, "known_hosts")
+			line := knownhosts.Line([]string{addr}, hostKey.PublicKey())
+			ioutil.WriteFile(knownHosts, []byte(line+"\n"), 0600)
+
+			s, err = New(&Options{
+				Server:     addr,
+				Username:   "drone",
+				Key:        clientPEM,
+				KnownHosts: knownHosts,
+				Root:       filepath.Join(dir, "root"),
+			})
+			if err != nil {
+				g.Fail(err)
+			}
+		})
+
+		g.AfterEach(func() {
+			storage.Close(s)
+			listener.Close()
+			os.RemoveAll(dir)
+		})
+
+		g.It("Should upload and download", func() {
+			err := s.Put("bucket/owner/repo/archive.tar", strings.NewReader("archive"))
+			g.Assert(err == nil).IsTrue("failed to put")
+
+			var buf bytes.Buffer
+			err = s.Get("bucket/owner/repo/archive.tar", &buf)
+			g.Assert(err == nil).IsTrue("failed to get")
+			g.Assert(buf.String()).Equal("archive")
+
+			// Nothing but the file is left behind
+			files, _ := ioutil.ReadDir(filepath.Join(dir, "root", "bucket", "owner", "repo"))
+			g.Assert(len(files)).Equal(1)
+		})
+
+		g.It("Should list with modification times", func() {
+			s.Put("bucket/owner/repo/a.tar", strings.NewReader("a"))
+			s.Put("bucket/owner/repo/b.tar", strings.NewReader("bb"))
+
+			old := time.Now().Add(-48 * time.Hour)
+			os.Chtimes(filepath.Join(dir, "root", "bucket", "owner", "repo", "a.tar"), old, old)
+
+			files, err := s.List("bucket/owner/repo")
+			g.Assert(err == nil).IsTrue("failed to list")
+			g.Assert(len(files)).Equal(2)
+			g.Assert(files[0].Path).Equal("bucket/owner/repo/a.tar")
+			g.Assert(files[0].LastModified.Unix()).Equal(old.Unix())
+			g.Assert(files[1].Size).Equal(int64(2))
+		})
+
+		g.It("Should reject an unknown host key", func() {
+			_, clientPEM := newKey()
+			other, _ := newKey()
+
+			knownHosts := filepath.Join(dir, "other_hosts")
+			line := knownhosts.Line([]string{addr}, other.PublicKey())
+			ioutil.WriteFile(knownHosts, []byte(line+"\n"), 0600)
+
+			_, err := New(&Options{Server: addr, Username: "drone", Key: clientPEM, KnownHosts: knownHosts})
+			g.Assert(err != nil).IsTrue("expected a host key error")
+		})
+
+		g.It("Should log in with the password of the url", func() {
+			u, _ := url.Parse("sftp://drone:secret@" + addr + "/srv/cache")
+
+			opts, location, err := FromURL(u)
+			g.Assert(err == nil).IsTrue("failed to parse url")
+			g.Assert(location).Equal("srv/cache")
+			g.Assert(opts.Get("password")).Equal("secret")
+
+			p, err := New(&Options{
+				Server:     addr,
+				Username:   opts.Get("username"),
+				Password:   opts.Get("password"),
+				KnownHosts: filepath.Join(dir, "known_hosts"),
+				Root:       filepath.Join(dir, "root"),
+			})
+			g.Assert(err == nil).IsTrue("failed to log in with the password")
+			defer storage.Close(p)
+
+			err = p.Put("bucket/owner/repo/archive.tar", strings.NewReader("archive"))
+			g.Assert(err == nil).IsTrue("failed to put")
+		})
+
+		g.It("Should reject a wrong password", func() {
+			_, err := New(&Options{
+				Server:     addr,
+				Username:   "drone",
+				Password:   "wrong",
+				KnownHosts: filepath.Join(dir, "known_hosts"),
+			})
+			g.Assert(err != nil).IsTrue("expected an authentication error")
+		})
+
+		g.It("Should close the connection", func() {
+			err := storage.Close(s)
+			g.Assert(err == nil).IsTrue("failed to close")
+
+			_, err = s.Exists("bucket/owner/repo/archive.tar")
+			g.Assert(err != nil).IsTrue("expected the connection to be closed")
+		})
+
+		storagetest.Run(g, func() storage.Storage { return s })
+	})
+}
+
+// newKey returns a signer and its private key in PEM.
+func newKey() (ssh.Signer, string) {
+	key, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	signer, _ := ssh.NewSignerFromKey(key)
+
+	der, _ := x509.MarshalECPrivateKey(key)
+	block := &pem.Block{Type: "EC PRIVATE KEY", Bytes: der}
+	return signer, string(pem.EncodeToMemory(block))
+}
+
+// serveSFTP runs an SSH server with the sftp subsystem that accepts the
+// client key and the password secret.
+func serveSFTP(hostKey ssh.Signer, clientKey ssh.PublicKey) (net.Listener, error) {
+	config := &ssh.ServerConfig{
+		PasswordCallback: func(conn ssh.ConnMetadata, password []byte) (*ssh.Permissions, error) {
+			if string(password) == "secret" {
+				return nil, nil
+			}
+			return nil, errors.New("wrong password")
+		},
+		PublicKeyCallback: func(conn ssh.ConnMetadata, key ssh.PublicKey) (*ssh.Permissions, error) {
+			if bytes.Equal(key.Marshal(), clientKey.Marshal()) {
+				return nil, nil
+			}
+			return nil, errors.New("unknown key")
+		},
+	}
+	config.AddHostKey(hostKey)
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return nil, err
+	}
+
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+
+			go serveConn(conn, config)
+		}
+	}()
+
+	return listener, nil
+}
+
+func serveConn(conn net.Conn, config *ssh.ServerConfig) {
+	_, chans, reqs, err := ssh.NewServerConn(conn, config)
+	if err != nil {
+		return
+	}
+	go ssh.DiscardRequests(reqs)
+
+	for newChannel := range chans {
+		channel, requests, err := newChannel.Accept()
+		if err != nil {
+			return
+		}
+
+		go func() {
+			for req := range requests {
+				ok := req.Type == "subsystem" && string(req.Payload[4:]) == "sftp"
+				req.Reply(ok, nil)
+
+				if ok {
+					server, _ := sftp.NewServer(channel)
+					server.Serve()
+					channel.Close()
+				}
+			}
+		}()
+	}
+}
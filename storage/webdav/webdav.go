@@ -0,0 +1,426 @@
+package webdav
+
+import (
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	pathutil "path"
+	"strings"
+	"time"
+
+	"github.com/dustin/go-humanize"
+	log "github.com/sirupsen/logrus"
+	"github.com/yingce/drone-oss-cache/lib/cache/storage"
+	"github.com/yingce/drone-oss-cache/lib/cache/storage/transport"
+)
+
+// propfind asks for the properties List needs.
+const propfind = `<?xml version="1.0" encoding="utf-8"?>
+<propfind xmlns="DAV:"><prop><resourcetype/><getcontentlength/><getlastmodified/></prop></propfind>`
+
+// Options contains configuration for the WebDAV connection.
+type Options struct {
+	// Server is the base URL paths are relative to, e.g.
+	// https://artifactory.example.com/artifactory/cache
+	Server string
+
+	// Username and Password are sent with basic auth
+	Username string
+	Password string
+
+	// Token is sent as bearer token instead of basic auth
+	Token string
+
+	// Headers are added to every request
+	Headers map[string]string
+
+	// CreateDirs creates missing parent collections with MKCOL before
+	// uploads, plain WebDAV servers need it
+	CreateDirs bool
+
+	// CACert and CACertPath are PEM encoded CAs trusted in addition to the
+	// system CAs, InsecureSkipVerify accepts any server certificate
+	CACert             string
+	CACertPath         string
+	InsecureSkipVerify bool
+}
+
+type webdavStorage struct {
+	client *http.Client
+	base   *url.URL
+	opts   *Options
+}
+
+// New method creates an implementation of Storage with a WebDAV or plain
+// HTTP server as the backend.
+func New(opts *Options) (storage.Storage, error) {
+	if len(opts.Server) == 0 {
+		return nil, errors.New("No server specified")
+	}
+
+	base, err := url.Parse(strings.TrimSuffix(opts.Server, "/"))
+	if err != nil {
+		return nil, err
+	}
+
+	if base.Scheme != "http" && base.Scheme != "https" {
+		return nil, fmt.Errorf("Invalid server %s. Needs to be a HTTP URI", opts.Server)
+	}
+
+	tr, err := transport.New(&transport.Options{
+		CACert:             opts.CACert,
+		CACertPath:         opts.CACertPath,
+		InsecureSkipVerify: opts.InsecureSkipVerify,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return &webdavStorage{
+		client: &http.Client{Transport: tr},
+		base:   base,
+		opts:   opts,
+	}, nil
+}
+
+func (s *webdavStorage) Get(p string, dst io.Writer) error {
+	u := s.url(p)
+
+	log.Infof("Retrieving file at %s", u)
+
+	resp, err := s.do(http.MethodGet, u, nil, nil)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if err := checkResponse(resp, http.StatusOK); err != nil {
+		return err
+	}
+
+	log.Infof("Copying object from the server")
+
+	numBytes, err := io.Copy(dst, resp.Body)
+
+	if err != nil {
+		return err
+	}
+
+	log.Infof("Downloaded %s from server", humanize.Bytes(uint64(numBytes)))
+
+	return nil
+}
+
+func (s *webdavStorage) Put(p string, src io.Reader) error {
+	u := s.url(p)
+
+	log.Infof("Uploading to %s", u)
+
+	if s.opts.CreateDirs {
+		if err := s.mkcol(pathutil.Dir(s.clean(p))); err != nil {
+			return err
+		}
+	}
+
+	cr := &countingReader{r: src}
+
+	resp, err := s.do(http.MethodPut, u, cr, map[string]string{
+		"Content-Type": "application/tar",
+	})
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if err := checkResponse(resp, http.StatusOK, http.StatusCreated, http.StatusNoContent); err != nil {
+		return err
+	}
+
+	log.Infof("Uploaded %s to server", humanize.Bytes(uint64(cr.n)))
+
+	return nil
+}
+
+// List walks the collections with PROPFIND, one level at a time since many
+// servers refuse an infinite depth.
+func (s *webdavStorage) List(p string) ([]storage.FileEntry, error) {
+	log.Infof("Retrieving files at %s", s.url(p))
+
+	var files []storage.FileEntry
+	dirs := []string{s.clean(p)}
+
+	for len(dirs) > 0 {
+		dir := dirs[0]
+		dirs = dirs[1:]
+
+		entries, err := s.propfind(dir)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, e := range entries {
+			if e.path == dir {
+				continue
+			}
+
+			if e.collection {
+				dirs = append(dirs, e.path)
+				continue
+			}
+
+			files = append(files, storage.FileEntry{
+				Path:         e.path,
+				Size:         e.size,
+				LastModified: e.lastModified,
+			})
+			log.Debugf("Found file %s: Size=%d LastModified=%s", e.path, e.size, e.lastModified)
+		}
+	}
+
+	log.Infof("Found %d files at %s", len(files), s.url(p))
+
+	return files, nil
+}
+
+func (s *webdavStorage) Exists(p string) (bool, error) {
+	resp, err := s.do(http.MethodHead, s.url(p), nil, nil)
+	if err != nil {
+		return false, err
+	}
+	defer resp.Body.Close()
+
+	if err := checkResponse(resp, http.StatusOK); err != nil {
+		if errors.Is(err, storage.ErrNotFound) {
+			return false, nil
+		}
+		return false, err
+	}
+
+	return true, nil
+}
+
+func (s *webdavStorage) Delete(p string) error {
+	u := s.url(p)
+
+	log.Infof("Deleting %s", u)
+
+	resp, err := s.do(http.MethodDelete, u, nil, nil)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	return checkResponse(resp, http.StatusOK, http.StatusAccepted, http.StatusNoContent)
+}
+
+// Copy uses the WebDAV COPY method and falls back to downloading and
+// uploading the file on plain HTTP servers.
+func (s *webdavStorage) Copy(src, dst string) error {
+	log.Infof("Copying %s to %s", s.url(src), s.url(dst))
+
+	if s.opts.CreateDirs {
+		if err := s.mkcol(pathutil.Dir(s.clean(dst))); err != nil {
+			return err
+		}
+	}
+
+	resp, err := s.do("COPY", s.url(src), nil, map[string]string{
+		"Destination": s.url(dst),
+		"Overwrite":   "T",
+	})
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	switch resp.StatusCode {
+	case http.StatusMethodNotAllowed, http.StatusNotImplemented:
+		log.Infof("Server does not support COPY, copying through the client")
+	default:
+		return checkResponse(resp, http.StatusCreated, http.StatusNoContent)
+	}
+
+	reader, writer := io.Pipe()
+
+	go func() {
+		writer.CloseWithError(s.Get(src, writer))
+	}()
+
+	err = s.Put(dst, reader)
+	reader.CloseWithError(err)
+
+	return err
+}
+
+// mkcol creates the collection at dir and its parents.
+func (s *webdavStorage) mkcol(dir string) error {
+	if dir == "" || dir == "." || dir == "/" {
+		return nil
+	}
+
+	if err := s.mkcol(pathutil.Dir(dir)); err != nil {
+		return err
+	}
+
+	resp, err := s.do("MKCOL", s.url(dir), nil, nil)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	// 405 means it exists already
+	return checkResponse(resp, http.StatusCreated, http.StatusOK, http.StatusMethodNotAllowed)
+}
+
+type davEntry struct {
+	path         string
+	collection   bool
+	size         int64
+	lastModified time.Time
+}
+
+func (s *webdavStorage) propfind(dir string) ([]davEntry, error) {
+	u := s.url(dir)
+	if !strings.HasSuffix(u, "/") {
+		u += "/"
+	}
+
+	resp, err := s.do("PROPFIND", u, strings.NewReader(propfind), map[string]string{
+		"Depth":        "1",
+		"Content-Type": "application/xml; charset=utf-8",
+	})
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if err := checkResponse(resp, http.StatusMultiStatus); err != nil {
+		return nil, err
+	}
+
+	var ms multistatus
+	if err := xml.NewDecoder(resp.Body).Decode(&ms); err != nil {
+		return nil, err
+	}
+
+	var entries []davEntry
+
+	for _, r := range ms.Responses {
+		href, err := url.Parse(r.Href)
+		if err != nil {
+			return nil, err
+		}
+
+		e := davEntry{path: s.relative(href.Path)}
+
+		for _, ps := range r.Propstats {
+			if !strings.Contains(ps.Status, " 200 ") {
+				continue
+			}
+
+			e.collection = ps.Prop.ResourceType.Collection != nil
+			e.size = ps.Prop.ContentLength
+
+			if ps.Prop.LastModified != "" {
+				if e.lastModified, err = http.ParseTime(ps.Prop.LastModified); err != nil {
+					return nil, fmt.Errorf("Invalid last modified %s of %s", ps.Prop.LastModified, r.Href)
+				}
+			}
+		}
+
+		entries = append(entries, e)
+	}
+
+	return entries, nil
+}
+
+// clean returns p without leading or trailing slashes.
+func (s *webdavStorage) clean(p string) string {
+	return strings.TrimPrefix(pathutil.Clean("/"+p), "/")
+}
+
+// relative returns the path of a server path below the base URL, like the
+// paths passed in.
+func (s *webdavStorage) relative(p string) string {
+	return s.clean(strings.TrimPrefix(p, s.base.Path))
+}
+
+func (s *webdavStorage) url(p string) string {
+	u := *s.base
+	u.Path = s.base.Path + "/" + s.clean(p)
+	return u.String()
+}
+
+func (s *webdavStorage) do(method, u string, body io.Reader, header map[string]string) (*http.Response, error) {
+	req, err := http.NewRequest(method, u, body)
+	if err != nil {
+		return nil, err
+	}
+
+	for k, v := range s.opts.Headers {
+		req.Header.Set(k, v)
+	}
+
+	for k, v := range header {
+		req.Header.Set(k, v)
+	}
+
+	if s.opts.Token != "" {
+		req.Header.Set("Authorization", "Bearer "+s.opts.Token)
+	} else if s.opts.Username != "" {
+		req.SetBasicAuth(s.opts.Username, s.opts.Password)
+	}
+
+	return s.client.Do(req)
+}
+
+// checkResponse maps unexpected responses to errors, 404 to
+// storage.ErrNotFound.
+func checkResponse(resp *http.Response, codes ...int) error {
+	for _, code := range codes {
+		if resp.StatusCode == code {
+			return nil
+		}
+	}
+
+	body, _ := ioutil.ReadAll(io.LimitReader(resp.Body, 1024))
+	err := fmt.Errorf("%s %s: %s %s", resp.Request.Method, resp.Request.URL.Path, resp.Status, strings.TrimSpace(string(body)))
+
+	if resp.StatusCode == http.StatusNotFound {
+		return fmt.Errorf("%w: %s", storage.ErrNotFound, err)
+	}
+
+	return err
+}
+
+type multistatus struct {
+	Responses []struct {
+		Href      string `xml:"DAV: href"`
+		Propstats []struct {
+			Status string `xml:"DAV: status"`
+			Prop   struct {
+				ResourceType struct {
+					Collection *struct{} `xml:"DAV: collection"`
+				} `xml:"DAV: resourcetype"`
+				ContentLength int64  `xml:"DAV: getcontentlength"`
+				LastModified  string `xml:"DAV: getlastmodified"`
+			} `xml:"DAV: prop"`
+		} `xml:"DAV: propstat"`
+	} `xml:"DAV: response"`
+}
+
+// countingReader counts the bytes read through it.
+type countingReader struct {
+	r io.Reader
+	n int64
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	c.n += int64(n)
+	return n, err
+}
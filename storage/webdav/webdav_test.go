@@ -0,0 +1,108 @@
+package webdav
+
+import (
+	"encoding/pem"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/franela/goblin"
+	"github.com/yingce/drone-oss-cache/lib/cache/storage"
+	"github.com/yingce/drone-oss-cache/lib/cache/storage/storagetest"
+	"golang.org/x/net/webdav"
+)
+
+func TestWebDAV(t *testing.T) {
+	g := goblin.Goblin(t)
+
+	g.Describe("webdav storage", func() {
+		var ts *httptest.Server
+		var header http.Header
+		var methods []string
+		var s storage.Storage
+
+		g.BeforeEach(func() {
+			dav := &webdav.Handler{
+				Prefix:     "/repo",
+				FileSystem: webdav.NewMemFS(),
+				LockSystem: webdav.NewMemLS(),
+			}
+
+			methods = nil
+
+			ts = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				header = r.Header
+				methods = append(methods, r.Method+" "+r.URL.Path)
+				dav.ServeHTTP(w, r)
+			}))
+
+			var err error
+			s, err = New(&Options{
+				Server:     ts.URL + "/repo/",
+				Token:      "secret",
+				Headers:    map[string]string{"X-Custom": "value"},
+				CreateDirs: true,
+			})
+			if err != nil {
+				g.Fail(err)
+			}
+		})
+
+		g.AfterEach(func() {
+			ts.Close()
+		})
+
+		g.It("Should send auth and custom headers", func() {
+			s.Exists("bucket/a.tar")
+
+			g.Assert(header.Get("Authorization")).Equal("Bearer secret")
+			g.Assert(header.Get("X-Custom")).Equal("value")
+		})
+
+		g.It("Should create the collections of a file", func() {
+			err := s.Put("bucket/owner/archive.tar", strings.NewReader("archive"))
+			g.Assert(err == nil).IsTrue("failed to put")
+
+			g.Assert(methods).Equal([]string{
+				"MKCOL /repo/bucket",
+				"MKCOL /repo/bucket/owner",
+				"PUT /repo/bucket/owner/archive.tar",
+			})
+		})
+
+		g.It("Should trust the ca cert of the server", func() {
+			tls := httptest.NewTLSServer(ts.Config.Handler)
+			defer tls.Close()
+
+			cert := string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: tls.Certificate().Raw}))
+
+			untrusted, _ := New(&Options{Server: tls.URL + "/repo"})
+			_, err := untrusted.Exists("bucket/a.tar")
+			g.Assert(err != nil).IsTrue("expected a certificate error")
+
+			trusted, err := New(&Options{Server: tls.URL + "/repo", CACert: cert})
+			g.Assert(err == nil).IsTrue("failed to create storage")
+
+			_, err = trusted.Exists("bucket/a.tar")
+			g.Assert(err == nil).IsTrue("failed to connect over tls")
+		})
+
+		g.It("Should report the reason of a failed copy", func() {
+			full := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				http.Error(w, "no space left", http.StatusInsufficientStorage)
+			}))
+			defer full.Close()
+
+			for _, createDirs := range []bool{false, true} {
+				s, _ = New(&Options{Server: full.URL, CreateDirs: createDirs})
+
+				err := s.Copy("bucket/a.tar", "bucket/master/a.tar")
+				g.Assert(err != nil).IsTrue("expected an error")
+				g.Assert(strings.Contains(err.Error(), "no space left")).IsTrue(err.Error())
+			}
+		})
+
+		storagetest.Run(g, func() storage.Storage { return s })
+	})
+}